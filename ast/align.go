@@ -0,0 +1,271 @@
+package ast
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// sub returns what f writes to a builder sharing the state of s.
+func (s *builder) sub(f func(b *builder)) string {
+	str := &strings.Builder{}
+	f(&builder{Out: str, Indent: s.Indent, Options: s.Options})
+	return str.String()
+}
+
+// padding returns the spaces needed to widen str to width columns.
+func padding(str string, width int) string {
+	if n := width - utf8.RuneCountInString(str); n > 0 {
+		return strings.Repeat(" ", n)
+	}
+	return ""
+}
+
+// alignWidths groups n consecutive items and returns the width each item's
+// left-hand side is padded to, or 0 for items that are not aligned. width
+// reports the width of an item and whether it can be aligned at all; brk
+// reports whether a new group starts at an item.
+func alignWidths(n int, width func(i int) (int, bool), brk func(i int) bool) []int {
+	widths := make([]int, n)
+	start, max := 0, 0
+	flush := func(end int) {
+		for j := start; j < end; j++ {
+			widths[j] = max
+		}
+	}
+	for i := 0; i < n; i++ {
+		w, ok := width(i)
+		if !ok || brk(i) {
+			flush(i)
+			start, max = i, 0
+		}
+		if !ok {
+			start = i + 1
+			continue
+		}
+		if w > max {
+			max = w
+		}
+	}
+	flush(n)
+	return widths
+}
+
+// blankBetween reports whether the source had a blank line between a node
+// ending on line prev and one starting on line next. Nodes without a known
+// position never do.
+func blankBetween(prev, next int) bool {
+	return prev > 0 && next > prev+1
+}
+
+// alignedChunk writes the statements of c aligning runs of assignments and
+// the trailing comments of runs of statements written on a single line.
+func (s *builder) alignedChunk(c Chunk) {
+	brk := func(i int) bool {
+		return i > 0 && blankBetween(endLine(c[i-1]), c[i].Line())
+	}
+	lhs := make([]string, len(c))
+	rhs := make([]string, len(c))
+	widths := alignWidths(len(c), func(i int) (int, bool) {
+		var ok bool
+		lhs[i], rhs[i], ok = s.assignParts(c[i])
+		return utf8.RuneCountInString(lhs[i]), ok
+	}, brk)
+
+	// lines holds the aligned assignments and the single-line statements
+	// with a comment, as written before the comment.
+	lines := make([]string, len(c))
+	for i, st := range c {
+		switch {
+		case widths[i] > 0:
+			lines[i] = lhs[i] + padding(lhs[i], widths[i]) + " = " + rhs[i] + ";"
+		case st.Comment() != "" && isSingleLine(st):
+			lines[i] = s.sub(func(b *builder) { b.stmtBody(st) }) + ";"
+		}
+	}
+	comments := alignWidths(len(c), func(i int) (int, bool) {
+		return utf8.RuneCountInString(lines[i]), lines[i] != "" && c[i].Comment() != ""
+	}, brk)
+
+	for i, st := range c {
+		// Blank lines end groups, so keep them to let the output align
+		// the same way when it is formatted again.
+		if brk(i) {
+			s.addln("")
+		}
+		if lines[i] == "" {
+			s.stmt(st)
+			continue
+		}
+		s.tab()
+		s.add(lines[i])
+		s.add(padding(lines[i], comments[i]))
+		s.comment(st.Comment())
+		s.add("\n")
+	}
+}
+
+// assignParts returns both sides of a single-line assignment.
+func (s *builder) assignParts(st Stmt) (lhs string, rhs string, ok bool) {
+	var exprs []Expr
+	switch stmt := st.(type) {
+	case *AssignStmt:
+		for _, ex := range stmt.Lhs {
+			if isMultiline(ex) {
+				return "", "", false
+			}
+		}
+		lhs = s.sub(func(b *builder) {
+			for i, ex := range stmt.Lhs {
+				b.expr(ex, data{})
+				b.addcomma(i, len(stmt.Lhs))
+			}
+		})
+		exprs = stmt.Rhs
+	case *LocalAssignStmt:
+		if len(stmt.Exprs) == 0 {
+			return "", "", false
+		}
+		lhs = "local " + strings.Join(stmt.Names, ", ")
+		exprs = stmt.Exprs
+	default:
+		return "", "", false
+	}
+	for _, ex := range exprs {
+		if isMultiline(ex) {
+			return "", "", false
+		}
+	}
+	rhs = s.sub(func(b *builder) {
+		for i, ex := range exprs {
+			b.expr(ex, data{})
+			b.addcomma(i, len(exprs))
+		}
+	})
+	return lhs, rhs, true
+}
+
+// fieldWidths returns the widths the keys of fields are padded to.
+func (s *builder) fieldWidths(fields []*Field, d data) []int {
+	return alignWidths(len(fields), func(i int) (int, bool) {
+		field := fields[i]
+		if field.Key == nil || !isSingleLineField(field) {
+			return 0, false
+		}
+		return utf8.RuneCountInString(s.fieldKey(field, d)), true
+	}, func(i int) bool {
+		return i > 0 && blankBeforeField(fields, i)
+	})
+}
+
+// commentWidths returns the widths the fields are padded to before their
+// trailing comments, given the widths their keys are padded to.
+func (s *builder) commentWidths(fields []*Field, keys []int, d data) []int {
+	return alignWidths(len(fields), func(i int) (int, bool) {
+		field := fields[i]
+		if field.Comment == "" || !isSingleLineField(field) {
+			return 0, false
+		}
+		str := s.sub(func(b *builder) { b.field(field, keys[i], i == len(fields)-1, d) })
+		return utf8.RuneCountInString(str), true
+	}, func(i int) bool {
+		return i > 0 && blankBeforeField(fields, i)
+	})
+}
+
+// blankBeforeField reports whether the source had a blank line before fields[i].
+func blankBeforeField(fields []*Field, i int) bool {
+	prev := fields[i-1].Value
+	return !isMultiline(prev) && blankBetween(prev.Line(), fields[i].Value.Line())
+}
+
+// endLine returns the last source line of st, or 0 if it is not known.
+func endLine(st Stmt) int {
+	for _, ex := range stmtExprs(st) {
+		if isMultiline(ex) { // The parser does not record where expressions end
+			return 0
+		}
+	}
+	if st.LastLine() > st.Line() {
+		return st.LastLine()
+	}
+	return st.Line()
+}
+
+// stmtExprs returns the expressions of the statements without a block.
+func stmtExprs(st Stmt) []Expr {
+	var exprs []Expr
+	switch stmt := st.(type) {
+	case *AssignStmt:
+		exprs = append(exprs, stmt.Lhs...)
+		exprs = append(exprs, stmt.Rhs...)
+	case *CompoundAssignStmt:
+		exprs = append(exprs, stmt.Lhs...)
+		exprs = append(exprs, stmt.Rhs...)
+	case *LocalAssignStmt:
+		exprs = stmt.Exprs
+	case *FuncCallStmt:
+		exprs = []Expr{stmt.Expr}
+	case *ReturnStmt:
+		exprs = stmt.Exprs
+	}
+	return exprs
+}
+
+// isSingleLine reports whether the formatter writes st on a single line.
+func isSingleLine(st Stmt) bool {
+	switch st.(type) {
+	case *AssignStmt, *CompoundAssignStmt, *LocalAssignStmt, *FuncCallStmt,
+		*ReturnStmt, *BreakStmt, *ContinueStmt, *LabelStmt, *GotoStmt:
+	default:
+		return false
+	}
+	for _, ex := range stmtExprs(st) {
+		if isMultiline(ex) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSingleLineField reports whether the formatter writes field on a single
+// line.
+func isSingleLineField(field *Field) bool {
+	return !(field.Key != nil && isMultiline(field.Key)) && !isMultiline(field.Value)
+}
+
+// isMultiline reports whether the formatter writes ex over several lines.
+func isMultiline(ex Expr) bool {
+	switch e := ex.(type) {
+	case *FunctionExpr:
+		return true
+	case *TableExpr:
+		return len(e.Fields) > 0
+	case *AttrGetExpr:
+		return isMultiline(e.Object) || isMultiline(e.Key)
+	case *FuncCallExpr:
+		for _, arg := range e.Args {
+			if isMultiline(arg) {
+				return true
+			}
+		}
+		if e.Func != nil {
+			return isMultiline(e.Func)
+		}
+		return isMultiline(e.Receiver)
+	case *LogicalOpExpr:
+		return isMultiline(e.Lhs) || isMultiline(e.Rhs)
+	case *RelationalOpExpr:
+		return isMultiline(e.Lhs) || isMultiline(e.Rhs)
+	case *StringConcatOpExpr:
+		return isMultiline(e.Lhs) || isMultiline(e.Rhs)
+	case *ArithmeticOpExpr:
+		return isMultiline(e.Lhs) || isMultiline(e.Rhs)
+	case *UnaryOpExpr:
+		return isMultiline(e.Expr)
+	case *StringExpr:
+		// Long bracket literals keep their newlines, in the source and
+		// with Quote.Long in the output.
+		return strings.Contains(e.Value, "\n")
+	}
+	return false
+}
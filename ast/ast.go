@@ -3,12 +3,15 @@ package ast
 type PositionHolder interface {
 	Line() int
 	SetLine(int)
+	Column() int
+	SetColumn(int)
 	LastLine() int
 	SetLastLine(int)
 }
 
 type Node struct {
 	line     int
+	column   int
 	lastline int
 }
 
@@ -20,10 +23,20 @@ func (n *Node) SetLine(line int) {
 	n.line = line
 }
 
+// Column returns the column in bytes, counting from 1, of the first token
+// of the node, or 0 if it is not known.
+func (n *Node) Column() int {
+	return n.column
+}
+
+func (n *Node) SetColumn(column int) {
+	n.column = column
+}
+
 func (n *Node) LastLine() int {
 	return n.lastline
 }
 
 func (n *Node) SetLastLine(line int) {
 	n.lastline = line
-}
\ No newline at end of file
+}
@@ -0,0 +1,55 @@
+package ast
+
+// AttachComments gives the trailing comments of a source, keyed by line, to
+// the statements and table fields of c that the formatter writes on a single
+// line. A comment goes to the last such statement or field ending on its
+// line; comments on other lines, such as the ones after then or end, are
+// dropped. Enclosing statements are considered before the ones they contain.
+func AttachComments(c Chunk, comments map[int]string) {
+	if len(comments) == 0 {
+		return
+	}
+	Inspect(c, func(node interface{}) bool {
+		switch n := node.(type) {
+		case Chunk:
+			for i, st := range n {
+				line := commentLine(st)
+				if line == 0 || i+1 < len(n) && n[i+1].Line() == line {
+					continue
+				}
+				if comment, ok := comments[line]; ok {
+					st.SetComment(comment)
+					delete(comments, line)
+				}
+			}
+		case *TableExpr:
+			for i, field := range n.Fields {
+				line := fieldLine(field)
+				if line == 0 || i+1 < len(n.Fields) && n.Fields[i+1].Value.Line() == line {
+					continue
+				}
+				if comment, ok := comments[line]; ok {
+					field.Comment = comment
+					delete(comments, line)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// commentLine returns the line of st if it is written on a single line, or 0.
+func commentLine(st Stmt) int {
+	if !isSingleLine(st) {
+		return 0
+	}
+	return st.Line()
+}
+
+// fieldLine returns the line of field if it is written on a single line, or 0.
+func fieldLine(field *Field) int {
+	if !isSingleLineField(field) {
+		return 0
+	}
+	return field.Value.Line()
+}
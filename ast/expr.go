@@ -41,6 +41,9 @@ type NumberExpr struct {
 	ConstExprBase
 
 	Value float64
+	// Float is set for float literals, such as 1.0 or 1e3, and unset for
+	// integers, such as 1 or 0xff.
+	Float bool
 }
 
 type StringExpr struct {
@@ -2,10 +2,10 @@ package ast
 
 import (
 	"fmt"
-	"strconv"
+	"io"
 	"strings"
 
-	"github.com/notnoobmaster/luautil"
+	luautil "github.com/hootrhino/beautiful-lua-go"
 )
 
 type data struct {
@@ -14,29 +14,66 @@ type data struct {
 	Parent     string
 }
 
+// Options control the layout chosen by the formatter. The zero value gives
+// the default layout used by String.
+type Options struct {
+	// Align pads the left-hand sides of runs of consecutive assignments and
+	// of keyed table fields so that their = line up. A run ends at a blank
+	// line in the source, which is kept in the output, at a multi-line value
+	// and at any other statement or field. The trailing comments of runs of
+	// statements or fields written on a single line are lined up the same
+	// way.
+	Align bool
+
+	// Quote selects how string literals are quoted.
+	Quote luautil.QuoteOptions
+}
+
+// writer is the destination of a builder, such as a *strings.Builder or a
+// *bufio.Writer.
+type writer interface {
+	io.Writer
+	io.StringWriter
+}
+
 type builder struct {
-	Str    *strings.Builder
+	Out    writer
+	Err    error // first error returned by Out; nothing is written after it
 	Indent int
+	Options
 }
 
 // Helper functions
-func (s *builder) add(str string)      { s.Str.WriteString(str) }
-func (s *builder) addln(str string)    { s.Str.WriteString(str + "\n") }
-func (s *builder) addrune(r rune)      { s.Str.WriteRune(r) }
-func (s *builder) addpad(str string)   { s.Str.WriteString(" " + str + " ") }
-func (s *builder) tab() *builder       { s.Str.WriteString(strings.Repeat("\t", s.Indent)); return s }
+func (s *builder) add(str string)      { s.write(str) }
+func (s *builder) addln(str string)    { s.write(str + "\n") }
+func (s *builder) addrune(r rune)      { s.write(string(r)) }
+func (s *builder) addpad(str string)   { s.write(" " + str + " ") }
+func (s *builder) tab() *builder       { s.write(strings.Repeat("\t", s.Indent)); return s }
 func (s *builder) wrap(e Expr, d data) { s.add("("); s.expr(e, d); s.add(")") }
 
+// comment writes the trailing comment str, if any, after the code on a line.
+func (s *builder) comment(str string) {
+	if str != "" {
+		s.add(" " + str)
+	}
+}
+
+func (s *builder) write(str string) {
+	if s.Err == nil {
+		_, s.Err = s.Out.WriteString(str)
+	}
+}
+
 func (s *builder) addcomma(idx int, length int) {
 	if idx < length-1 {
-		s.Str.WriteString(", ")
+		s.add(", ")
 	}
 }
 
 func (s *builder) expr(ex Expr, d data) {
 	switch e := ex.(type) {
 	case *NumberExpr:
-		s.add(strconv.FormatFloat(e.Value, 'f', -1, 64))
+		s.add(formatNumber(e))
 	case *NilExpr:
 		s.add("nil")
 	case *FalseExpr:
@@ -48,10 +85,10 @@ func (s *builder) expr(ex Expr, d data) {
 	case *Comma3Expr:
 		s.add("...")
 	case *StringExpr:
-		s.add(luautil.Quote(e.Value))
+		s.add(luautil.QuoteWith(e.Value, s.Quote))
 	case *AttrGetExpr:
 		switch obj := e.Object.(type) {
-		case *IdentExpr, *AttrGetExpr:
+		case *IdentExpr, *AttrGetExpr, *FuncCallExpr:
 			s.expr(e.Object, d)
 		case *StringExpr:
 			if obj.Value == "" {
@@ -75,22 +112,27 @@ func (s *builder) expr(ex Expr, d data) {
 		s.add("{")
 		s.Indent++
 		length := len(e.Fields)
+		widths, comments := make([]int, length), make([]int, length)
+		if s.Align {
+			widths = s.fieldWidths(e.Fields, d)
+			comments = s.commentWidths(e.Fields, widths, d)
+		}
 		for idx, field := range e.Fields {
+			if s.Align && idx > 0 && blankBeforeField(e.Fields, idx) {
+				s.addln("")
+			}
 			s.addln("")
 			s.tab()
-			if field.Key != nil {
-				if str, ok := field.Key.(*StringExpr); ok && isValid(str.Value) && !isReserved(str.Value) {
-					s.add(str.Value)
-				} else {
-					s.add("[")
-					s.expr(field.Key, d)
-					s.add("]")
-				}
-				s.add(" = ")
+			last := idx == length-1
+			if comments[idx] > 0 {
+				str := s.sub(func(b *builder) { b.field(field, widths[idx], last, d) })
+				s.add(str)
+				s.add(padding(str, comments[idx]))
+			} else {
+				s.field(field, widths[idx], last, d)
 			}
-			s.expr(field.Value, d)
-			if idx < length-1 {
-				s.Str.WriteRune(',')
+			s.comment(field.Comment)
+			if !last {
 				continue
 			}
 			s.addln("")
@@ -140,16 +182,20 @@ func (s *builder) expr(ex Expr, d data) {
 			s.expr(e.Expr, data{Precedence: 11})
 		}
 	case *FuncCallExpr:
+		if e.AdjustRet { // (hoge()) truncates the results to one value
+			s.add("(")
+			defer s.add(")")
+		}
 		if e.Func != nil { // hoge.func()
 			switch e.Func.(type) {
-			case *IdentExpr, *AttrGetExpr:
+			case *IdentExpr, *AttrGetExpr, *FuncCallExpr:
 				s.expr(e.Func, d)
 			default:
 				s.wrap(e.Func, d)
 			}
 		} else { // hoge:method()
 			switch e.Receiver.(type) {
-			case *IdentExpr, *AttrGetExpr:
+			case *IdentExpr, *AttrGetExpr, *FuncCallExpr:
 				s.expr(e.Receiver, data{})
 			default:
 				s.wrap(e.Receiver, data{})
@@ -201,14 +247,49 @@ func (s *builder) elseBody(elseStmt []Stmt) {
 
 func (b *builder) chunk(c Chunk) {
 	b.Indent++
-	for _, s := range c {
-		b.stmt(s)
+	if b.Align {
+		b.alignedChunk(c)
+	} else {
+		for _, s := range c {
+			b.stmt(s)
+		}
 	}
 	b.Indent--
 }
 
+// field writes a table field with its key padded to width columns, followed
+// by a comma unless it is the last field of its table.
+func (s *builder) field(field *Field, width int, last bool, d data) {
+	if field.Key != nil {
+		key := s.fieldKey(field, d)
+		s.add(key)
+		s.add(padding(key, width))
+		s.add(" = ")
+	}
+	s.expr(field.Value, d)
+	if !last {
+		s.addrune(',')
+	}
+}
+
+// fieldKey returns the key of a table field as it is written before the =.
+func (s *builder) fieldKey(field *Field, d data) string {
+	if str, ok := field.Key.(*StringExpr); ok && isValid(str.Value) && !isReserved(str.Value) {
+		return str.Value
+	}
+	return "[" + s.sub(func(b *builder) { b.expr(field.Key, d) }) + "]"
+}
+
 func (s *builder) stmt(st Stmt) {
 	s.tab()
+	s.stmtBody(st)
+	s.add(";")
+	s.comment(st.Comment())
+	s.add("\n")
+}
+
+// stmtBody writes st without its indentation, terminator and comment.
+func (s *builder) stmtBody(st Stmt) {
 	switch stmt := st.(type) {
 	case *AssignStmt:
 		for i, ex := range stmt.Lhs {
@@ -247,14 +328,14 @@ func (s *builder) stmt(st Stmt) {
 		ex := stmt.Expr.(*FuncCallExpr)
 		if ex.Func != nil {
 			switch ex.Func.(type) {
-			case *IdentExpr, *AttrGetExpr:
+			case *IdentExpr, *AttrGetExpr, *FuncCallExpr:
 				s.expr(ex.Func, data{})
 			default:
 				s.wrap(ex.Func, data{})
 			}
 		} else {
 			switch ex.Receiver.(type) {
-			case *IdentExpr, *AttrGetExpr:
+			case *IdentExpr, *AttrGetExpr, *FuncCallExpr:
 				s.expr(ex.Receiver, data{})
 			default:
 				s.wrap(ex.Receiver, data{})
@@ -305,7 +386,7 @@ func (s *builder) stmt(st Stmt) {
 		s.add("function ")
 		if stmt.Name.Func == nil {
 			s.expr(stmt.Name.Receiver, data{})
-			s.Str.WriteRune(':')
+			s.addrune(':')
 			s.add(stmt.Name.Method)
 		} else {
 			s.expr(stmt.Name.Func, data{})
@@ -383,5 +464,4 @@ func (s *builder) stmt(st Stmt) {
 	default:
 		panic(fmt.Sprintf("unexpected statement kind: %T", stmt))
 	}
-	s.add(";\n")
 }
@@ -12,10 +12,10 @@ func isValid(str string) bool {
 
 func isReserved(str string) bool {
 	switch str {
-	case "and", "break", "do", " else", "elseif", 
-		"end", "false", "for", "function", "if", 
-		"in", "local", "nil", "not", "or", "repeat", 
-		"return", "then", " true", " until", "while":
+	case "and", "break", "continue", "do", "else", "elseif",
+		"end", "false", "for", "function", "goto", "if",
+		"in", "local", "nil", "not", "or", "repeat",
+		"return", "then", "true", "until", "while":
 		return true
 	}
 	return false
@@ -1,8 +1,9 @@
 package ast
 
 type Field struct {
-	Key   Expr
-	Value Expr
+	Key     Expr
+	Value   Expr
+	Comment string // trailing comment, such as "-- note", or ""
 }
 
 type ParList struct {
@@ -1,19 +1,65 @@
 package ast
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
-	"github.com/notnoobmaster/luautil"
+	luautil "github.com/hootrhino/beautiful-lua-go"
 )
 
-func (c Chunk) String() string {
-	s := &builder{
-		Str:    &strings.Builder{},
-		Indent: -1, // Accounting for the fact that each chunk call increments Indent by one
+// Fprint writes the formatted source of node to w using the layout chosen by
+// opts. node must be a Chunk, an Expr or a Stmt. The output is streamed
+// through a small buffer rather than built in memory, and the first error
+// returned by w is reported.
+func Fprint(w io.Writer, node interface{}, opts Options) error {
+	out := bufio.NewWriter(w)
+	s := &builder{Out: out, Options: opts}
+	if err := s.node(node); err != nil {
+		return err
+	}
+	if s.Err != nil {
+		return s.Err
 	}
-	s.chunk(c)
-	return s.Str.String()
+	return out.Flush()
+}
+
+// node writes node, which must be a Chunk, an Expr or a Stmt.
+func (s *builder) node(node interface{}) error {
+	switch n := node.(type) {
+	case Chunk:
+		s.Indent-- // Accounting for the fact that each chunk call increments Indent by one
+		s.chunk(n)
+		s.Indent++
+	case []Stmt:
+		return s.node(Chunk(n))
+	case Expr:
+		s.expr(n, data{})
+	case Stmt:
+		s.stmt(n)
+	default:
+		return fmt.Errorf("ast: cannot print node of type %T", node)
+	}
+	return nil
+}
+
+// sprint returns the formatted source of node using opts.
+func sprint(node interface{}, opts Options) string {
+	str := &strings.Builder{}
+	s := &builder{Out: str, Options: opts}
+	s.node(node)
+	return str.String()
+}
+
+func (c Chunk) String() string {
+	return c.Format(Options{})
+}
+
+// Format returns the formatted source of c using the layout chosen by opts.
+func (c Chunk) Format(opts Options) string {
+	return sprint(c, opts)
 }
 
 // Expressions
@@ -25,7 +71,17 @@ func (v *IdentExpr) String() string  { return v.Value }
 func (v *Comma3Expr) String() string { return "..." }
 
 func (v *NumberExpr) String() string {
-	return strconv.FormatFloat(v.Value, 'f', -1, 64)
+	return formatNumber(v)
+}
+
+// formatNumber returns the literal of v, with a ".0" suffix for a float
+// that would read back as an integer.
+func formatNumber(v *NumberExpr) string {
+	s := strconv.FormatFloat(v.Value, 'f', -1, 64)
+	if v.Float && strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
 }
 
 func (v *StringExpr) String() string {
@@ -35,159 +91,107 @@ func (v *StringExpr) String() string {
 // We pass the value to b.expr because we need to know the indentation level and carry some state.
 
 func (e *AttrGetExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *TableExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *FuncCallExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *LogicalOpExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *RelationalOpExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *StringConcatOpExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *ArithmeticOpExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *UnaryOpExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 func (e *FunctionExpr) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.expr(e, data{})
-	return b.Str.String()
+	return sprint(e, Options{})
 }
 
 // Statements
 
 func (s *AssignStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *CompoundAssignStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *LocalAssignStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *FuncCallStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *DoBlockStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *WhileStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *RepeatStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *IfStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *NumberForStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *GenericForStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *LocalFunctionStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *FunctionStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *ReturnStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *BreakStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *ContinueStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *LabelStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
 
 func (s *GotoStmt) String() string {
-	b := &builder{&strings.Builder{}, 0}
-	b.stmt(s)
-	return b.Str.String()
+	return sprint(s, Options{})
 }
@@ -0,0 +1,91 @@
+package ast_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+const input = `
+local t = {a = 1, b = function(x) return x * (2 + 3) end}
+while t.a < 10 do
+	t.a = t.a + 1
+end
+`
+
+// Helper function
+func parseString(str string, t *testing.T) ast.Chunk {
+	chunk, err := parse.Parse(strings.NewReader(str), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunk
+}
+
+func TestFprint(t *testing.T) {
+	chunk := parseString(input, t)
+
+	b := &bytes.Buffer{}
+	if err := ast.Fprint(b, chunk, ast.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != chunk.String() {
+		t.Errorf("Expected %s, got %s", chunk.String(), b.String())
+	}
+}
+
+func TestFprintNode(t *testing.T) {
+	chunk := parseString(input, t)
+	tests := []struct {
+		node     interface{}
+		expected string
+	}{
+		{chunk[1], "while t.a < 10 do\n\tt.a = t.a + 1;\nend;\n"},
+		{chunk[1].(*ast.WhileStmt).Condition, "t.a < 10"},
+		{chunk[0].(*ast.LocalAssignStmt).Exprs[0].(*ast.TableExpr).Fields[1].Value, "function(x)\n\treturn x * (2 + 3);\nend"},
+	}
+
+	for _, test := range tests {
+		b := &strings.Builder{}
+		if err := ast.Fprint(b, test.node, ast.Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, b.String())
+		}
+	}
+
+	if err := ast.Fprint(&strings.Builder{}, 1, ast.Options{}); err == nil {
+		t.Error("Expected error for unsupported node")
+	}
+}
+
+type failingWriter struct {
+	written int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	return 0, errWrite
+}
+
+func TestFprintError(t *testing.T) {
+	var chunk ast.Chunk
+	for i := 0; i < 1000; i++ {
+		chunk = append(chunk, parseString(input, t)...)
+	}
+
+	w := &failingWriter{}
+	if err := ast.Fprint(w, chunk, ast.Options{}); err != errWrite {
+		t.Errorf("Expected %v, got %v", errWrite, err)
+	}
+	if w.written > 4096 {
+		t.Errorf("Expected output to stop after the first failed write, %d bytes written", w.written)
+	}
+}
@@ -0,0 +1,322 @@
+// Package query selects nodes of a lua syntax tree with a compact selector
+// syntax modelled on CSS:
+//
+//	FuncCallExpr[Func=ident("require")] > StringExpr
+//
+// selects the string arguments of calls of require.
+//
+// A selector is a list of compound selectors separated by combinators. A
+// space selects descendants of the nodes on its left and > selects their
+// children. Blocks and table fields are not nodes of their own: the
+// statements of a while loop are children of the *ast.WhileStmt and the
+// keys and values of a table constructor are children of the
+// *ast.TableExpr. Several selectors can be joined with commas.
+//
+// A compound selector starts with the name of a node type, such as
+// IfStmt, Expr for any expression, Stmt for any statement or * for any
+// node. It is followed by any number of attribute filters in brackets:
+//
+//	[Field]          the field is set: not nil, empty, zero or false
+//	[Field=value]    the field equals value
+//	[Field!=value]   the field does not equal value
+//	[Field^=value]   the field starts with the string value
+//	[Field$=value]   the field ends with the string value
+//	[Field*=value]   the field contains the string value
+//
+// Fields are the exported fields of the node type, like Operator or Args,
+// or its methods without arguments, like Line or String. A path like
+// Func.Value or Args.0 selects a field of a field or an element of a list.
+//
+// A value is a quoted lua string, a number, true, false or nil, a node
+// type such as AttrGetExpr, or one of ident("name"), string("text") and
+// number(1) for an identifier, string or number with the given value.
+// A string is compared to a field holding a node by the lua code of the
+// node, so [Func="os.execute"] matches calls of os.execute.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	luautil "github.com/hootrhino/beautiful-lua-go"
+)
+
+// Query is a compiled query.
+type Query struct {
+	src       string
+	selectors []selector
+}
+
+// selector is a chain of compound selectors. combinators[i] joins
+// compounds[i] and compounds[i+1].
+type selector struct {
+	compounds   []compound
+	combinators []byte // ' ' or '>'
+}
+
+type compound struct {
+	typ     string // "*" for any node
+	filters []filter
+}
+
+type filter struct {
+	path  []string
+	op    string // "" if the field only has to be set
+	value value
+}
+
+type value struct {
+	kind string // "string", "number", "bool", "nil", "type" or "ctor"
+	str  string
+	num  float64
+	bool bool
+	ctor string // for kind "ctor": ident, string or number
+}
+
+// Compile parses a query.
+func Compile(src string) (*Query, error) {
+	p := &parser{src: src}
+	q := &Query{src: src}
+	for {
+		sel, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		q.selectors = append(q.selectors, sel)
+		p.space()
+		if p.eof() {
+			return q, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("unexpected %q", p.src[p.pos])
+		}
+	}
+}
+
+// MustCompile is like Compile but panics if the query cannot be parsed.
+func MustCompile(src string) *Query {
+	q, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the source of the query.
+func (q *Query) String() string { return q.src }
+
+// Error is a syntax error in a query.
+type Error struct {
+	Offset int // byte offset in the query, counting from 0
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query: offset %d: %s", e.Offset, e.Msg)
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.src) }
+
+// space skips white space and reports whether there was any.
+func (p *parser) space() bool {
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *parser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *parser) ident() string {
+	start := p.pos
+	for !p.eof() && isIdent(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) selector() (selector, error) {
+	var sel selector
+	p.space()
+	for {
+		c, err := p.compound()
+		if err != nil {
+			return sel, err
+		}
+		sel.compounds = append(sel.compounds, c)
+
+		space := p.space()
+		switch {
+		case p.eof() || p.src[p.pos] == ',':
+			return sel, nil
+		case p.consume(">"):
+			p.space()
+			sel.combinators = append(sel.combinators, '>')
+		case space:
+			sel.combinators = append(sel.combinators, ' ')
+		default:
+			return sel, p.errorf("unexpected %q", p.src[p.pos])
+		}
+	}
+}
+
+func (p *parser) compound() (compound, error) {
+	var c compound
+	if p.consume("*") {
+		c.typ = "*"
+	} else if c.typ = p.ident(); c.typ == "" {
+		return c, p.errorf("node type expected")
+	} else if !isType(c.typ) {
+		p.pos -= len(c.typ)
+		return c, p.errorf("unknown node type %s", c.typ)
+	}
+
+	for p.consume("[") {
+		p.space()
+		var f filter
+		for {
+			name := p.ident()
+			if name == "" {
+				return c, p.errorf("field name expected")
+			}
+			f.path = append(f.path, name)
+			if !p.consume(".") {
+				break
+			}
+		}
+		p.space()
+		for _, op := range []string{"!=", "^=", "$=", "*=", "="} {
+			if p.consume(op) {
+				f.op = op
+				break
+			}
+		}
+		if f.op != "" {
+			p.space()
+			v, err := p.value()
+			if err != nil {
+				return c, err
+			}
+			if v.kind != "string" && f.op != "=" && f.op != "!=" {
+				return c, p.errorf("%s needs a string", f.op)
+			}
+			f.value = v
+			p.space()
+		}
+		if !p.consume("]") {
+			return c, p.errorf("] expected")
+		}
+		c.filters = append(c.filters, f)
+	}
+	return c, nil
+}
+
+func (p *parser) value() (value, error) {
+	if p.eof() {
+		return value{}, p.errorf("value expected")
+	}
+	switch ch := p.src[p.pos]; {
+	case ch == '"' || ch == '\'':
+		s, err := p.string()
+		return value{kind: "string", str: s}, err
+	case ch == '-' || isDecimal(ch):
+		n, err := p.number()
+		return value{kind: "number", num: n}, err
+	}
+
+	start := p.pos
+	name := p.ident()
+	switch name {
+	case "":
+		return value{}, p.errorf("value expected")
+	case "true", "false":
+		return value{kind: "bool", bool: name == "true"}, nil
+	case "nil":
+		return value{kind: "nil"}, nil
+	case "ident", "string", "number":
+		if !p.consume("(") {
+			return value{}, p.errorf("( expected")
+		}
+		p.space()
+		v := value{kind: "ctor", ctor: name}
+		var err error
+		if name == "number" {
+			v.num, err = p.number()
+		} else {
+			v.str, err = p.string()
+		}
+		if err != nil {
+			return v, err
+		}
+		p.space()
+		if !p.consume(")") {
+			return v, p.errorf(") expected")
+		}
+		return v, nil
+	}
+	if !isType(name) {
+		p.pos = start
+		return value{}, p.errorf("unknown value %s", name)
+	}
+	return value{kind: "type", str: name}, nil
+}
+
+func (p *parser) string() (string, error) {
+	if p.eof() || p.src[p.pos] != '"' && p.src[p.pos] != '\'' {
+		return "", p.errorf("string expected")
+	}
+	quote := p.src[p.pos]
+	end := p.pos + 1
+	for ; end < len(p.src) && p.src[end] != quote; end++ {
+		if p.src[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(p.src) {
+		return "", p.errorf("unterminated string")
+	}
+	s, err := luautil.Unquote(p.src[p.pos : end+1])
+	if err != nil {
+		e := err.(*luautil.UnquoteError)
+		return "", &Error{Offset: p.pos + e.Offset, Msg: e.Msg}
+	}
+	p.pos = end + 1
+	return s, nil
+}
+
+func (p *parser) number() (float64, error) {
+	start := p.pos
+	p.consume("-")
+	for !p.eof() && (isIdent(p.src[p.pos]) || p.src[p.pos] == '.') {
+		p.pos++
+	}
+	n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("invalid number")
+	}
+	return n, nil
+}
+
+func isIdent(ch byte) bool {
+	return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || isDecimal(ch)
+}
+
+func isDecimal(ch byte) bool { return '0' <= ch && ch <= '9' }
@@ -0,0 +1,102 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+const src = `local socket = require("socket")
+local http = require "socket.http"
+local x = -1
+os.execute("rm " .. name)
+while true do
+	if x > 0 then break end
+	local t = {a = "b", os.getenv("HOME")}
+end
+`
+
+func sel(t *testing.T, query string) []string {
+	t.Helper()
+	chunk, err := parse.Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Select(chunk, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, r := range results {
+		out = append(out, fmt.Sprintf("%d:%d:%s", r.Line, r.Column, firstLine(r.Node)))
+	}
+	return out
+}
+
+func firstLine(n ast.PositionHolder) string {
+	s := n.(interface{ String() string }).String()
+	return strings.SplitN(s, "\n", 2)[0]
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`FuncCallExpr[Func=ident("require")] > StringExpr`, []string{
+			`1:24:"socket"`, `2:22:"socket.http"`,
+		}},
+		{`FuncCallExpr[Func="os.execute"]`, []string{`4:1:os.execute("rm " .. name)`}},
+		{`FuncCallExpr[Func^="os."]`, []string{`4:1:os.execute("rm " .. name)`, `7:22:os.getenv("HOME")`}},
+		{`WhileStmt > IfStmt > BreakStmt`, []string{`6:16:break;`}},
+		{`WhileStmt > BreakStmt`, nil},
+		{`WhileStmt BreakStmt, UnaryOpExpr`, []string{`3:11:-1`, `6:16:break;`}},
+		{`TableExpr > StringExpr[Value="b"]`, []string{`7:17:"b"`}},
+		{`LocalAssignStmt[Names.0="x"] NumberExpr[Value=1]`, []string{`3:12:1`}},
+		{`FuncCallExpr[Args.0=StringConcatOpExpr]`, []string{`4:1:os.execute("rm " .. name)`}},
+		{`FuncCallExpr[Args.0=string("HOME")][Line=7]`, []string{`7:22:os.getenv("HOME")`}},
+		{`RelationalOpExpr[Operator!="=="][Rhs=number(0)]`, []string{`6:5:x > 0`}},
+		{`IfStmt[Else]`, nil},
+		{`Stmt[String*="http"]`, []string{`2:1:local http = require("socket.http");`}},
+	}
+
+	for _, test := range tests {
+		got := sel(t, test.query)
+		if strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s:\nExpected %q\nGot      %q", test.query, test.expected, got)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{``, 0},
+		{`Foo`, 0},
+		{`IfStmt[`, 7},
+		{`IfStmt[Else`, 11},
+		{`IfStmt[Else=]`, 12},
+		{`IfStmt[Else=Foo]`, 12},
+		{`IfStmt[Line^=1]`, 14},
+		{`IfStmt[Else=ident(1)]`, 18},
+		{`StringExpr[Value="\q"]`, 19},
+		{`IfStmt,`, 7},
+		{`IfStmt}`, 6},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.query)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: Expected *Error, got %v", test.query, err)
+			continue
+		}
+		if e.Offset != test.offset {
+			t.Errorf("%q: Expected error at %d, got %v", test.query, test.offset, e)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package query
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+)
+
+// types maps the name of each node type to an example of it.
+var types = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []ast.PositionHolder{
+		&ast.AssignStmt{}, &ast.CompoundAssignStmt{}, &ast.LocalAssignStmt{},
+		&ast.FuncCallStmt{}, &ast.DoBlockStmt{}, &ast.WhileStmt{},
+		&ast.RepeatStmt{}, &ast.IfStmt{}, &ast.NumberForStmt{},
+		&ast.GenericForStmt{}, &ast.LocalFunctionStmt{}, &ast.FunctionStmt{},
+		&ast.ReturnStmt{}, &ast.BreakStmt{}, &ast.ContinueStmt{},
+		&ast.LabelStmt{}, &ast.GotoStmt{},
+
+		&ast.TrueExpr{}, &ast.FalseExpr{}, &ast.NilExpr{}, &ast.NumberExpr{},
+		&ast.StringExpr{}, &ast.Comma3Expr{}, &ast.IdentExpr{},
+		&ast.AttrGetExpr{}, &ast.TableExpr{}, &ast.FuncCallExpr{},
+		&ast.LogicalOpExpr{}, &ast.RelationalOpExpr{},
+		&ast.StringConcatOpExpr{}, &ast.ArithmeticOpExpr{},
+		&ast.UnaryOpExpr{}, &ast.FunctionExpr{},
+	} {
+		t := reflect.TypeOf(node)
+		types[t.Elem().Name()] = t
+	}
+}
+
+func isType(name string) bool {
+	_, ok := types[name]
+	return ok || name == "Expr" || name == "Stmt"
+}
+
+// Result is a selected node.
+type Result struct {
+	Node   ast.PositionHolder // an ast.Stmt or ast.Expr
+	Line   int
+	Column int
+}
+
+// Select returns the nodes of chunk matching the query q in source order.
+func Select(chunk ast.Chunk, q string) ([]Result, error) {
+	query, err := Compile(q)
+	if err != nil {
+		return nil, err
+	}
+	return query.Select(chunk), nil
+}
+
+// Select returns the nodes of chunk matching q in source order. A node
+// matching several of the selectors of q is returned once.
+func (q *Query) Select(chunk ast.Chunk) []Result {
+	var results []Result
+	var ancestors []ast.PositionHolder
+	var stack []bool // whether each node visited was pushed onto ancestors
+	ast.Inspect(chunk, func(node interface{}) bool {
+		if node == nil {
+			if stack[len(stack)-1] {
+				ancestors = ancestors[:len(ancestors)-1]
+			}
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		n, ok := node.(ast.PositionHolder)
+		stack = append(stack, ok)
+		if !ok { // a block or a table field
+			return true
+		}
+		for _, sel := range q.selectors {
+			if sel.match(n, ancestors) {
+				results = append(results, Result{Node: n, Line: n.Line(), Column: n.Column()})
+				break
+			}
+		}
+		ancestors = append(ancestors, n)
+		return true
+	})
+	return results
+}
+
+// match reports whether n, whose ancestors are given from the root down,
+// matches the selector.
+func (sel *selector) match(n ast.PositionHolder, ancestors []ast.PositionHolder) bool {
+	last := len(sel.compounds) - 1
+	return sel.compounds[last].match(n) && sel.matchAncestors(last-1, ancestors)
+}
+
+// matchAncestors matches compounds[:i+1] against ancestors, where the
+// last ancestor is the parent of the node matching compounds[i+1].
+func (sel *selector) matchAncestors(i int, ancestors []ast.PositionHolder) bool {
+	if i < 0 {
+		return true
+	}
+	switch sel.combinators[i] {
+	case '>':
+		j := len(ancestors) - 1
+		return j >= 0 && sel.compounds[i].match(ancestors[j]) && sel.matchAncestors(i-1, ancestors[:j])
+	default:
+		for j := len(ancestors) - 1; j >= 0; j-- {
+			if sel.compounds[i].match(ancestors[j]) && sel.matchAncestors(i-1, ancestors[:j]) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (c *compound) match(n ast.PositionHolder) bool {
+	if !typeMatches(c.typ, n) {
+		return false
+	}
+	for i := range c.filters {
+		if !c.filters[i].match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func typeMatches(typ string, n interface{}) bool {
+	switch typ {
+	case "*":
+		return true
+	case "Expr":
+		_, ok := n.(ast.Expr)
+		return ok
+	case "Stmt":
+		_, ok := n.(ast.Stmt)
+		return ok
+	}
+	return reflect.TypeOf(n) == types[typ]
+}
+
+func (f *filter) match(n ast.PositionHolder) bool {
+	v, ok := field(reflect.ValueOf(n), f.path)
+	switch f.op {
+	case "":
+		return ok && !isZero(v)
+	case "!=":
+		return !ok || !f.value.equal(v)
+	case "=":
+		return ok && f.value.equal(v)
+	}
+	s, ok := stringOf(v, ok)
+	if !ok {
+		return false
+	}
+	switch f.op {
+	case "^=":
+		return strings.HasPrefix(s, f.value.str)
+	case "$=":
+		return strings.HasSuffix(s, f.value.str)
+	}
+	return strings.Contains(s, f.value.str)
+}
+
+// field follows path from v. It reports false if a step does not exist.
+func field(v reflect.Value, path []string) (reflect.Value, bool) {
+	for _, name := range path {
+		if !v.IsValid() {
+			return v, false
+		}
+		if m := v.MethodByName(name); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+			if isNil(v) {
+				return reflect.Value{}, false
+			}
+			v = m.Call(nil)[0]
+			continue
+		}
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			f, ok := v.Type().FieldByName(name)
+			if !ok || f.PkgPath != "" {
+				return v, false
+			}
+			v = v.FieldByIndex(f.Index)
+		case reflect.Slice:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= v.Len() {
+				return v, false
+			}
+			v = v.Index(i)
+		default:
+			return v, false
+		}
+	}
+	return v, v.IsValid()
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// stringOf returns the string held by v, or the lua code of the node in v.
+func stringOf(v reflect.Value, ok bool) (string, bool) {
+	if !ok || isNil(v) {
+		return "", false
+	}
+	if v.Kind() == reflect.String {
+		return v.String(), true
+	}
+	if s, ok := v.Interface().(interface{ String() string }); ok {
+		return s.String(), true
+	}
+	return "", false
+}
+
+func (val *value) equal(v reflect.Value) bool {
+	switch val.kind {
+	case "nil":
+		return isNil(v) || isZero(v)
+	case "string":
+		s, ok := stringOf(v, true)
+		return ok && s == val.str
+	case "number":
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(v.Int()) == val.num
+		case reflect.Float32, reflect.Float64:
+			return v.Float() == val.num
+		}
+	case "bool":
+		return v.Kind() == reflect.Bool && v.Bool() == val.bool
+	case "type":
+		return !isNil(v) && typeMatches(val.str, v.Interface())
+	case "ctor":
+		if isNil(v) {
+			return false
+		}
+		switch n := v.Interface().(type) {
+		case *ast.IdentExpr:
+			return val.ctor == "ident" && n.Value == val.str
+		case *ast.StringExpr:
+			return val.ctor == "string" && n.Value == val.str
+		case *ast.NumberExpr:
+			return val.ctor == "number" && n.Value == val.num
+		}
+	}
+	return false
+}
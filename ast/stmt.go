@@ -4,14 +4,28 @@ type Stmt interface {
 	PositionHolder
 	stmtMarker()
 	String() string
+	Comment() string
+	SetComment(string)
 }
 
 type StmtBase struct {
 	Node
+
+	comment string
 }
 
 func (stmt *StmtBase) stmtMarker() {}
 
+// Comment returns the trailing comment of the statement, such as "-- note",
+// or "" if it has none.
+func (stmt *StmtBase) Comment() string {
+	return stmt.comment
+}
+
+func (stmt *StmtBase) SetComment(comment string) {
+	stmt.comment = comment
+}
+
 type AssignStmt struct {
 	StmtBase
 
@@ -11,11 +11,12 @@ type Position struct {
 }
 
 type Token struct {
-	Type int
-	Name string
-	Str  string
-	Num  float64
-	Pos  Position
+	Type  int
+	Name  string
+	Str   string
+	Num   float64
+	Float bool
+	Pos   Position
 }
 
 func (t *Token) String() string {
@@ -0,0 +1,109 @@
+package ast
+
+// Inspect traverses the tree rooted at node in depth-first order. It calls
+// f(node) and, if f returns true, inspects each of the non-nil children of
+// node, followed by a call of f(nil).
+//
+// node is a Chunk, Stmt, Expr or *Field. Every block of statements is
+// visited as a Chunk, so Inspect(chunk, f) first calls f(chunk) and the
+// chunk of a while loop is visited as a child of the *WhileStmt. Names,
+// such as the ones of a local assignment or a parameter list, are part of
+// their node and not visited on their own.
+func Inspect(node interface{}, f func(node interface{}) bool) {
+	if !f(node) {
+		return
+	}
+	expr := func(ex Expr) {
+		if ex != nil {
+			Inspect(ex, f)
+		}
+	}
+	exprs := func(list []Expr) {
+		for _, ex := range list {
+			expr(ex)
+		}
+	}
+	chunk := func(c Chunk) {
+		if c != nil {
+			Inspect(c, f)
+		}
+	}
+
+	switch n := node.(type) {
+	case Chunk:
+		for _, st := range n {
+			Inspect(st, f)
+		}
+	case *Field:
+		expr(n.Key)
+		expr(n.Value)
+
+	case *AssignStmt:
+		exprs(n.Lhs)
+		exprs(n.Rhs)
+	case *CompoundAssignStmt:
+		exprs(n.Lhs)
+		exprs(n.Rhs)
+	case *LocalAssignStmt:
+		exprs(n.Exprs)
+	case *FuncCallStmt:
+		expr(n.Expr)
+	case *DoBlockStmt:
+		chunk(n.Chunk)
+	case *WhileStmt:
+		expr(n.Condition)
+		chunk(n.Chunk)
+	case *RepeatStmt:
+		chunk(n.Chunk)
+		expr(n.Condition)
+	case *IfStmt:
+		expr(n.Condition)
+		chunk(n.Then)
+		chunk(n.Else)
+	case *NumberForStmt:
+		expr(n.Init)
+		expr(n.Limit)
+		expr(n.Step)
+		chunk(n.Chunk)
+	case *GenericForStmt:
+		exprs(n.Exprs)
+		chunk(n.Chunk)
+	case *LocalFunctionStmt:
+		expr(n.Func)
+	case *FunctionStmt:
+		expr(n.Name.Func)
+		expr(n.Name.Receiver)
+		expr(n.Func)
+	case *ReturnStmt:
+		exprs(n.Exprs)
+
+	case *AttrGetExpr:
+		expr(n.Object)
+		expr(n.Key)
+	case *TableExpr:
+		for _, field := range n.Fields {
+			Inspect(field, f)
+		}
+	case *FuncCallExpr:
+		expr(n.Func)
+		expr(n.Receiver)
+		exprs(n.Args)
+	case *LogicalOpExpr:
+		expr(n.Lhs)
+		expr(n.Rhs)
+	case *RelationalOpExpr:
+		expr(n.Lhs)
+		expr(n.Rhs)
+	case *StringConcatOpExpr:
+		expr(n.Lhs)
+		expr(n.Rhs)
+	case *ArithmeticOpExpr:
+		expr(n.Lhs)
+		expr(n.Rhs)
+	case *UnaryOpExpr:
+		expr(n.Expr)
+	case *FunctionExpr:
+		chunk(n.Chunk)
+	}
+	f(nil)
+}
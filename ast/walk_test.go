@@ -0,0 +1,52 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+func TestInspect(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var idents []string
+	chunks, depth, maxDepth := 0, 0, 0
+	ast.Inspect(chunk, func(node interface{}) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		switch n := node.(type) {
+		case ast.Chunk:
+			chunks++
+		case *ast.IdentExpr:
+			idents = append(idents, n.Value)
+		}
+		return true
+	})
+
+	if depth != 0 {
+		t.Errorf("Expected every node to be followed by nil, depth is %d", depth)
+	}
+	// The chunk, the function body and the body of the loop.
+	if chunks != 3 {
+		t.Errorf("Expected 3 chunks, got %d", chunks)
+	}
+	// The name of the local is not an expression.
+	if got := strings.Join(idents, " "); got != "x t t t" {
+		t.Errorf("Expected identifiers x t t t, got %s", got)
+	}
+	// Chunk, local, table, field, function, chunk, return, *, + and 2.
+	if maxDepth != 10 {
+		t.Errorf("Expected a depth of 10, got %d", maxDepth)
+	}
+}
@@ -0,0 +1,164 @@
+// Luafmt formats lua source files.
+//
+// Usage:
+//
+//	luafmt [flags] [path ...]
+//
+// Without paths it reads from standard input. By default the formatted code
+// is written to standard output. Every result is re-parsed and checked
+// against the original before it is used.
+//
+// The flags are:
+//
+//	-d	print a unified diff of the formatting changes instead
+//	-l	list the files whose formatting would change instead
+//	-w	write the result back to the source file instead
+//	-align	align consecutive assignments and keyed table fields
+//	-quote	comma-separated string quoting strategies:
+//		smart	use single quotes when that needs fewer escapes
+//		long	use [[...]] for multi-line text
+//		hex	escape binary data as \xXX
+//	-r rule	apply a rewrite rule, such as 'pcall($f) -> xpcall($f, debug.traceback)',
+//		before formatting
+//
+// With -d or -l the exit status is 1 if any file would change, which lets
+// CI check formatting without rewriting files.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/format"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+	"github.com/hootrhino/beautiful-lua-go/rewrite"
+)
+
+var (
+	diff  = flag.Bool("d", false, "print a unified diff of the formatting changes")
+	list  = flag.Bool("l", false, "list files whose formatting would change")
+	write = flag.Bool("w", false, "write the result back to the source file")
+	align = flag.Bool("align", false, "align consecutive assignments and keyed table fields")
+	quote = flag.String("quote", "", "comma-separated string quoting strategies: smart, long, hex")
+	rule  = flag.String("r", "", "rewrite rule (e.g., 'a[$i] -> rawget(a, $i)')")
+
+	rewriteRule *rewrite.Rule
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: luafmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	opts := ast.Options{Align: *align}
+	for _, q := range strings.Split(*quote, ",") {
+		switch q {
+		case "":
+		case "smart":
+			opts.Quote.Smart = true
+		case "long":
+			opts.Quote.Long = true
+		case "hex":
+			opts.Quote.Hex = true
+		default:
+			fmt.Fprintf(os.Stderr, "luafmt: unknown quoting strategy %q\n", q)
+			os.Exit(2)
+		}
+	}
+	if *rule != "" {
+		var err error
+		if rewriteRule, err = rewrite.Parse(*rule); err != nil {
+			fmt.Fprintf(os.Stderr, "luafmt: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	status := 0
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "luafmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		status = process("<standard input>", os.Stdin, opts)
+	}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		if s := process(path, f, opts); s > status {
+			status = s
+		}
+		f.Close()
+	}
+	os.Exit(status)
+}
+
+// process formats one file and returns the exit status for it.
+func process(name string, f *os.File, opts ast.Options) int {
+	src, err := ioutil.ReadAll(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	chunk, err := parse.Parse(bytes.NewReader(src), name)
+	if err == nil && rewriteRule != nil {
+		chunk = rewriteRule.Apply(chunk)
+	}
+	var out string
+	if err == nil {
+		out, err = format.ChunkWith(chunk, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 2
+	}
+	changed := out != string(src)
+
+	switch {
+	case *list || *diff:
+		if !changed {
+			return 0
+		}
+		if *list {
+			fmt.Println(name)
+		}
+		if *diff {
+			os.Stdout.Write(format.Unified(name+".orig", name, src, []byte(out)))
+		}
+		if *write {
+			return writeFile(name, f, out)
+		}
+		return 1
+	case *write:
+		if changed {
+			return writeFile(name, f, out)
+		}
+	default:
+		os.Stdout.WriteString(out)
+	}
+	return 0
+}
+
+func writeFile(name string, f *os.File, out string) int {
+	info, err := f.Stat()
+	if err == nil {
+		err = ioutil.WriteFile(name, []byte(out), info.Mode().Perm())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
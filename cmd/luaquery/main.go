@@ -0,0 +1,107 @@
+// Luaquery prints the nodes of lua source files matching a query.
+//
+// Usage:
+//
+//	luaquery [flags] query [path ...]
+//
+// Without paths it reads from standard input. The query syntax is
+// described in the ast/query package. Every match is printed as
+//
+//	file:line:col: code
+//
+// where code is the first line of the formatted node.
+//
+// The flags are:
+//
+//	-l	only list the files containing a match
+//	-c	only print the number of matches in each file
+//
+// The exit status is 0 if there was a match, 1 if there was none and 2 if
+// an error occurred, like grep.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast/query"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+var (
+	list  = flag.Bool("l", false, "only list the files containing a match")
+	count = flag.Bool("c", false, "only print the number of matches in each file")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: luaquery [flags] query [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	q, err := query.Compile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "luaquery: %v\n", err)
+		os.Exit(2)
+	}
+
+	status := 1
+	update := func(s int) {
+		if s == 2 || status == 1 {
+			status = s
+		}
+	}
+	if flag.NArg() == 1 {
+		update(process("<standard input>", os.Stdin, q))
+	}
+	for _, path := range flag.Args()[1:] {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			update(2)
+			continue
+		}
+		update(process(path, f, q))
+		f.Close()
+	}
+	os.Exit(status)
+}
+
+// process queries one file and returns the exit status for it.
+func process(name string, r io.Reader, q *query.Query) int {
+	chunk, err := parse.Parse(r, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, strings.TrimSpace(err.Error()))
+		return 2
+	}
+	results := q.Select(chunk)
+	switch {
+	case *count:
+		fmt.Printf("%s:%d\n", name, len(results))
+	case *list:
+		if len(results) > 0 {
+			fmt.Println(name)
+		}
+	default:
+		for _, r := range results {
+			code := r.Node.(fmt.Stringer).String()
+			if i := strings.IndexByte(code, '\n'); i >= 0 {
+				code = code[:i]
+			}
+			fmt.Printf("%s:%d:%d: %s\n", name, r.Line, r.Column, code)
+		}
+	}
+	if len(results) == 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,285 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+// Unified returns a unified diff transforming old into new, or nil if they
+// are equal. oldName and newName are used in the --- and +++ headers.
+func Unified(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	a, b := splitLines(old), splitLines(new)
+	d := newDiffer(a, b)
+	d.compare(0, len(a), 0, len(b))
+
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range d.hunks(context) {
+		h.write(out, a, b)
+	}
+	return out.Bytes()
+}
+
+// splitLines splits s after each newline. The last line has no newline if
+// s does not end in one.
+func splitLines(s []byte) []string {
+	var lines []string
+	for len(s) > 0 {
+		i := bytes.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		lines = append(lines, string(s[:i]))
+		s = s[i:]
+	}
+	return lines
+}
+
+// differ computes which lines of a are deleted and which lines of b are
+// inserted using the linear space variant of Myers' algorithm described in
+// "An O(ND) Difference Algorithm and Its Variations", 1986.
+type differ struct {
+	a, b     []int // lines as indices into a table of distinct lines
+	deleted  []bool
+	inserted []bool
+}
+
+func newDiffer(a, b []string) *differ {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		res := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+	return &differ{
+		a:        intern(a),
+		b:        intern(b),
+		deleted:  make([]bool, len(a)),
+		inserted: make([]bool, len(b)),
+	}
+}
+
+// compare marks the differences between a[a0:a1] and b[b0:b1].
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0, b0 = a0+1, b0+1
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1, b1 = a1-1, b1-1
+	}
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			d.inserted[j] = true
+		}
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		x, y, ok := d.middleSnake(a0, a1, b0, b1)
+		if !ok {
+			for i := a0; i < a1; i++ {
+				d.deleted[i] = true
+			}
+			for j := b0; j < b1; j++ {
+				d.inserted[j] = true
+			}
+			return
+		}
+		d.compare(a0, x, b0, y)
+		d.compare(x, a1, y, b1)
+	}
+}
+
+// middleSnake searches forward from the start and backward from the end of
+// a[a0:a1] and b[b0:b1] at the same time and returns the point at which
+// the two searches first overlap.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (int, int, bool) {
+	n, m := a1-a0, b1-b0
+	max := (n + m + 1) / 2
+	offset, length := max, 2*max+2
+	v1, v2 := make([]int, length), make([]int, length)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+	delta := n - m
+	front := delta%2 != 0 // the forward search checks for overlaps if delta is odd
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for step := 0; step < max; step++ {
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1off := offset + k1
+			var x1 int
+			if k1 == -step || k1 != step && v1[k1off-1] < v1[k1off+1] {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.a[a0+x1] == d.b[b0+y1] {
+				x1, y1 = x1+1, y1+1
+			}
+			v1[k1off] = x1
+			switch {
+			case x1 > n:
+				k1end += 2 // ran off the right of the graph
+			case y1 > m:
+				k1start += 2 // ran off the bottom of the graph
+			case front:
+				k2off := offset + delta - k1
+				if k2off >= 0 && k2off < length && v2[k2off] != -1 && x1 >= n-v2[k2off] {
+					return a0 + x1, b0 + y1, true
+				}
+			}
+		}
+
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2off := offset + k2
+			var x2 int
+			if k2 == -step || k2 != step && v2[k2off-1] < v2[k2off+1] {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.a[a1-x2-1] == d.b[b1-y2-1] {
+				x2, y2 = x2+1, y2+1
+			}
+			v2[k2off] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1off := offset + delta - k2
+				if k1off >= 0 && k1off < length && v1[k1off] != -1 {
+					x1 := v1[k1off]
+					y1 := offset + x1 - k1off
+					if x1 >= n-x2 {
+						return a0 + x1, b0 + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// hunk is a range of lines a[a0:a1] replaced by b[b0:b1] together with
+// its surrounding context.
+type hunk struct {
+	a0, a1, b0, b1 int
+	d              *differ
+}
+
+// hunks groups the differences into hunks with ctx lines of context,
+// merging hunks whose context would overlap.
+func (d *differ) hunks(ctx int) []*hunk {
+	var hunks []*hunk
+	var cur *hunk
+	i, j, n, m := 0, 0, len(d.a), len(d.b)
+	for i < n || j < m {
+		if i < n && j < m && !d.deleted[i] && !d.inserted[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		// Start of a change: extend it over every deleted and inserted line.
+		si, sj := i, j
+		for i < n && d.deleted[i] {
+			i++
+		}
+		for j < m && d.inserted[j] {
+			j++
+		}
+		if cur != nil && si-cur.a1 <= 2*ctx {
+			cur.a1, cur.b1 = i, j
+			continue
+		}
+		if cur != nil {
+			cur.extend(ctx, n, m)
+		}
+		cur = &hunk{a0: si, a1: i, b0: sj, b1: j, d: d}
+		hunks = append(hunks, cur)
+	}
+	if cur != nil {
+		cur.extend(ctx, n, m)
+	}
+	for _, h := range hunks {
+		h.begin(ctx)
+	}
+	return hunks
+}
+
+// begin adds up to ctx lines of leading context to h.
+func (h *hunk) begin(ctx int) {
+	c := ctx
+	if h.a0 < c {
+		c = h.a0
+	}
+	h.a0, h.b0 = h.a0-c, h.b0-c
+}
+
+// extend adds up to ctx lines of trailing context to h.
+func (h *hunk) extend(ctx, n, m int) {
+	c := ctx
+	if n-h.a1 < c {
+		c = n - h.a1
+	}
+	if m-h.b1 < c {
+		c = m - h.b1
+	}
+	h.a1, h.b1 = h.a1+c, h.b1+c
+}
+
+func (h *hunk) write(out *bytes.Buffer, a, b []string) {
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", span(h.a0, h.a1), span(h.b0, h.b1))
+	i, j := h.a0, h.b0
+	for i < h.a1 || j < h.b1 {
+		switch {
+		case i < h.a1 && h.d.deleted[i]:
+			line(out, '-', a[i])
+			i++
+		case j < h.b1 && h.d.inserted[j]:
+			line(out, '+', b[j])
+			j++
+		default:
+			line(out, ' ', a[i])
+			i, j = i+1, j+1
+		}
+	}
+}
+
+// span formats the line range [start, end) for a hunk header.
+func span(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func line(out *bytes.Buffer, prefix byte, text string) {
+	out.WriteByte(prefix)
+	out.WriteString(text)
+	if len(text) == 0 || text[len(text)-1] != '\n' {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
@@ -0,0 +1,118 @@
+package format
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+)
+
+func TestDiff(t *testing.T) {
+	const src = "local a=1\nlocal b = 2;\nlocal c = 3;\nlocal d = 4;\nlocal e = 5;\nlocal f = 6;\nlocal g = 7;\nlocal h = 8;\nlocal i = 9;\nlocal j=10"
+	const expected = `--- test.lua.orig
++++ test.lua
+@@ -1,4 +1,4 @@
+-local a=1
++local a = 1;
+ local b = 2;
+ local c = 3;
+ local d = 4;
+@@ -7,4 +7,4 @@
+ local g = 7;
+ local h = 8;
+ local i = 9;
+-local j=10
+\ No newline at end of file
++local j = 10;
+`
+	diff, err := Diff("test.lua", []byte(src), ast.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(diff) != expected {
+		t.Errorf("\nExpected:\n%s\nGot:\n%s", expected, diff)
+	}
+
+	changed, err := Changed([]byte(src), ast.Options{})
+	if err != nil || !changed {
+		t.Errorf("Expected change, got %v, %v", changed, err)
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	const src = "local a = 1;\n"
+	diff, err := Diff("test.lua", []byte(src), ast.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil {
+		t.Errorf("Expected no diff, got %s", diff)
+	}
+
+	changed, err := Changed([]byte(src), ast.Options{})
+	if err != nil || changed {
+		t.Errorf("Expected no change, got %v, %v", changed, err)
+	}
+}
+
+// apply applies a unified diff produced by Unified to old.
+func apply(old string, diff string, t *testing.T) string {
+	a := splitLines([]byte(old))
+	lines := strings.Split(diff, "\n")
+	var out []string
+	pos := 0
+	for i := 2; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "@@"):
+			start, err := strconv.Atoi(strings.Split(strings.TrimPrefix(strings.Fields(l)[1], "-"), ",")[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(strings.Fields(l)[1], ",0") {
+				start--
+			}
+			out = append(out, a[pos:start]...)
+			pos = start
+		case strings.HasPrefix(l, " "):
+			out = append(out, a[pos])
+			pos++
+		case strings.HasPrefix(l, "-"):
+			pos++
+		case strings.HasPrefix(l, "+"):
+			out = append(out, l[1:]+"\n")
+		case strings.HasPrefix(l, `\`):
+			out[len(out)-1] = strings.TrimSuffix(out[len(out)-1], "\n")
+		}
+	}
+	out = append(out, a[pos:]...)
+	return strings.Join(out, "")
+}
+
+func TestUnified(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	gen := func() string {
+		b := &strings.Builder{}
+		for i := r.Intn(40); i > 0; i-- {
+			b.WriteString(string(rune('a' + r.Intn(4))))
+			b.WriteByte('\n')
+		}
+		return b.String()
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := gen(), gen()
+		diff := string(Unified("a", "b", []byte(a), []byte(b)))
+		if a == b {
+			if diff != "" {
+				t.Fatalf("Expected no diff for equal input, got %s", diff)
+			}
+			continue
+		}
+		if got := apply(a, diff, t); got != b {
+			t.Fatalf("Applying diff to %q\n%s\nExpected %q, got %q", a, diff, b, got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package format
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+)
+
+// maxNodeLen is the maximum length of a node rendering in an Error.
+const maxNodeLen = 60
+
+// Error describes the first difference found while verifying formatted output.
+type Error struct {
+	Line    int    // line of the differing node in the original chunk, if known
+	Path    string // path of the differing node from the chunk root, e.g. [2].Exprs[0].Lhs
+	Node    string // type of the differing node
+	Want    string // original node
+	Got     string // node obtained by re-parsing the formatted output
+	Message string
+}
+
+func (e *Error) Error() string {
+	b := &strings.Builder{}
+	if e.Line > 0 {
+		fmt.Fprintf(b, "line:%d ", e.Line)
+	}
+	b.WriteString(e.Message)
+	if e.Path != "" {
+		fmt.Fprintf(b, " at %s", e.Path)
+	}
+	if e.Node != "" {
+		fmt.Fprintf(b, " (%s)", e.Node)
+	}
+	if e.Want != "" || e.Got != "" {
+		fmt.Fprintf(b, ": want %s, got %s", e.Want, e.Got)
+	}
+	return b.String()
+}
+
+// comparer walks two trees in parallel and records the innermost
+// expression or statement enclosing the current position.
+type comparer struct {
+	node     reflect.Value // innermost ast.Expr or ast.Stmt of the original tree
+	got      reflect.Value // its counterpart in the re-parsed tree
+	lastLine int           // line of the innermost positioned node
+}
+
+var (
+	exprType  = reflect.TypeOf((*ast.Expr)(nil)).Elem()
+	stmtType  = reflect.TypeOf((*ast.Stmt)(nil)).Elem()
+	fieldType = reflect.TypeOf(ast.Field{})
+)
+
+// Equal compares two chunks ignoring positions and comments and returns an
+// *Error naming the first node at which they differ, or nil if they are equal.
+func Equal(want, got ast.Chunk) error {
+	c := &comparer{}
+	return c.compare("", reflect.ValueOf(want), reflect.ValueOf(got))
+}
+
+func (c *comparer) mismatch(path string, msg string, want, got reflect.Value) *Error {
+	err := &Error{
+		Line:    c.lastLine,
+		Path:    path,
+		Message: msg,
+		Want:    describe(want),
+		Got:     describe(got),
+	}
+	switch {
+	case isNode(want):
+		err.Node = typeName(want)
+	case isNode(got):
+		err.Node = typeName(got)
+	case c.node.IsValid():
+		// Name the enclosing node rather than a bare field value.
+		err.Node = typeName(c.node)
+		err.Want = fmt.Sprintf("%s in %s", err.Want, describe(c.node))
+		err.Got = fmt.Sprintf("%s in %s", err.Got, describe(c.got))
+	}
+	return err
+}
+
+func (c *comparer) compare(path string, want, got reflect.Value) error {
+	if want.Kind() == reflect.Interface {
+		if want.IsNil() || got.IsNil() {
+			if want.IsNil() != got.IsNil() {
+				return c.mismatch(path, "formatted output differs", want, got)
+			}
+			return nil
+		}
+		want, got = want.Elem(), got.Elem()
+	}
+	if want.Type() != got.Type() {
+		return c.mismatch(path, "formatted output differs", want, got)
+	}
+
+	switch want.Kind() {
+	case reflect.Ptr:
+		if want.IsNil() || got.IsNil() {
+			if want.IsNil() != got.IsNil() {
+				return c.mismatch(path, "formatted output differs", want, got)
+			}
+			return nil
+		}
+		if isNode(want) {
+			old, oldGot, oldLine := c.node, c.got, c.lastLine
+			c.node, c.got = want, got
+			if line := want.Interface().(ast.PositionHolder).Line(); line > 0 {
+				c.lastLine = line
+			}
+			defer func() { c.node, c.got, c.lastLine = old, oldGot, oldLine }()
+		}
+		return c.compare(path, want.Elem(), got.Elem())
+	case reflect.Struct:
+		t := want.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous { // ExprBase, StmtBase and friends only carry positions and comments
+				continue
+			}
+			if t == fieldType && field.Name == "Comment" { // a comment is not code
+				continue
+			}
+			if err := c.compare(path+"."+field.Name, want.Field(i), got.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		n := want.Len()
+		if got.Len() < n {
+			n = got.Len()
+		}
+		for i := 0; i < n; i++ {
+			if err := c.compare(fmt.Sprintf("%s[%d]", path, i), want.Index(i), got.Index(i)); err != nil {
+				return err
+			}
+		}
+		if want.Len() != got.Len() {
+			return c.mismatch(fmt.Sprintf("%s[%d]", path, n), "formatted output differs", index(want, n), index(got, n))
+		}
+		return nil
+	case reflect.Float32, reflect.Float64:
+		w, g := want.Float(), got.Float()
+		if w != g && !(math.IsNaN(w) && math.IsNaN(g)) {
+			return c.mismatch(path, "formatted output differs", want, got)
+		}
+		return nil
+	default:
+		if want.Interface() != got.Interface() {
+			return c.mismatch(path, "formatted output differs", want, got)
+		}
+		return nil
+	}
+}
+
+func index(v reflect.Value, i int) reflect.Value {
+	if i < v.Len() {
+		return v.Index(i)
+	}
+	return reflect.Value{}
+}
+
+func isNode(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v.IsValid() && (v.Type().Implements(exprType) || v.Type().Implements(stmtType))
+}
+
+func typeName(v reflect.Value) string {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v.Type().Elem().Name()
+}
+
+// describe renders v for an error message.
+func describe(v reflect.Value) string {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "<nil>"
+	}
+	var s string
+	switch n := v.Interface().(type) {
+	case ast.Expr:
+		s = n.String()
+	case ast.Stmt:
+		s = strings.TrimSuffix(n.String(), ";\n")
+	case *ast.Field:
+		if n.Key != nil {
+			s = fmt.Sprintf("[%s] = %s", n.Key, n.Value)
+		} else {
+			s = n.Value.String()
+		}
+	case *ast.ParList:
+		names := n.Names
+		if n.HasVargs {
+			names = append(names[:len(names):len(names)], "...")
+		}
+		s = "(" + strings.Join(names, ", ") + ")"
+	case *ast.FuncName:
+		if n.Func != nil {
+			s = n.Func.String()
+		} else {
+			s = n.Receiver.String() + ":" + n.Method
+		}
+	case string:
+		s = fmt.Sprintf("%q", n)
+	default:
+		s = fmt.Sprint(n)
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > maxNodeLen {
+		s = s[:maxNodeLen-3] + "..."
+	}
+	return s
+}
@@ -0,0 +1,115 @@
+// Package format formats lua code and verifies that the output is safe to
+// use in place of the original.
+package format
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+// Chunk formats chunk and verifies the result with Verify. The formatted
+// source is only returned if the verification succeeds.
+func Chunk(chunk ast.Chunk) (string, error) {
+	return ChunkWith(chunk, ast.Options{})
+}
+
+// ChunkWith is like Chunk but formats chunk using opts.
+func ChunkWith(chunk ast.Chunk, opts ast.Options) (string, error) {
+	src := chunk.Format(opts)
+	if err := VerifyWith(chunk, src, opts); err != nil {
+		return "", err
+	}
+	return src, nil
+}
+
+// Source parses the lua code read from r, formats it and verifies the result.
+// name is used as the source name in parse errors.
+func Source(r io.Reader, name string) (string, error) {
+	return SourceWith(r, name, ast.Options{})
+}
+
+// SourceWith is like Source but formats the code using opts.
+func SourceWith(r io.Reader, name string, opts ast.Options) (string, error) {
+	chunk, err := parse.Parse(r, name)
+	if err != nil {
+		return "", err
+	}
+	return ChunkWith(chunk, opts)
+}
+
+// Diff formats src and returns a unified diff from src to the formatted
+// code, or nil if formatting would not change src. name is used in parse
+// errors and in the diff headers as name.orig and name.
+func Diff(name string, src []byte, opts ast.Options) ([]byte, error) {
+	out, err := SourceWith(bytes.NewReader(src), name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Unified(name+".orig", name, src, []byte(out)), nil
+}
+
+// Changed reports whether formatting src would change it.
+func Changed(src []byte, opts ast.Options) (bool, error) {
+	out, err := SourceWith(bytes.NewReader(src), "", opts)
+	if err != nil {
+		return false, err
+	}
+	return out != string(src), nil
+}
+
+// Verify checks that src, the formatted form of chunk, parses back into a
+// tree equal to chunk when positions and comments are ignored, and that
+// formatting it again yields src. The returned error is an *Error describing the first
+// difference.
+func Verify(chunk ast.Chunk, src string) error {
+	return VerifyWith(chunk, src, ast.Options{})
+}
+
+// VerifyWith is like Verify for output formatted using opts.
+func VerifyWith(chunk ast.Chunk, src string, opts ast.Options) error {
+	got, err := parse.Parse(strings.NewReader(src), "")
+	if err != nil {
+		e := &Error{Message: "formatted output does not parse: " + strings.TrimSpace(err.Error())}
+		if perr, ok := err.(*parse.Error); ok {
+			e.Line = perr.Pos.Line
+			e.Message = "formatted output does not parse: " + perr.Message
+			e.Got = perr.Token
+		}
+		return e
+	}
+	if err := Equal(chunk, got); err != nil {
+		return err
+	}
+	if again := got.Format(opts); again != src {
+		line, want, have := firstDiff(src, again)
+		return &Error{
+			Line:    line,
+			Message: "formatting is not idempotent",
+			Want:    want,
+			Got:     have,
+		}
+	}
+	return nil
+}
+
+// firstDiff returns the first line, counting from 1, at which a and b differ
+// together with the contents of that line in each.
+func firstDiff(a, b string) (int, string, string) {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; ; i++ {
+		var x, y string
+		if i < len(al) {
+			x = al[i]
+		}
+		if i < len(bl) {
+			y = bl[i]
+		}
+		if x != y || i >= len(al) || i >= len(bl) {
+			return i + 1, x, y
+		}
+	}
+}
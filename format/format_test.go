@@ -0,0 +1,265 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	luautil "github.com/hootrhino/beautiful-lua-go"
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+var valid = []string{
+	"local a = (1 + 2) * 3",
+	"local b = 2 ^ 3 ^ 2",
+	"local c = (2 ^ 3) ^ 2",
+	"local d = -x ^ 2",
+	"local e = (-x) ^ 2",
+	"local f = a - (b - c)",
+	"local g = (a .. b) .. c",
+	"local h = (not a) == b",
+	"local i = (a or b) and c",
+	"local j = (f())",
+	"local k = f()()",
+	"local l = f().x:y()",
+	"t = {a = 1, [2] = 3, \"x\"; y = {z = 1}}",
+	"t[\"else\"], t[\"true\"], t[\"until\"], t[\"goto\"] = 1, 2, 3, 4",
+	"for i = 1, 10, 2 do if i then break elseif j then goto x else return end end ::x::",
+	"local function f(a, ...) return function() return a end end",
+	"local m = 1.0 + 0x10 + 1e2 + 2.5 + 10",
+}
+
+// Helper function
+func formatString(str string, t *testing.T) string {
+	chunk, err := parse.Parse(strings.NewReader(str), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Chunk(chunk)
+	if err != nil {
+		t.Fatalf("%s: %v", str, err)
+	}
+	return src
+}
+
+func TestVerify(t *testing.T) {
+	for _, str := range valid {
+		formatString(str, t)
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("_ = a - (b - c)"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(chunk, "_ = a - b - c;\n")
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	if e.Path != "[0].Rhs[0].Lhs" || e.Node != "IdentExpr" || e.Line != 1 {
+		t.Errorf("Expected mismatch of IdentExpr at [0].Rhs[0].Lhs on line 1, got %v", e)
+	}
+	if e.Want != "a" || e.Got != "a - b" {
+		t.Errorf("Expected want a, got a - b, got %v", e)
+	}
+}
+
+func TestVerifyField(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("_ = a < b"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(chunk, "_ = a <= b;\n")
+	expected := `line:1 formatted output differs at [0].Rhs[0].Operator (RelationalOpExpr): want "<" in a < b, got "<=" in a <= b`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestVerifyIdempotent(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("_ = 1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(chunk, "_ =   1;\n")
+	e, ok := err.(*Error)
+	if !ok || e.Message != "formatting is not idempotent" || e.Line != 1 {
+		t.Errorf("Expected idempotence error on line 1, got %v", err)
+	}
+}
+
+func TestVerifySyntax(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("_ = 1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(chunk, "_ = = 1;\n"); err == nil {
+		t.Error("Expected error for unparsable output")
+	}
+}
+
+func TestAlign(t *testing.T) {
+	const input = `
+local a = 1
+local bcd = 2
+x.y, z = 3, 4
+
+local e = 5
+ef = function() end
+local g, h = 6
+local i
+local jk = {
+	a = 1,
+	bcd = "x",
+	[1] = true,
+
+	key = nil,
+	"positional",
+	longer = {},
+	t = {x = 1},
+	u = 1
+}
+`
+	const expected = `local a   = 1;
+local bcd = 2;
+x.y, z    = 3, 4;
+
+local e = 5;
+ef = function()
+end;
+local g, h = 6;
+local i;
+local jk = {
+	a   = 1,
+	bcd = "x",
+	[1] = true,
+
+	key = nil,
+	"positional",
+	longer = {},
+	t = {
+		x = 1
+	},
+	u = 1
+};
+`
+	chunk, err := parse.Parse(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ChunkWith(chunk, ast.Options{Align: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Errorf("\nExpected:\n%sGot:\n%s", expected, result)
+	}
+}
+
+func TestComments(t *testing.T) {
+	const input = `-- dropped
+local a = 1 -- one
+b = {c = 2, d = 3} --[[ dropped ]]
+if a then f() end -- moved into the block
+e = {
+	f = 4,   -- four
+	g = 5 -- five
+}
+`
+	const expected = `local a = 1; -- one
+b = {
+	c = 2,
+	d = 3
+};
+if a then
+	f(); -- moved into the block
+end;
+e = {
+	f = 4, -- four
+	g = 5 -- five
+};
+`
+	if result := formatString(input, t); result != expected {
+		t.Errorf("\nExpected:\n%sGot:\n%s", expected, result)
+	}
+}
+
+func TestAlignComments(t *testing.T) {
+	const input = `
+local a = 1 -- one
+local bcd = 22 -- two
+f() -- call
+x.y = 3
+z = 4 -- four
+
+local t = {
+	name = "x", -- the name
+	[10] = 2, -- ten
+	"positional", -- positional
+	u = {}, -- empty
+	v = {1},
+	w = 1 -- w
+}
+`
+	const expected = `local a   = 1;  -- one
+local bcd = 22; -- two
+f();            -- call
+x.y = 3;
+z   = 4; -- four
+
+local t = {
+	name = "x",   -- the name
+	[10] = 2,     -- ten
+	"positional", -- positional
+	u = {},       -- empty
+	v = {
+		1
+	},
+	w = 1 -- w
+};
+`
+	chunk, err := parse.Parse(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ChunkWith(chunk, ast.Options{Align: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Errorf("\nExpected:\n%sGot:\n%s", expected, result)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	const input = `
+local a = "first\nsecond]]"
+local bc = "say \"hi\""
+
+local frame = "\1\2\255\254"
+`
+	const expected = `local a = [=[first
+second]]]=];
+local bc = 'say "hi"';
+
+local frame = "\x01\x02\xff\xfe";
+`
+	chunk, err := parse.Parse(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := ast.Options{Align: true, Quote: luautil.QuoteOptions{Smart: true, Long: true, Hex: true}}
+	result, err := ChunkWith(chunk, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Errorf("\nExpected:\n%sGot:\n%s", expected, result)
+	}
+}
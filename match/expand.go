@@ -0,0 +1,285 @@
+package match
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+)
+
+// Names returns the metavariables of p, such as $x or $$body, in sorted
+// order. $_ is left out.
+func (p *Pattern) Names() []string {
+	var names []string
+	for _, v := range p.metas {
+		if v.Name == "_" {
+			continue
+		}
+		if v.Seq {
+			names = append(names, "$$"+v.Name)
+		} else {
+			names = append(names, "$"+v.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasWildcard reports whether p uses $_ or $$_, which match anything
+// and bind nothing.
+func (p *Pattern) HasWildcard() bool {
+	for _, v := range p.metas {
+		if v.Name == "_" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandExpr returns a copy of the expression pattern p with every
+// metavariable replaced by the code bindings holds for it. The new nodes
+// are placed on line.
+func (p *Pattern) ExpandExpr(bindings map[string]Binding, line int) (ast.Expr, error) {
+	if p.expr == nil {
+		return nil, fmt.Errorf("match: %s is not an expression", p.src)
+	}
+	e := &expander{p: p, bindings: bindings, line: line}
+	ex := e.expr(p.expr)
+	return ex, e.err
+}
+
+// ExpandStmts is like ExpandExpr for statement patterns. An expression
+// pattern that is a function call expands to a call statement.
+func (p *Pattern) ExpandStmts(bindings map[string]Binding, line int) ([]ast.Stmt, error) {
+	e := &expander{p: p, bindings: bindings, line: line}
+	stmts := p.stmts
+	if p.expr != nil {
+		if !p.IsCall() {
+			return nil, fmt.Errorf("match: %s is not a statement", p.src)
+		}
+		stmts = []ast.Stmt{&ast.FuncCallStmt{Expr: p.expr}}
+	}
+	out := e.chunk(stmts)
+	return out, e.err
+}
+
+// expander copies a pattern substituting the bound code. An expander
+// without a pattern copies code as it is, keeping its positions.
+type expander struct {
+	p        *Pattern
+	bindings map[string]Binding
+	line     int
+	err      error
+}
+
+func (e *expander) binding(v meta) Binding {
+	b, ok := e.bindings[v.Name]
+	if !ok && e.err == nil {
+		e.err = fmt.Errorf("match: $%s is not bound", v.Name)
+	}
+	return b
+}
+
+// meta returns the metavariable that name stands for.
+func (e *expander) meta(name string) (meta, bool) {
+	if e.p == nil {
+		return meta{}, false
+	}
+	return e.p.meta(name)
+}
+
+func (e *expander) pos(out, in ast.PositionHolder) {
+	if e.p == nil {
+		out.SetLine(in.Line())
+		out.SetColumn(in.Column())
+		out.SetLastLine(in.LastLine())
+		return
+	}
+	out.SetLine(e.line)
+}
+
+// copyExpr and copyStmts copy the code bound to a metavariable, so that
+// the expansions of a metavariable used twice do not share nodes.
+func copyExpr(ex ast.Expr) ast.Expr { return (&expander{}).expr(ex) }
+
+func copyStmts(stmts []ast.Stmt) []ast.Stmt { return (&expander{}).chunk(stmts) }
+
+func (e *expander) name(name string) string {
+	v, ok := e.meta(name)
+	if !ok {
+		return name
+	}
+	ident, ok := e.binding(v).Expr.(*ast.IdentExpr)
+	if !ok {
+		if e.err == nil {
+			e.err = fmt.Errorf("match: $%s is not bound to a name", v.Name)
+		}
+		return name
+	}
+	return ident.Value
+}
+
+func (e *expander) names(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = e.name(name)
+	}
+	return out
+}
+
+func (e *expander) exprs(exprs []ast.Expr) []ast.Expr {
+	out := make([]ast.Expr, len(exprs))
+	for i, ex := range exprs {
+		out[i] = e.expr(ex)
+	}
+	return out
+}
+
+func (e *expander) expr(ex ast.Expr) ast.Expr {
+	var out ast.Expr
+	switch x := ex.(type) {
+	case nil:
+		return nil
+	case *ast.IdentExpr:
+		if v, ok := e.meta(x.Value); ok {
+			return copyExpr(e.binding(v).Expr)
+		}
+		out = &ast.IdentExpr{Value: x.Value}
+	case *ast.StringExpr:
+		if v, ok := e.meta(x.Value); ok {
+			// A name after a dot or in a table constructor.
+			switch b := e.binding(v).Expr.(type) {
+			case *ast.IdentExpr:
+				out = &ast.StringExpr{Value: b.Value}
+			default:
+				return copyExpr(b)
+			}
+			break
+		}
+		out = &ast.StringExpr{Value: x.Value}
+	case *ast.NumberExpr:
+		out = &ast.NumberExpr{Value: x.Value, Float: x.Float}
+	case *ast.NilExpr:
+		out = &ast.NilExpr{}
+	case *ast.TrueExpr:
+		out = &ast.TrueExpr{}
+	case *ast.FalseExpr:
+		out = &ast.FalseExpr{}
+	case *ast.Comma3Expr:
+		out = &ast.Comma3Expr{}
+	case *ast.AttrGetExpr:
+		out = &ast.AttrGetExpr{Object: e.expr(x.Object), Key: e.expr(x.Key)}
+	case *ast.TableExpr:
+		fields := make([]*ast.Field, len(x.Fields))
+		for i, field := range x.Fields {
+			fields[i] = &ast.Field{Key: e.expr(field.Key), Value: e.expr(field.Value)}
+		}
+		out = &ast.TableExpr{Fields: fields}
+	case *ast.FuncCallExpr:
+		call := &ast.FuncCallExpr{
+			Func:      e.expr(x.Func),
+			Receiver:  e.expr(x.Receiver),
+			Args:      e.exprs(x.Args),
+			AdjustRet: x.AdjustRet,
+		}
+		if x.Receiver != nil {
+			call.Method = e.name(x.Method)
+		}
+		out = call
+	case *ast.LogicalOpExpr:
+		out = &ast.LogicalOpExpr{Operator: x.Operator, Lhs: e.expr(x.Lhs), Rhs: e.expr(x.Rhs)}
+	case *ast.RelationalOpExpr:
+		out = &ast.RelationalOpExpr{Operator: x.Operator, Lhs: e.expr(x.Lhs), Rhs: e.expr(x.Rhs)}
+	case *ast.StringConcatOpExpr:
+		out = &ast.StringConcatOpExpr{Lhs: e.expr(x.Lhs), Rhs: e.expr(x.Rhs)}
+	case *ast.ArithmeticOpExpr:
+		out = &ast.ArithmeticOpExpr{Operator: x.Operator, Lhs: e.expr(x.Lhs), Rhs: e.expr(x.Rhs)}
+	case *ast.UnaryOpExpr:
+		out = &ast.UnaryOpExpr{Operator: x.Operator, Expr: e.expr(x.Expr)}
+	case *ast.FunctionExpr:
+		out = e.function(x)
+	default:
+		panic(fmt.Sprintf("match: unknown expression %T", ex))
+	}
+	e.pos(out, ex)
+	return out
+}
+
+func (e *expander) function(fn *ast.FunctionExpr) *ast.FunctionExpr {
+	out := &ast.FunctionExpr{
+		ParList: &ast.ParList{HasVargs: fn.ParList.HasVargs, Names: e.names(fn.ParList.Names)},
+		Chunk:   e.chunk(fn.Chunk),
+	}
+	e.pos(out, fn)
+	return out
+}
+
+func (e *expander) chunk(stmts []ast.Stmt) ast.Chunk {
+	out := make(ast.Chunk, 0, len(stmts))
+	for _, st := range stmts {
+		if e.p == nil {
+			out = append(out, e.stmt(st))
+			continue
+		}
+		if v, ok := e.p.seqMeta(st); ok {
+			out = append(out, copyStmts(e.binding(v).Stmts)...)
+			continue
+		}
+		out = append(out, e.stmt(st))
+	}
+	return out
+}
+
+func (e *expander) stmt(st ast.Stmt) ast.Stmt {
+	var out ast.Stmt
+	switch s := st.(type) {
+	case *ast.AssignStmt:
+		out = &ast.AssignStmt{Lhs: e.exprs(s.Lhs), Rhs: e.exprs(s.Rhs)}
+	case *ast.CompoundAssignStmt:
+		out = &ast.CompoundAssignStmt{Operator: s.Operator, Lhs: e.exprs(s.Lhs), Rhs: e.exprs(s.Rhs)}
+	case *ast.LocalAssignStmt:
+		out = &ast.LocalAssignStmt{Names: e.names(s.Names), Exprs: e.exprs(s.Exprs)}
+	case *ast.FuncCallStmt:
+		out = &ast.FuncCallStmt{Expr: e.expr(s.Expr)}
+	case *ast.DoBlockStmt:
+		out = &ast.DoBlockStmt{Chunk: e.chunk(s.Chunk)}
+	case *ast.WhileStmt:
+		out = &ast.WhileStmt{Condition: e.expr(s.Condition), Chunk: e.chunk(s.Chunk)}
+	case *ast.RepeatStmt:
+		out = &ast.RepeatStmt{Condition: e.expr(s.Condition), Chunk: e.chunk(s.Chunk)}
+	case *ast.IfStmt:
+		out = &ast.IfStmt{Condition: e.expr(s.Condition), Then: e.chunk(s.Then), Else: e.chunk(s.Else)}
+	case *ast.NumberForStmt:
+		out = &ast.NumberForStmt{
+			Name:  e.name(s.Name),
+			Init:  e.expr(s.Init),
+			Limit: e.expr(s.Limit),
+			Step:  e.expr(s.Step),
+			Chunk: e.chunk(s.Chunk),
+		}
+	case *ast.GenericForStmt:
+		out = &ast.GenericForStmt{Names: e.names(s.Names), Exprs: e.exprs(s.Exprs), Chunk: e.chunk(s.Chunk)}
+	case *ast.LocalFunctionStmt:
+		out = &ast.LocalFunctionStmt{Name: e.name(s.Name), Func: e.function(s.Func)}
+	case *ast.FunctionStmt:
+		name := &ast.FuncName{Func: e.expr(s.Name.Func), Receiver: e.expr(s.Name.Receiver)}
+		if s.Name.Receiver != nil {
+			name.Method = e.name(s.Name.Method)
+		}
+		out = &ast.FunctionStmt{Name: name, Func: e.function(s.Func)}
+	case *ast.ReturnStmt:
+		out = &ast.ReturnStmt{Exprs: e.exprs(s.Exprs)}
+	case *ast.BreakStmt:
+		out = &ast.BreakStmt{}
+	case *ast.ContinueStmt:
+		out = &ast.ContinueStmt{}
+	case *ast.LabelStmt:
+		out = &ast.LabelStmt{Name: e.name(s.Name)}
+	case *ast.GotoStmt:
+		out = &ast.GotoStmt{Label: e.name(s.Label)}
+	default:
+		panic(fmt.Sprintf("match: unknown statement %T", st))
+	}
+	e.pos(out, st)
+	return out
+}
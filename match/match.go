@@ -0,0 +1,358 @@
+package match
+
+import (
+	"sort"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+)
+
+// Binding is the code a metavariable matched.
+type Binding struct {
+	Expr  ast.Expr   // set for $x; a name is bound as an *ast.IdentExpr
+	Stmts []ast.Stmt // set for $$x
+}
+
+// Match is a piece of code matching a pattern.
+type Match struct {
+	Line     int        // first source line of the match
+	LastLine int        // last known source line of the match
+	Expr     ast.Expr   // the matched expression, for expression patterns
+	Stmts    []ast.Stmt // the matched statements, for statement patterns
+
+	// Bindings maps the name of each metavariable, without the $ or $$, to
+	// the code it matched. $_ is never bound.
+	Bindings map[string]Binding
+}
+
+// Find returns the matches of p in chunk ordered by line. Expression
+// patterns also match inside matched expressions; the statements matched
+// by a statement pattern do not overlap within a block.
+func (p *Pattern) Find(chunk ast.Chunk) []*Match {
+	var matches []*Match
+	ast.Inspect(chunk, func(node interface{}) bool {
+		switch n := node.(type) {
+		case ast.Expr:
+			if p.expr == nil {
+				break
+			}
+			if bindings, ok := p.MatchExpr(n); ok {
+				matches = append(matches, &Match{
+					Line:     n.Line(),
+					LastLine: lastLine(n),
+					Expr:     n,
+					Bindings: bindings,
+				})
+			}
+		case ast.Chunk:
+			if p.stmts == nil {
+				break
+			}
+			for i := 0; i < len(n); {
+				size, bindings, ok := p.MatchStmts(n[i:])
+				if !ok || size == 0 {
+					i++
+					continue
+				}
+				stmts := n[i : i+size]
+				matches = append(matches, &Match{
+					Line:     stmts[0].Line(),
+					LastLine: lastLine(stmts[len(stmts)-1]),
+					Stmts:    stmts,
+					Bindings: bindings,
+				})
+				i += size
+			}
+		}
+		return true
+	})
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Line < matches[j].Line })
+	return matches
+}
+
+// MatchExpr reports whether the expression pattern p matches ex and
+// returns the bindings of its metavariables.
+func (p *Pattern) MatchExpr(ex ast.Expr) (map[string]Binding, bool) {
+	if p.expr == nil {
+		return nil, false
+	}
+	m := newMatcher(p)
+	if !m.expr(p.expr, ex) {
+		return nil, false
+	}
+	return m.bindings, true
+}
+
+// MatchStmts reports whether the statement pattern p matches a prefix of
+// stmts. It returns the number of statements matched, preferring the
+// longest match, and the bindings of the metavariables.
+func (p *Pattern) MatchStmts(stmts []ast.Stmt) (int, map[string]Binding, bool) {
+	if p.stmts == nil {
+		return 0, nil, false
+	}
+	m := newMatcher(p)
+	n, ok := m.seq(p.stmts, stmts, false)
+	if !ok {
+		return 0, nil, false
+	}
+	return n, m.bindings, true
+}
+
+func lastLine(node ast.PositionHolder) int {
+	if node.LastLine() > node.Line() {
+		return node.LastLine()
+	}
+	return node.Line()
+}
+
+// matcher matches a pattern against code and collects the bindings. The
+// pattern is the first argument of every method.
+type matcher struct {
+	p        *Pattern
+	bindings map[string]Binding
+	bound    []string // names in bindings, in the order they were bound
+}
+
+func newMatcher(p *Pattern) *matcher {
+	return &matcher{p: p, bindings: make(map[string]Binding)}
+}
+
+// mark returns the state to undo to when a match fails part way through.
+func (m *matcher) mark() int { return len(m.bound) }
+
+func (m *matcher) undo(mark int) {
+	for _, name := range m.bound[mark:] {
+		delete(m.bindings, name)
+	}
+	m.bound = m.bound[:mark]
+}
+
+// bind binds the metavariable v to b, or checks that b equals the code it
+// is already bound to.
+func (m *matcher) bind(v meta, b Binding) bool {
+	if v.Name == "_" {
+		return true
+	}
+	prev, ok := m.bindings[v.Name]
+	if !ok {
+		m.bindings[v.Name] = b
+		m.bound = append(m.bound, v.Name)
+		return true
+	}
+	// Code without metavariables only matches equal code.
+	eq := newMatcher(&Pattern{})
+	if v.Seq {
+		_, ok := eq.seq(prev.Stmts, b.Stmts, true)
+		return ok
+	}
+	return eq.expr(prev.Expr, b.Expr)
+}
+
+// name matches a name, such as the name of a local variable.
+func (m *matcher) name(pat, name string, line int) bool {
+	if v, ok := m.p.meta(pat); ok {
+		ident := &ast.IdentExpr{Value: name}
+		ident.SetLine(line)
+		return (v.Kind == "" || v.Kind == "ident") && m.bind(v, Binding{Expr: ident})
+	}
+	return pat == name
+}
+
+func (m *matcher) names(pat, names []string, line int) bool {
+	if len(pat) != len(names) {
+		return false
+	}
+	for i := range pat {
+		if !m.name(pat[i], names[i], line) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *matcher) exprs(pat, exprs []ast.Expr) bool {
+	if len(pat) != len(exprs) {
+		return false
+	}
+	for i := range pat {
+		if !m.expr(pat[i], exprs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *matcher) expr(pat, ex ast.Expr) bool {
+	if pat == nil || ex == nil {
+		return pat == nil && ex == nil
+	}
+	switch p := pat.(type) {
+	case *ast.IdentExpr:
+		if v, ok := m.p.meta(p.Value); ok {
+			if v.Kind != "" && !kinds[v.Kind](ex) {
+				return false
+			}
+			return m.bind(v, Binding{Expr: ex})
+		}
+		e, ok := ex.(*ast.IdentExpr)
+		return ok && e.Value == p.Value
+	case *ast.StringExpr:
+		e, ok := ex.(*ast.StringExpr)
+		if v, meta := m.p.meta(p.Value); meta && ok {
+			return m.bind(v, Binding{Expr: e}) // a name after a dot or in a table
+		}
+		return ok && e.Value == p.Value
+	case *ast.NumberExpr:
+		e, ok := ex.(*ast.NumberExpr)
+		return ok && e.Value == p.Value && e.Float == p.Float
+	case *ast.NilExpr:
+		_, ok := ex.(*ast.NilExpr)
+		return ok
+	case *ast.TrueExpr:
+		_, ok := ex.(*ast.TrueExpr)
+		return ok
+	case *ast.FalseExpr:
+		_, ok := ex.(*ast.FalseExpr)
+		return ok
+	case *ast.Comma3Expr:
+		_, ok := ex.(*ast.Comma3Expr)
+		return ok
+	case *ast.AttrGetExpr:
+		e, ok := ex.(*ast.AttrGetExpr)
+		return ok && m.expr(p.Object, e.Object) && m.expr(p.Key, e.Key)
+	case *ast.TableExpr:
+		e, ok := ex.(*ast.TableExpr)
+		if !ok || len(p.Fields) != len(e.Fields) {
+			return false
+		}
+		for i, field := range p.Fields {
+			if !m.expr(field.Key, e.Fields[i].Key) || !m.expr(field.Value, e.Fields[i].Value) {
+				return false
+			}
+		}
+		return true
+	case *ast.FuncCallExpr:
+		e, ok := ex.(*ast.FuncCallExpr)
+		// (f()) truncates the results of f() to one value.
+		return ok && p.AdjustRet == e.AdjustRet && m.expr(p.Func, e.Func) && m.expr(p.Receiver, e.Receiver) &&
+			(p.Receiver == nil || m.name(p.Method, e.Method, e.Line())) && m.exprs(p.Args, e.Args)
+	case *ast.LogicalOpExpr:
+		e, ok := ex.(*ast.LogicalOpExpr)
+		return ok && p.Operator == e.Operator && m.expr(p.Lhs, e.Lhs) && m.expr(p.Rhs, e.Rhs)
+	case *ast.RelationalOpExpr:
+		e, ok := ex.(*ast.RelationalOpExpr)
+		return ok && p.Operator == e.Operator && m.expr(p.Lhs, e.Lhs) && m.expr(p.Rhs, e.Rhs)
+	case *ast.StringConcatOpExpr:
+		e, ok := ex.(*ast.StringConcatOpExpr)
+		return ok && m.expr(p.Lhs, e.Lhs) && m.expr(p.Rhs, e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		e, ok := ex.(*ast.ArithmeticOpExpr)
+		return ok && p.Operator == e.Operator && m.expr(p.Lhs, e.Lhs) && m.expr(p.Rhs, e.Rhs)
+	case *ast.UnaryOpExpr:
+		e, ok := ex.(*ast.UnaryOpExpr)
+		return ok && p.Operator == e.Operator && m.expr(p.Expr, e.Expr)
+	case *ast.FunctionExpr:
+		e, ok := ex.(*ast.FunctionExpr)
+		return ok && m.function(p, e)
+	}
+	return false
+}
+
+func (m *matcher) function(pat, fn *ast.FunctionExpr) bool {
+	if pat.ParList.HasVargs != fn.ParList.HasVargs || !m.names(pat.ParList.Names, fn.ParList.Names, fn.Line()) {
+		return false
+	}
+	return m.block(pat.Chunk, fn.Chunk)
+}
+
+// block matches a whole block.
+func (m *matcher) block(pat, stmts ast.Chunk) bool {
+	_, ok := m.seq(pat, stmts, true)
+	return ok
+}
+
+// seq matches pat against a prefix of stmts, or against all of them if
+// full is set, and returns the number of statements matched. A $$
+// metavariable takes as many statements as it can.
+func (m *matcher) seq(pat, stmts []ast.Stmt, full bool) (int, bool) {
+	if len(pat) == 0 {
+		return 0, !full || len(stmts) == 0
+	}
+	mark := m.mark()
+	if v, ok := m.p.seqMeta(pat[0]); ok {
+		for n := len(stmts); n >= 0; n-- {
+			if m.bind(v, Binding{Stmts: stmts[:n:n]}) {
+				if rest, ok := m.seq(pat[1:], stmts[n:], full); ok {
+					return n + rest, true
+				}
+			}
+			m.undo(mark)
+		}
+		return 0, false
+	}
+	if len(stmts) > 0 && m.stmt(pat[0], stmts[0]) {
+		if rest, ok := m.seq(pat[1:], stmts[1:], full); ok {
+			return 1 + rest, true
+		}
+	}
+	m.undo(mark)
+	return 0, false
+}
+
+func (m *matcher) stmt(pat, st ast.Stmt) bool {
+	switch p := pat.(type) {
+	case *ast.AssignStmt:
+		s, ok := st.(*ast.AssignStmt)
+		return ok && m.exprs(p.Lhs, s.Lhs) && m.exprs(p.Rhs, s.Rhs)
+	case *ast.CompoundAssignStmt:
+		s, ok := st.(*ast.CompoundAssignStmt)
+		return ok && p.Operator == s.Operator && m.exprs(p.Lhs, s.Lhs) && m.exprs(p.Rhs, s.Rhs)
+	case *ast.LocalAssignStmt:
+		s, ok := st.(*ast.LocalAssignStmt)
+		return ok && m.names(p.Names, s.Names, s.Line()) && m.exprs(p.Exprs, s.Exprs)
+	case *ast.FuncCallStmt:
+		s, ok := st.(*ast.FuncCallStmt)
+		return ok && m.expr(p.Expr, s.Expr)
+	case *ast.DoBlockStmt:
+		s, ok := st.(*ast.DoBlockStmt)
+		return ok && m.block(p.Chunk, s.Chunk)
+	case *ast.WhileStmt:
+		s, ok := st.(*ast.WhileStmt)
+		return ok && m.expr(p.Condition, s.Condition) && m.block(p.Chunk, s.Chunk)
+	case *ast.RepeatStmt:
+		s, ok := st.(*ast.RepeatStmt)
+		return ok && m.block(p.Chunk, s.Chunk) && m.expr(p.Condition, s.Condition)
+	case *ast.IfStmt:
+		s, ok := st.(*ast.IfStmt)
+		return ok && m.expr(p.Condition, s.Condition) && m.block(p.Then, s.Then) && m.block(p.Else, s.Else)
+	case *ast.NumberForStmt:
+		s, ok := st.(*ast.NumberForStmt)
+		return ok && m.name(p.Name, s.Name, s.Line()) && m.expr(p.Init, s.Init) &&
+			m.expr(p.Limit, s.Limit) && m.expr(p.Step, s.Step) && m.block(p.Chunk, s.Chunk)
+	case *ast.GenericForStmt:
+		s, ok := st.(*ast.GenericForStmt)
+		return ok && m.names(p.Names, s.Names, s.Line()) && m.exprs(p.Exprs, s.Exprs) && m.block(p.Chunk, s.Chunk)
+	case *ast.LocalFunctionStmt:
+		s, ok := st.(*ast.LocalFunctionStmt)
+		return ok && m.name(p.Name, s.Name, s.Line()) && m.function(p.Func, s.Func)
+	case *ast.FunctionStmt:
+		s, ok := st.(*ast.FunctionStmt)
+		return ok && m.expr(p.Name.Func, s.Name.Func) && m.expr(p.Name.Receiver, s.Name.Receiver) &&
+			(p.Name.Receiver == nil || m.name(p.Name.Method, s.Name.Method, s.Line())) && m.function(p.Func, s.Func)
+	case *ast.ReturnStmt:
+		s, ok := st.(*ast.ReturnStmt)
+		return ok && m.exprs(p.Exprs, s.Exprs)
+	case *ast.BreakStmt:
+		_, ok := st.(*ast.BreakStmt)
+		return ok
+	case *ast.ContinueStmt:
+		_, ok := st.(*ast.ContinueStmt)
+		return ok
+	case *ast.LabelStmt:
+		s, ok := st.(*ast.LabelStmt)
+		return ok && m.name(p.Name, s.Name, s.Line())
+	case *ast.GotoStmt:
+		s, ok := st.(*ast.GotoStmt)
+		return ok && m.name(p.Label, s.Label, s.Line())
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package match
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+const target = `local cmd = "ls " .. dir
+os.execute(cmd)
+local ok = os.execute("rm -rf " .. path)
+if os.execute(cmd) then
+	print(x == x, x == y)
+end
+
+local function load(name)
+	local f = io.open(name)
+	local data = f:read("*a")
+	f:close()
+	return data
+end
+`
+
+func find(t *testing.T, pattern string) []*Match {
+	t.Helper()
+	chunk, err := parse.Parse(strings.NewReader(target), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Find(chunk)
+}
+
+func TestFindExpr(t *testing.T) {
+	matches := find(t, "os.execute($cmd)")
+	lines := []int{2, 3, 4}
+	if len(matches) != len(lines) {
+		t.Fatalf("Expected %d matches, got %d", len(lines), len(matches))
+	}
+	for i, m := range matches {
+		if m.Line != lines[i] {
+			t.Errorf("Expected match %d on line %d, got %d", i, lines[i], m.Line)
+		}
+	}
+	if cmd := matches[1].Bindings["cmd"].Expr.String(); cmd != `"rm -rf " .. path` {
+		t.Errorf("Expected $cmd to be bound to the concatenation, got %s", cmd)
+	}
+}
+
+func TestFindAdjustRet(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("local a = f()\nlocal b = (f())\nreturn f(), (f())"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		pattern string
+		lines   []int
+	}{
+		{"f()", []int{1, 3}},
+		{"(f())", []int{2, 3}},
+	} {
+		p, err := Compile(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []int
+		for _, m := range p.Find(chunk) {
+			lines = append(lines, m.Line)
+		}
+		if fmt.Sprint(lines) != fmt.Sprint(test.lines) {
+			t.Errorf("%s: Expected matches on lines %v, got %v", test.pattern, test.lines, lines)
+		}
+	}
+}
+
+func TestFindKinds(t *testing.T) {
+	if matches := find(t, "os.execute($cmd:ident)"); len(matches) != 2 {
+		t.Errorf("Expected 2 matches with an identifier, got %d", len(matches))
+	}
+	if matches := find(t, `$a .. $s:string`); len(matches) != 0 {
+		t.Errorf("Expected no concatenation ending in a string, got %d", len(matches))
+	}
+	if matches := find(t, `$s:string .. $_`); len(matches) != 2 {
+		t.Errorf("Expected 2 concatenations starting with a string, got %d", len(matches))
+	}
+}
+
+func TestFindRepeated(t *testing.T) {
+	matches := find(t, "$x == $x")
+	if len(matches) != 1 || matches[0].Expr.String() != "x == x" {
+		t.Fatalf("Expected to match x == x only, got %v", matches)
+	}
+}
+
+func TestFindStmts(t *testing.T) {
+	matches := find(t, `
+local $f = io.open($name)
+$$body
+$f:close()`)
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 match, got %d", len(matches))
+	}
+	m := matches[0]
+	if m.Line != 9 || m.LastLine != 11 || len(m.Stmts) != 3 {
+		t.Errorf("Expected 3 statements on lines 9-11, got %d on %d-%d", len(m.Stmts), m.Line, m.LastLine)
+	}
+	if f := m.Bindings["f"].Expr.(*ast.IdentExpr).Value; f != "f" {
+		t.Errorf("Expected $f to be bound to f, got %s", f)
+	}
+	if body := m.Bindings["body"].Stmts; len(body) != 1 || body[0].Line() != 10 {
+		t.Errorf("Expected $$body to be bound to line 10, got %v", body)
+	}
+}
+
+func TestFindBlock(t *testing.T) {
+	matches := find(t, "if $c then $$body end")
+	if len(matches) != 1 || matches[0].Line != 4 || matches[0].LastLine != 6 {
+		t.Fatalf("Expected to match the if statement, got %v", matches)
+	}
+	if matches := find(t, "local function $f($x) $$body end"); len(matches) != 1 {
+		t.Errorf("Expected to match the local function, got %d", len(matches))
+	}
+	if matches := find(t, "local function $f() $$body end"); len(matches) != 0 {
+		t.Errorf("Expected no function without parameters, got %d", len(matches))
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"$",
+		"$1",
+		"f($$args)",
+		"$$s:string",
+		"f($x:string, $x)",
+		"local = 1",
+	} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("%q: Expected an error", pattern)
+		}
+	}
+}
+
+func TestCompileStrings(t *testing.T) {
+	p := MustCompile(`print("$x", '$y', [[$z]]) -- $w`)
+	if len(p.metas) != 0 {
+		t.Errorf("Expected no metavariables in strings or comments, got %v", p.metas)
+	}
+	p = MustCompile(`$s:string()`)
+	if len(p.metas) != 1 || p.metas[0].Kind != "" {
+		t.Errorf("Expected a method call, got %v", p.metas)
+	}
+}
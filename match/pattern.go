@@ -0,0 +1,346 @@
+// Package match finds lua code that has the shape of a pattern.
+//
+// Patterns are lua code with metavariables in place of the parts that may
+// vary:
+//
+//	$x         any expression, or any name where lua expects a name
+//	$$body     any sequence of statements, including an empty one
+//	$s:string  an expression of the given kind, which is one of string,
+//	           number, ident, func, table or call
+//	$_         like $x, but without binding anything
+//
+// A metavariable used twice only matches if both places hold the same
+// code, so `$x == $x` finds comparisons of an expression with itself.
+//
+// A pattern that is a single expression, such as `os.execute($cmd)`, is
+// matched against every expression in a chunk. Any other pattern is a
+// sequence of statements and is matched against every run of consecutive
+// statements in a block.
+package match
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+// Kinds of expressions a typed metavariable accepts.
+var kinds = map[string]func(ex ast.Expr) bool{
+	"string": func(ex ast.Expr) bool { _, ok := ex.(*ast.StringExpr); return ok },
+	"number": func(ex ast.Expr) bool { _, ok := ex.(*ast.NumberExpr); return ok },
+	"ident":  func(ex ast.Expr) bool { _, ok := ex.(*ast.IdentExpr); return ok },
+	"func":   func(ex ast.Expr) bool { _, ok := ex.(*ast.FunctionExpr); return ok },
+	"table":  func(ex ast.Expr) bool { _, ok := ex.(*ast.TableExpr); return ok },
+	"call":   func(ex ast.Expr) bool { _, ok := ex.(*ast.FuncCallExpr); return ok },
+}
+
+// metaPrefix starts the identifiers that stand in for metavariables while
+// the pattern is parsed.
+const metaPrefix = "__match_"
+
+// meta describes a metavariable.
+type meta struct {
+	Name string // without the $ or $$
+	Seq  bool   // $$name
+	Kind string // "" for any expression
+}
+
+// Pattern is a compiled pattern.
+type Pattern struct {
+	src   string
+	metas []meta
+	expr  ast.Expr   // set for expression patterns
+	stmts []ast.Stmt // set for statement patterns
+}
+
+// Compile parses a pattern.
+func Compile(src string) (*Pattern, error) {
+	p := &Pattern{src: src}
+	code, err := p.replaceMetas(src)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(code), "<pattern>")
+	if err == nil {
+		if st, ok := singleCall(chunk); ok && !p.isSeq(st) {
+			p.expr = st.Expr
+		} else {
+			p.stmts = chunk
+		}
+	} else {
+		// Patterns like `$a .. $b` are not statements.
+		ret, rerr := parse.Parse(strings.NewReader("return "+code), "<pattern>")
+		if rerr != nil || len(ret) != 1 || len(ret[0].(*ast.ReturnStmt).Exprs) != 1 {
+			return nil, fmt.Errorf("match: %v", err)
+		}
+		p.expr = ret[0].(*ast.ReturnStmt).Exprs[0]
+	}
+	if len(p.stmts) == 0 && p.expr == nil {
+		return nil, fmt.Errorf("match: empty pattern")
+	}
+	return p, p.check()
+}
+
+// MustCompile is like Compile but panics if the pattern cannot be parsed.
+func MustCompile(src string) *Pattern {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source of the pattern.
+func (p *Pattern) String() string { return p.src }
+
+// IsExpr reports whether p is an expression pattern.
+func (p *Pattern) IsExpr() bool { return p.expr != nil }
+
+// IsCall reports whether p is an expression pattern of a function call,
+// which also stands for a call statement.
+func (p *Pattern) IsCall() bool {
+	_, ok := p.expr.(*ast.FuncCallExpr)
+	return ok
+}
+
+func singleCall(chunk ast.Chunk) (*ast.FuncCallStmt, bool) {
+	if len(chunk) != 1 {
+		return nil, false
+	}
+	st, ok := chunk[0].(*ast.FuncCallStmt)
+	return st, ok
+}
+
+// replaceMetas replaces each metavariable in src with an identifier lua
+// can parse. Strings and comments are left alone.
+func (p *Pattern) replaceMetas(src string) (string, error) {
+	b := &strings.Builder{}
+	index := make(map[string]int)
+	for i := 0; i < len(src); {
+		if j := skipText(src, i); j > i {
+			b.WriteString(src[i:j])
+			i = j
+			continue
+		}
+		switch ch := src[i]; {
+		case ch == '$':
+			m := meta{}
+			j := i + 1
+			if j < len(src) && src[j] == '$' {
+				m.Seq = true
+				j++
+			}
+			k := j
+			for k < len(src) && isIdent(src[k]) {
+				k++
+			}
+			if k == j || isDecimal(src[j]) {
+				return "", fmt.Errorf("match: metavariable name expected at offset %d", k)
+			}
+			m.Name = src[j:k]
+			if kind, n := metaKind(src[k:]); n > 0 {
+				if m.Seq {
+					return "", fmt.Errorf("match: $$%s cannot have a kind", m.Name)
+				}
+				m.Kind = kind
+				k += n
+			}
+
+			key := m.Name
+			if m.Name == "_" {
+				key = "" // every $_ is a new metavariable
+			}
+			id, ok := index[key]
+			if !ok || key == "" {
+				id = len(p.metas)
+				index[key] = id
+				p.metas = append(p.metas, m)
+			} else if p.metas[id] != m {
+				return "", fmt.Errorf("match: $%s is used in different ways", m.Name)
+			}
+			b.WriteString(metaPrefix + strconv.Itoa(id))
+			if m.Seq {
+				b.WriteString("()")
+			}
+			i = k
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// metaKind returns the kind following a metavariable at the start of s and
+// the length of the :kind suffix, or 0 if there is none. A method call
+// such as $s:string() is not a kind.
+func metaKind(s string) (string, int) {
+	if len(s) == 0 || s[0] != ':' {
+		return "", 0
+	}
+	n := 1
+	for n < len(s) && isIdent(s[n]) {
+		n++
+	}
+	kind := s[1:n]
+	if _, ok := kinds[kind]; !ok {
+		return "", 0
+	}
+	rest := strings.TrimLeft(s[n:], " \t")
+	if rest != "" && strings.ContainsRune("({\"'[", rune(rest[0])) {
+		return "", 0
+	}
+	return kind, n
+}
+
+// check reports metavariables used where they cannot match.
+func (p *Pattern) check() error {
+	var err error
+	ast.Inspect(p.root(), func(node interface{}) bool {
+		if err != nil {
+			return false
+		}
+		switch n := node.(type) {
+		case *ast.IdentExpr:
+			if m, ok := p.meta(n.Value); ok && m.Seq {
+				err = fmt.Errorf("match: $$%s must be used as a statement", m.Name)
+			}
+		case *ast.FuncCallStmt:
+			return !p.isSeq(n)
+		}
+		return true
+	})
+	return err
+}
+
+func (p *Pattern) root() interface{} {
+	if p.expr != nil {
+		return p.expr
+	}
+	return ast.Chunk(p.stmts)
+}
+
+// meta returns the metavariable that name stands for.
+func (p *Pattern) meta(name string) (meta, bool) {
+	if !strings.HasPrefix(name, metaPrefix) {
+		return meta{}, false
+	}
+	id, err := strconv.Atoi(name[len(metaPrefix):])
+	if err != nil || id < 0 || id >= len(p.metas) {
+		return meta{}, false
+	}
+	return p.metas[id], true
+}
+
+// seqMeta returns the $$ metavariable that the statement st stands for.
+func (p *Pattern) seqMeta(st ast.Stmt) (meta, bool) {
+	call, ok := st.(*ast.FuncCallStmt)
+	if !ok {
+		return meta{}, false
+	}
+	ex, ok := call.Expr.(*ast.FuncCallExpr)
+	if !ok || ex.Func == nil || len(ex.Args) != 0 {
+		return meta{}, false
+	}
+	ident, ok := ex.Func.(*ast.IdentExpr)
+	if !ok {
+		return meta{}, false
+	}
+	m, ok := p.meta(ident.Value)
+	return m, ok && m.Seq
+}
+
+func (p *Pattern) isSeq(st ast.Stmt) bool {
+	_, ok := p.seqMeta(st)
+	return ok
+}
+
+func isIdent(ch byte) bool {
+	return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || isDecimal(ch)
+}
+
+func isDecimal(ch byte) bool { return '0' <= ch && ch <= '9' }
+
+// Split slices src into the substrings separated by sep, like
+// strings.Split, but leaves alone the instances of sep in strings and
+// comments.
+func Split(src, sep string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(src); {
+		if j := skipText(src, i); j > i {
+			i = j
+			continue
+		}
+		switch {
+		case strings.HasPrefix(src[i:], sep):
+			parts = append(parts, src[start:i])
+			i += len(sep)
+			start = i
+		default:
+			i++
+		}
+	}
+	return append(parts, src[start:])
+}
+
+// skipText returns the offset after the string or the comment starting at
+// src[i], or i if there is none.
+func skipText(src string, i int) int {
+	switch {
+	case src[i] == '"' || src[i] == '\'':
+		return skipString(src, i)
+	case src[i] == '[' && longLevel(src, i) >= 0:
+		return skipLong(src, i)
+	case strings.HasPrefix(src[i:], "--"):
+		j := i + 2
+		if j < len(src) && src[j] == '[' && longLevel(src, j) >= 0 {
+			return skipLong(src, j)
+		}
+		if k := strings.IndexAny(src[j:], "\r\n"); k >= 0 {
+			return j + k
+		}
+		return len(src)
+	}
+	return i
+}
+
+// skipString returns the offset after the quoted string starting at src[i].
+func skipString(src string, i int) int {
+	quote := src[i]
+	for i++; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote, '\n':
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// longLevel returns the level of the long bracket starting at src[i], or
+// -1 if there is none.
+func longLevel(src string, i int) int {
+	level := 0
+	for i++; i < len(src) && src[i] == '='; i++ {
+		level++
+	}
+	if i < len(src) && src[i] == '[' {
+		return level
+	}
+	return -1
+}
+
+// skipLong returns the offset after the long bracket starting at src[i].
+func skipLong(src string, i int) int {
+	end := "]" + strings.Repeat("=", longLevel(src, i)) + "]"
+	if j := strings.Index(src[i:], end); j >= 0 {
+		return i + j + len(end)
+	}
+	return len(src)
+}
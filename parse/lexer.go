@@ -9,12 +9,13 @@ import (
 	"strconv"
 	"strings"
 
+	luautil "github.com/hootrhino/beautiful-lua-go"
 	"github.com/hootrhino/beautiful-lua-go/ast"
 )
 
 const EOF = -1
-const whitespace1 = 1<<'\t' | 1<<' '
-const whitespace2 = 1<<'\t' | 1<<'\n' | 1<<'\r' | 1<<' '
+const whitespace1 = 1<<'\t' | 1<<'\v' | 1<<'\f' | 1<<' '
+const whitespace2 = 1<<'\t' | 1<<'\n' | 1<<'\v' | 1<<'\f' | 1<<'\r' | 1<<' '
 
 type Error struct {
 	Pos     ast.Position
@@ -117,7 +118,10 @@ func (sc *Scanner) skipWhiteSpace(whitespace int64) int {
 	return ch
 }
 
-func (sc *Scanner) skipComments(ch int) error {
+// skipComments skips the comment starting at ch, the character after the
+// first '-'. The text of a line comment, from that character to the end of
+// the line, is written to buf; long comments are only skipped.
+func (sc *Scanner) skipComments(ch int, buf *bytes.Buffer) error {
 	// multiline comment
 	if sc.Peek() == '[' {
 		ch = sc.Next()
@@ -129,10 +133,8 @@ func (sc *Scanner) skipComments(ch int) error {
 			return nil
 		}
 	}
-	for {
-		if ch == '\n' || ch == '\r' || ch < 0 {
-			break
-		}
+	for ch != '\n' && ch != '\r' && ch >= 0 {
+		writeChar(buf, ch)
 		ch = sc.Next()
 	}
 	return nil
@@ -158,7 +160,9 @@ func (sc *Scanner) scanDecimal(ch int, buf *bytes.Buffer) error {
 	return nil
 }
 
-func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, error) {
+// scanNumber returns the value of the number literal, and whether it is
+// a float rather than an integer.
+func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, bool, error) {
 	if ch == '0' {
 		switch sc.Peek() {
 		case 'x', 'X':
@@ -166,7 +170,7 @@ func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, error) {
 			if !isDigit(sc.Peek()) {
 				writeChar(buf, ch)
 				writeChar(buf, n)
-				return 0, sc.Error(buf.String(), "hex number expected")
+				return 0, false, sc.Error(buf.String(), "hex number expected")
 			}
 			for isDigit(sc.Peek()) || sc.Peek() == '_' {
 				if sc.Peek() == '_' {
@@ -176,13 +180,13 @@ func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, error) {
 				writeChar(buf, sc.Next())
 			}
 			val, err := strconv.ParseInt(buf.String(), 16, 64)
-			return float64(val), err
+			return float64(val), false, err
 		case 'b', 'B':
 			n := sc.Next()
 			if !isBinary(sc.Peek()) {
 				writeChar(buf, ch)
 				writeChar(buf, n)
-				return 0, sc.Error(buf.String(), "binary number expected")
+				return 0, false, sc.Error(buf.String(), "binary number expected")
 			}
 			for isBinary(sc.Peek()) || sc.Peek() == '_' {
 				if sc.Peek() == '_' {
@@ -192,13 +196,13 @@ func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, error) {
 				writeChar(buf, sc.Next())
 			}
 			val, err := strconv.ParseInt(buf.String(), 2, 64)
-			return float64(val), err
+			return float64(val), false, err
 		case 'o', 'O':
 			n := sc.Next()
 			if !isOctal(sc.Peek()) {
 				writeChar(buf, ch)
 				writeChar(buf, n)
-				return 0, sc.Error(buf.String(), "octal number expected")
+				return 0, false, sc.Error(buf.String(), "octal number expected")
 			}
 			for isOctal(sc.Peek()) || sc.Peek() == '_' {
 				if sc.Peek() == '_' {
@@ -208,7 +212,7 @@ func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, error) {
 				writeChar(buf, sc.Next())
 			}
 			val, err := strconv.ParseInt(buf.String(), 8, 64)
-			return float64(val), err
+			return float64(val), false, err
 		default:
 			if sc.Peek() != '.' && isDecimal(sc.Peek()) {
 				ch = sc.Next()
@@ -226,108 +230,58 @@ func (sc *Scanner) scanNumber(ch int, buf *bytes.Buffer) (float64, error) {
 		}
 		sc.scanDecimal(sc.Next(), buf)
 	}
-	return strconv.ParseFloat(buf.String(), 64)
+	val, err := strconv.ParseFloat(buf.String(), 64)
+	// Decimal integers too large for an integer are floats.
+	_, ierr := strconv.ParseInt(buf.String(), 10, 64)
+	return val, ierr != nil, err
 }
 
+// scanString scans the literal as written and decodes it with
+// luautil.Unquote, so that the lexer and Unquote agree on its escape
+// sequences. Newlines are read as '\n'. An error of Unquote is reported
+// at its position in the source.
 func (sc *Scanner) scanString(quote int, buf *bytes.Buffer) error {
+	start := sc.Pos
+	var lit bytes.Buffer
+	writeChar(&lit, quote)
 	ch := sc.Next()
 	for ch != quote {
 		if ch == '\n' || ch == '\r' || ch < 0 {
-			return sc.Error(buf.String(), "unterminated string")
+			return sc.Error(lit.String(), "unterminated string")
 		}
+		writeChar(&lit, ch)
 		if ch == '\\' {
-			ch = sc.Next()
-			if ch == 'z' {
-				ch = sc.skipWhiteSpace(whitespace2)
+			if ch = sc.Next(); ch < 0 {
 				continue
 			}
-			if err := sc.scanEscape(ch, buf); err != nil {
-				return err
+			writeChar(&lit, ch)
+			if ch == 'z' {
+				for ch = sc.Next(); ch >= 0 && whitespace2&(1<<uint(ch)) != 0; ch = sc.Next() {
+					writeChar(&lit, ch)
+				}
+				continue
 			}
-		} else {
-			writeChar(buf, ch)
 		}
 		ch = sc.Next()
 	}
-	return nil
-}
-
-func (sc *Scanner) scanEscape(ch int, buf *bytes.Buffer) error {
-	switch ch {
-	case 'a':
-		buf.WriteByte('\a')
-	case 'b':
-		buf.WriteByte('\b')
-	case 'f':
-		buf.WriteByte('\f')
-	case 'n':
-		buf.WriteByte('\n')
-	case 'r':
-		buf.WriteByte('\r')
-	case 't':
-		buf.WriteByte('\t')
-	case 'v':
-		buf.WriteByte('\v')
-	case 'x':
-		var bytes []byte
-		for i := 0; i < 2; i++ {
-			ch = sc.Next()
-			if !isDigit(ch) {
-				return sc.Error(buf.String(), "hex digit expected")
-			}
-			bytes = append(bytes, byte(ch))
-		}
-		val, _ := strconv.ParseInt(string(bytes), 16, 32)
-		buf.WriteRune(rune(val))
-	case 'u':
-		var index int
-		var bytes []byte
-
-		if sc.Next() != '{' {
-			return sc.Error(buf.String(), "{ expected")
-		}
-
-		for {
-			ch = sc.Next()
-			if ch == '}' && index > 0 {
-				break
-			}
-			if !isDigit(ch) {
-				return sc.Error(buf.String(), "hex digit expected")
-			}
-			if index > 4 {
-				return sc.Error(buf.String(), "UTF-8 value too large")
-			}
-			bytes = append(bytes, byte(ch))
-			index++
-		}
-		val, _ := strconv.ParseInt(string(bytes), 16, 32)
-		buf.WriteRune(rune(val))
-	case '\\':
-		buf.WriteByte('\\')
-	case '"':
-		buf.WriteByte('"')
-	case '\'':
-		buf.WriteByte('\'')
-	case '\n':
-		buf.WriteByte('\n')
-	case '\r':
-		buf.WriteByte('\n')
-		sc.Newline('\r')
-	default:
-		if '0' <= ch && ch <= '9' {
-			bytes := []byte{byte(ch)}
-			for i := 0; i < 2 && isDecimal(sc.Peek()); i++ {
-				bytes = append(bytes, byte(sc.Next()))
-			}
-			val, _ := strconv.ParseInt(string(bytes), 10, 32)
-			writeChar(buf, int(val))
+	writeChar(&lit, quote)
+	s, err := luautil.Unquote(lit.String())
+	if err != nil {
+		e := err.(*luautil.UnquoteError)
+		pos := start
+		if e.Line > 1 {
+			pos.Line += e.Line - 1
+			pos.Column = e.Column
 		} else {
-			buf.WriteByte('\\')
-			writeChar(buf, ch)
-			return sc.Error(buf.String(), "Invalid escape sequence")
+			pos.Column += e.Column - 1
+		}
+		tok := lit.String()
+		if e.Offset < len(tok) {
+			tok = tok[:e.Offset+1]
 		}
+		return &Error{pos, e.Msg, tok}
 	}
+	buf.WriteString(s)
 	return nil
 }
 
@@ -411,7 +365,7 @@ redo:
 		}
 	case isDecimal(ch):
 		tok.Type = TNumber
-		tok.Num, err = sc.scanNumber(ch, buf)
+		tok.Num, tok.Float, err = sc.scanNumber(ch, buf)
 	default:
 		switch ch {
 		case EOF:
@@ -420,10 +374,14 @@ redo:
 			ch2 := sc.Peek()
 			switch ch2 {
 			case '-':
-				err = sc.skipComments(sc.Next())
+				line := sc.Pos.Line
+				err = sc.skipComments(sc.Next(), buf)
 				if err != nil {
 					goto finally
 				}
+				if line == lexer.tokenLine && buf.Len() > 0 {
+					lexer.Comments[line] = "-" + strings.TrimRight(buf.String(), " \t\v\f")
+				}
 				goto redo
 			case '=':
 				tok.Type = TCompound
@@ -523,7 +481,7 @@ redo:
 			switch {
 			case isDecimal(ch2):
 				tok.Type = TNumber
-				tok.Num, err = sc.scanNumber(ch, buf)
+				tok.Num, tok.Float, err = sc.scanNumber(ch, buf)
 			case ch2 == '.':
 				writeChar(buf, ch)
 				writeChar(buf, sc.Next())
@@ -589,6 +547,7 @@ redo:
 
 finally:
 	tok.Name = TokenName(int(tok.Type))
+	lexer.tokenLine = sc.Pos.Line
 	return tok, err
 }
 
@@ -600,6 +559,12 @@ type Lexer struct {
 	PNewLine      bool
 	Token         ast.Token
 	PrevTokenType int
+
+	// Comments holds the trailing comments, the line comments following a
+	// token on the same line, by line.
+	Comments map[int]string
+
+	tokenLine int // line on which the last token ended
 }
 
 func (lx *Lexer) Lex(lval *yySymType) int {
@@ -625,7 +590,7 @@ func (lx *Lexer) TokenError(tok ast.Token, message string) {
 }
 
 func Parse(reader io.Reader, name string) (chunk ast.Chunk, err error) {
-	lexer := &Lexer{NewScanner(reader, name), nil, false, ast.Token{Str: ""}, TNil}
+	lexer := &Lexer{scanner: NewScanner(reader, name), Token: ast.Token{Str: ""}, PrevTokenType: TNil, Comments: map[int]string{}}
 	chunk = nil
 	defer func() {
 		if e := recover(); e != nil {
@@ -634,6 +599,7 @@ func Parse(reader io.Reader, name string) (chunk ast.Chunk, err error) {
 	}()
 	yyParse(lexer)
 	chunk = lexer.Chunk
+	ast.AttachComments(chunk, lexer.Comments)
 	return
 }
 
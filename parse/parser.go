@@ -925,11 +925,13 @@ yynewstate:
 		{
 			yyVAL.stmt = &ast.AssignStmt{Lhs: yyS[yypt-2].exprlist, Rhs: yyS[yypt-0].exprlist}
 			yyVAL.stmt.SetLine(yyS[yypt-2].exprlist[0].Line())
+			yyVAL.stmt.SetColumn(yyS[yypt-2].exprlist[0].Column())
 		}
 	case 9:
 		{
 			yyVAL.stmt = &ast.CompoundAssignStmt{Operator: yyS[yypt-1].token.Str, Lhs: yyS[yypt-2].exprlist, Rhs: yyS[yypt-0].exprlist}
 			yyVAL.stmt.SetLine(yyS[yypt-2].exprlist[0].Line())
+			yyVAL.stmt.SetColumn(yyS[yypt-2].exprlist[0].Column())
 		}
 	case 10:
 		{
@@ -938,24 +940,28 @@ yynewstate:
 			} else {
 				yyVAL.stmt = &ast.FuncCallStmt{Expr: yyS[yypt-0].expr}
 				yyVAL.stmt.SetLine(yyS[yypt-0].expr.Line())
+				yyVAL.stmt.SetColumn(yyS[yypt-0].expr.Column())
 			}
 		}
 	case 11:
 		{
 			yyVAL.stmt = &ast.DoBlockStmt{Chunk: yyS[yypt-1].stmts}
 			yyVAL.stmt.SetLine(yyS[yypt-2].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-2].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 12:
 		{
 			yyVAL.stmt = &ast.WhileStmt{Condition: yyS[yypt-3].expr, Chunk: yyS[yypt-1].stmts}
 			yyVAL.stmt.SetLine(yyS[yypt-4].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-4].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 13:
 		{
 			yyVAL.stmt = &ast.RepeatStmt{Condition: yyS[yypt-0].expr, Chunk: yyS[yypt-2].stmts}
 			yyVAL.stmt.SetLine(yyS[yypt-3].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-3].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].expr.Line())
 		}
 	case 14:
@@ -967,6 +973,7 @@ yynewstate:
 				cur = elseif
 			}
 			yyVAL.stmt.SetLine(yyS[yypt-5].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-5].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 15:
@@ -979,62 +986,73 @@ yynewstate:
 			}
 			cur.(*ast.IfStmt).Else = yyS[yypt-1].stmts
 			yyVAL.stmt.SetLine(yyS[yypt-7].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-7].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 16:
 		{
 			yyVAL.stmt = &ast.NumberForStmt{Name: yyS[yypt-7].token.Str, Init: yyS[yypt-5].expr, Limit: yyS[yypt-3].expr, Chunk: yyS[yypt-1].stmts}
 			yyVAL.stmt.SetLine(yyS[yypt-8].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-8].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 17:
 		{
 			yyVAL.stmt = &ast.NumberForStmt{Name: yyS[yypt-9].token.Str, Init: yyS[yypt-7].expr, Limit: yyS[yypt-5].expr, Step: yyS[yypt-3].expr, Chunk: yyS[yypt-1].stmts}
 			yyVAL.stmt.SetLine(yyS[yypt-10].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-10].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 18:
 		{
 			yyVAL.stmt = &ast.GenericForStmt{Names: yyS[yypt-5].namelist, Exprs: yyS[yypt-3].exprlist, Chunk: yyS[yypt-1].stmts}
 			yyVAL.stmt.SetLine(yyS[yypt-6].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-6].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 19:
 		{
 			yyVAL.stmt = &ast.FunctionStmt{Name: yyS[yypt-1].funcname, Func: yyS[yypt-0].funcexpr}
 			yyVAL.stmt.SetLine(yyS[yypt-2].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-2].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].funcexpr.LastLine())
 		}
 	case 20:
 		{
 			yyVAL.stmt = &ast.LocalFunctionStmt{Name: yyS[yypt-1].token.Str, Func: yyS[yypt-0].funcexpr}
 			yyVAL.stmt.SetLine(yyS[yypt-3].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-3].token.Pos.Column)
 			yyVAL.stmt.SetLastLine(yyS[yypt-0].funcexpr.LastLine())
 		}
 	case 21:
 		{
 			yyVAL.stmt = &ast.LocalAssignStmt{Names: yyS[yypt-2].namelist, Exprs: yyS[yypt-0].exprlist}
 			yyVAL.stmt.SetLine(yyS[yypt-3].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-3].token.Pos.Column)
 		}
 	case 22:
 		{
 			yyVAL.stmt = &ast.LocalAssignStmt{Names: yyS[yypt-0].namelist, Exprs: []ast.Expr{}}
 			yyVAL.stmt.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 23:
 		{
 			yyVAL.stmt = &ast.LabelStmt{Name: yyS[yypt-1].token.Str}
 			yyVAL.stmt.SetLine(yyS[yypt-2].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-2].token.Pos.Column)
 		}
 	case 24:
 		{
 			yyVAL.stmt = &ast.GotoStmt{Label: yyS[yypt-0].token.Str}
 			yyVAL.stmt.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 25:
 		{
 			yyVAL.stmt = &ast.BreakStmt{}
 			yyVAL.stmt.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 26:
 		{
@@ -1044,21 +1062,25 @@ yynewstate:
 		{
 			yyVAL.stmts = append(yyS[yypt-4].stmts, &ast.IfStmt{Condition: yyS[yypt-2].expr, Then: yyS[yypt-0].stmts})
 			yyVAL.stmts[len(yyVAL.stmts)-1].SetLine(yyS[yypt-3].token.Pos.Line)
+			yyVAL.stmts[len(yyVAL.stmts)-1].SetColumn(yyS[yypt-3].token.Pos.Column)
 		}
 	case 28:
 		{
 			yyVAL.stmt = &ast.ReturnStmt{Exprs: nil}
 			yyVAL.stmt.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 29:
 		{
 			yyVAL.stmt = &ast.ReturnStmt{Exprs: yyS[yypt-0].exprlist}
 			yyVAL.stmt.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 30:
 		{
 			yyVAL.stmt = &ast.ContinueStmt{}
 			yyVAL.stmt.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.stmt.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 31:
 		{
@@ -1072,13 +1094,16 @@ yynewstate:
 		{
 			yyVAL.funcname = &ast.FuncName{Func: &ast.IdentExpr{Value: yyS[yypt-0].token.Str}}
 			yyVAL.funcname.Func.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.funcname.Func.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 34:
 		{
 			key := &ast.StringExpr{Value: yyS[yypt-0].token.Str}
 			key.SetLine(yyS[yypt-0].token.Pos.Line)
+			key.SetColumn(yyS[yypt-0].token.Pos.Column)
 			fn := &ast.AttrGetExpr{Object: yyS[yypt-2].funcname.Func, Key: key}
 			fn.SetLine(yyS[yypt-0].token.Pos.Line)
+			fn.SetColumn(yyS[yypt-0].token.Pos.Column)
 			yyVAL.funcname = &ast.FuncName{Func: fn}
 		}
 	case 35:
@@ -1093,18 +1118,22 @@ yynewstate:
 		{
 			yyVAL.expr = &ast.IdentExpr{Value: yyS[yypt-0].token.Str}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 38:
 		{
 			yyVAL.expr = &ast.AttrGetExpr{Object: yyS[yypt-3].expr, Key: yyS[yypt-1].expr}
 			yyVAL.expr.SetLine(yyS[yypt-3].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-3].expr.Column())
 		}
 	case 39:
 		{
 			key := &ast.StringExpr{Value: yyS[yypt-0].token.Str}
 			key.SetLine(yyS[yypt-0].token.Pos.Line)
+			key.SetColumn(yyS[yypt-0].token.Pos.Column)
 			yyVAL.expr = &ast.AttrGetExpr{Object: yyS[yypt-2].expr, Key: key}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 40:
 		{
@@ -1126,26 +1155,31 @@ yynewstate:
 		{
 			yyVAL.expr = &ast.NilExpr{}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 45:
 		{
 			yyVAL.expr = &ast.FalseExpr{}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 46:
 		{
 			yyVAL.expr = &ast.TrueExpr{}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 47:
 		{
-			yyVAL.expr = &ast.NumberExpr{Value: yyS[yypt-0].token.Num}
+			yyVAL.expr = &ast.NumberExpr{Value: yyS[yypt-0].token.Num, Float: yyS[yypt-0].token.Float}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 48:
 		{
 			yyVAL.expr = &ast.Comma3Expr{}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 49:
 		{
@@ -1167,131 +1201,157 @@ yynewstate:
 		{
 			yyVAL.expr = &ast.LogicalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "or", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 54:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "|", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 55:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "~", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 56:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "&", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 57:
 		{
 			yyVAL.expr = &ast.LogicalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "and", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 58:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: ">", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 59:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "<", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 60:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: ">=", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 61:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "<=", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 62:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "==", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 63:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "~=", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 64:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: ">>", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 65:
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyS[yypt-2].expr, Operator: "<<", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 66:
 		{
 			yyVAL.expr = &ast.StringConcatOpExpr{Lhs: yyS[yypt-2].expr, Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 67:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "+", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 68:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "-", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 69:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "*", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 70:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "/", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 71:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "//", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 72:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "%", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 73:
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyS[yypt-2].expr, Operator: "^", Rhs: yyS[yypt-0].expr}
 			yyVAL.expr.SetLine(yyS[yypt-2].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-2].expr.Column())
 		}
 	case 74:
 		{
 			yyVAL.expr = &ast.UnaryOpExpr{Expr: yyS[yypt-0].expr, Operator: "-"}
-			yyVAL.expr.SetLine(yyS[yypt-0].expr.Line())
+			yyVAL.expr.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 75:
 		{
 			yyVAL.expr = &ast.UnaryOpExpr{Expr: yyS[yypt-0].expr, Operator: "not "}
-			yyVAL.expr.SetLine(yyS[yypt-0].expr.Line())
+			yyVAL.expr.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 76:
 		{
 			yyVAL.expr = &ast.UnaryOpExpr{Expr: yyS[yypt-0].expr, Operator: "#"}
-			yyVAL.expr.SetLine(yyS[yypt-0].expr.Line())
+			yyVAL.expr.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 77:
 		{
 			yyVAL.expr = &ast.UnaryOpExpr{Expr: yyS[yypt-0].expr, Operator: "~"}
-			yyVAL.expr.SetLine(yyS[yypt-0].expr.Line())
+			yyVAL.expr.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 78:
 		{
 			yyVAL.expr = &ast.StringExpr{Value: yyS[yypt-0].token.Str}
 			yyVAL.expr.SetLine(yyS[yypt-0].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-0].token.Pos.Column)
 		}
 	case 79:
 		{
@@ -1309,6 +1369,7 @@ yynewstate:
 		{
 			yyVAL.expr = yyS[yypt-1].expr
 			yyVAL.expr.SetLine(yyS[yypt-2].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-2].token.Pos.Column)
 		}
 	case 83:
 		{
@@ -1319,11 +1380,13 @@ yynewstate:
 		{
 			yyVAL.expr = &ast.FuncCallExpr{Func: yyS[yypt-1].expr, Args: yyS[yypt-0].exprlist}
 			yyVAL.expr.SetLine(yyS[yypt-1].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-1].expr.Column())
 		}
 	case 85:
 		{
 			yyVAL.expr = &ast.FuncCallExpr{Method: yyS[yypt-1].token.Str, Receiver: yyS[yypt-3].expr, Args: yyS[yypt-0].exprlist}
 			yyVAL.expr.SetLine(yyS[yypt-3].expr.Line())
+			yyVAL.expr.SetColumn(yyS[yypt-3].expr.Column())
 		}
 	case 86:
 		{
@@ -1351,18 +1414,21 @@ yynewstate:
 		{
 			yyVAL.expr = &ast.FunctionExpr{ParList: yyS[yypt-0].funcexpr.ParList, Chunk: yyS[yypt-0].funcexpr.Chunk}
 			yyVAL.expr.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-1].token.Pos.Column)
 			yyVAL.expr.SetLastLine(yyS[yypt-0].funcexpr.LastLine())
 		}
 	case 91:
 		{
 			yyVAL.funcexpr = &ast.FunctionExpr{ParList: yyS[yypt-3].parlist, Chunk: yyS[yypt-1].stmts}
 			yyVAL.funcexpr.SetLine(yyS[yypt-4].token.Pos.Line)
+			yyVAL.funcexpr.SetColumn(yyS[yypt-4].token.Pos.Column)
 			yyVAL.funcexpr.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 92:
 		{
 			yyVAL.funcexpr = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: false, Names: []string{}}, Chunk: yyS[yypt-1].stmts}
 			yyVAL.funcexpr.SetLine(yyS[yypt-3].token.Pos.Line)
+			yyVAL.funcexpr.SetColumn(yyS[yypt-3].token.Pos.Column)
 			yyVAL.funcexpr.SetLastLine(yyS[yypt-0].token.Pos.Line)
 		}
 	case 93:
@@ -1383,11 +1449,13 @@ yynewstate:
 		{
 			yyVAL.expr = &ast.TableExpr{Fields: []*ast.Field{}}
 			yyVAL.expr.SetLine(yyS[yypt-1].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-1].token.Pos.Column)
 		}
 	case 97:
 		{
 			yyVAL.expr = &ast.TableExpr{Fields: yyS[yypt-1].fieldlist}
 			yyVAL.expr.SetLine(yyS[yypt-2].token.Pos.Line)
+			yyVAL.expr.SetColumn(yyS[yypt-2].token.Pos.Column)
 		}
 	case 98:
 		{
@@ -1405,6 +1473,7 @@ yynewstate:
 		{
 			yyVAL.field = &ast.Field{Key: &ast.StringExpr{Value: yyS[yypt-2].token.Str}, Value: yyS[yypt-0].expr}
 			yyVAL.field.Key.SetLine(yyS[yypt-2].token.Pos.Line)
+			yyVAL.field.Key.SetColumn(yyS[yypt-2].token.Pos.Column)
 		}
 	case 102:
 		{
@@ -113,10 +113,12 @@ stat:
         varlist '=' exprlist {
             $$ = &ast.AssignStmt{Lhs: $1, Rhs: $3}
             $$.SetLine($1[0].Line())
+            $$.SetColumn($1[0].Column())
         } |
         varlist TCompound exprlist {
             $$ = &ast.CompoundAssignStmt{Operator: $2.Str, Lhs: $1, Rhs: $3}
             $$.SetLine($1[0].Line())
+            $$.SetColumn($1[0].Column())
         } |
         /* 'stat = functioncal' causes a reduce/reduce conflict */
         prefixexp {
@@ -125,21 +127,25 @@ stat:
             } else {
               $$ = &ast.FuncCallStmt{Expr: $1}
               $$.SetLine($1.Line())
+              $$.SetColumn($1.Column())
             }
         } |
         TDo block TEnd {
             $$ = &ast.DoBlockStmt{Chunk: $2}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($3.Pos.Line)
         } |
         TWhile expr TDo block TEnd {
             $$ = &ast.WhileStmt{Condition: $2, Chunk: $4}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($5.Pos.Line)
         } |
         TRepeat block TUntil expr {
             $$ = &ast.RepeatStmt{Condition: $4, Chunk: $2}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($4.Line())
         } |
         TIf expr TThen block elseifs TEnd {
@@ -150,6 +156,7 @@ stat:
                 cur = elseif
             }
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($6.Pos.Line)
         } |
         TIf expr TThen block elseifs TElse block TEnd {
@@ -161,52 +168,63 @@ stat:
             }
             cur.(*ast.IfStmt).Else = $7
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($8.Pos.Line)
         } |
         TFor TIdent '=' expr ',' expr TDo block TEnd {
             $$ = &ast.NumberForStmt{Name: $2.Str, Init: $4, Limit: $6, Chunk: $8}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($9.Pos.Line)
         } |
         TFor TIdent '=' expr ',' expr ',' expr TDo block TEnd {
             $$ = &ast.NumberForStmt{Name: $2.Str, Init: $4, Limit: $6, Step:$8, Chunk: $10}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($11.Pos.Line)
         } |
         TFor namelist TIn exprlist TDo block TEnd {
             $$ = &ast.GenericForStmt{Names:$2, Exprs:$4, Chunk: $6}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($7.Pos.Line)
         } |
         TFunction funcname funcbody {
             $$ = &ast.FunctionStmt{Name: $2, Func: $3}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($3.LastLine())
         } |
         TLocal TFunction TIdent funcbody {
             $$ = &ast.LocalFunctionStmt{Name: $3.Str, Func: $4}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($4.LastLine())
         } |
         TLocal namelist '=' exprlist {
             $$ = &ast.LocalAssignStmt{Names: $2, Exprs:$4}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TLocal namelist {
             $$ = &ast.LocalAssignStmt{Names: $2, Exprs:[]ast.Expr{}}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         T2Colon TIdent T2Colon {
             $$ = &ast.LabelStmt{Name: $2.Str}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TGoto TIdent {
             $$ = &ast.GotoStmt{Label: $2.Str}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TBreak  {
             $$ = &ast.BreakStmt{}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         }
 
 elseifs:
@@ -216,20 +234,24 @@ elseifs:
         elseifs TElseIf expr TThen block {
             $$ = append($1, &ast.IfStmt{Condition: $3, Then: $5})
             $$[len($$)-1].SetLine($2.Pos.Line)
+            $$[len($$)-1].SetColumn($2.Pos.Column)
         }
 
 laststat:
         TReturn {
             $$ = &ast.ReturnStmt{Exprs:nil}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TReturn exprlist {
             $$ = &ast.ReturnStmt{Exprs:$2}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TContinue  {
             $$ = &ast.ContinueStmt{}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         }
 funcname:
         funcname1 {
@@ -243,12 +265,15 @@ funcname1:
         TIdent {
             $$ = &ast.FuncName{Func: &ast.IdentExpr{Value:$1.Str}}
             $$.Func.SetLine($1.Pos.Line)
+            $$.Func.SetColumn($1.Pos.Column)
         } |
         funcname1 '.' TIdent {
             key:= &ast.StringExpr{Value:$3.Str}
             key.SetLine($3.Pos.Line)
+            key.SetColumn($3.Pos.Column)
             fn := &ast.AttrGetExpr{Object: $1.Func, Key: key}
             fn.SetLine($3.Pos.Line)
+            fn.SetColumn($3.Pos.Column)
             $$ = &ast.FuncName{Func: fn}
         }
 
@@ -264,16 +289,20 @@ var:
         TIdent {
             $$ = &ast.IdentExpr{Value:$1.Str}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         prefixexp '[' expr ']' {
             $$ = &ast.AttrGetExpr{Object: $1, Key: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         prefixexp '.' TIdent {
             key := &ast.StringExpr{Value:$3.Str}
             key.SetLine($3.Pos.Line)
+            key.SetColumn($3.Pos.Column)
             $$ = &ast.AttrGetExpr{Object: $1, Key: key}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         }
 
 namelist:
@@ -296,22 +325,27 @@ expr:
         TNil {
             $$ = &ast.NilExpr{}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TFalse {
             $$ = &ast.FalseExpr{}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TTrue {
             $$ = &ast.TrueExpr{}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TNumber {
-            $$ = &ast.NumberExpr{Value: $1.Num}
+            $$ = &ast.NumberExpr{Value: $1.Num, Float: $1.Float}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         T3Comma {
             $$ = &ast.Comma3Expr{}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         function {
             $$ = $1
@@ -328,108 +362,134 @@ expr:
         expr TOr expr {
             $$ = &ast.LogicalOpExpr{Lhs: $1, Operator: "or", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '|' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "|", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '~' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "~", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '&' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "&", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TAnd expr {
             $$ = &ast.LogicalOpExpr{Lhs: $1, Operator: "and", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '>' expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: ">", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '<' expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "<", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TGte expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: ">=", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TLte expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "<=", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TEqeq expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "==", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TNeq expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "~=", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TRshift expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: ">>", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TLshift expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "<<", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr T2Comma expr {
             $$ = &ast.StringConcatOpExpr{Lhs: $1, Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '+' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "+", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '-' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "-", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '*' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "*", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '/' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "/", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr TFloorDiv expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "//", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '%' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "%", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         expr '^' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "^", Rhs: $3}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         '-' expr %prec UNARY {
             $$ = &ast.UnaryOpExpr{Expr: $2, Operator: "-"}
-            $$.SetLine($2.Line())
+            $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         TNot expr %prec UNARY {
             $$ = &ast.UnaryOpExpr{Expr: $2, Operator: "not "}
-            $$.SetLine($2.Line())
+            $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         '#' expr %prec UNARY {
             $$ = &ast.UnaryOpExpr{Expr: $2, Operator: "#"}
-            $$.SetLine($2.Line())
+            $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         '~' expr %prec UNARY {
             $$ = &ast.UnaryOpExpr{Expr: $2, Operator: "~"}
-            $$.SetLine($2.Line())
+            $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         }
 
 string:
         TString {
             $$ = &ast.StringExpr{Value: $1.Str}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         }
 
 prefixexp:
@@ -445,6 +505,7 @@ prefixexp:
         '(' expr ')' {
             $$ = $2
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         }
 
 afunctioncall:
@@ -457,10 +518,12 @@ functioncall:
         prefixexp args {
             $$ = &ast.FuncCallExpr{Func: $1, Args: $2}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         } |
         prefixexp ':' TIdent args {
             $$ = &ast.FuncCallExpr{Method: $3.Str, Receiver: $1, Args: $4}
             $$.SetLine($1.Line())
+            $$.SetColumn($1.Column())
         }
 
 args:
@@ -487,6 +550,7 @@ function:
         TFunction funcbody {
             $$ = &ast.FunctionExpr{ParList:$2.ParList, Chunk: $2.Chunk}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($2.LastLine())
         }
 
@@ -494,11 +558,13 @@ funcbody:
         '(' parlist ')' block TEnd {
             $$ = &ast.FunctionExpr{ParList: $2, Chunk: $4}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($5.Pos.Line)
         } |
         '(' ')' block TEnd {
             $$ = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: false, Names: []string{}}, Chunk: $3}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
             $$.SetLastLine($4.Pos.Line)
         }
 
@@ -520,10 +586,12 @@ tableconstructor:
         '{' '}' {
             $$ = &ast.TableExpr{Fields: []*ast.Field{}}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         } |
         '{' fieldlist '}' {
             $$ = &ast.TableExpr{Fields: $2}
             $$.SetLine($1.Pos.Line)
+            $$.SetColumn($1.Pos.Column)
         }
 
 
@@ -542,6 +610,7 @@ field:
         TIdent '=' expr {
             $$ = &ast.Field{Key: &ast.StringExpr{Value:$1.Str}, Value: $3}
             $$.Key.SetLine($1.Pos.Line)
+            $$.Key.SetColumn($1.Pos.Column)
         } |
         '[' expr ']' '=' expr {
             $$ = &ast.Field{Key: $2, Value: $5}
@@ -6,14 +6,46 @@ import (
 	"unicode/utf8"
 )
 
+// QuoteOptions control the literal produced by QuoteWith. The zero value
+// gives the double-quoted literal produced by Quote.
+type QuoteOptions struct {
+	// Smart uses single quotes if s contains more double quotes than single
+	// quotes, so that fewer of them need a backslash.
+	Smart bool
+
+	// Long uses a long bracket literal such as [[...]] or [==[...]==] for
+	// text spanning several lines, as long as every character in it can be
+	// written verbatim. Lua drops \r in long brackets, so text containing one
+	// is still quoted.
+	Long bool
+
+	// Hex escapes bytes that are not part of a printable character as \xXX
+	// instead of \ddd, which is easier to read for binary data.
+	Hex bool
+}
+
 // Quote returns a double-quoted lua string literal representing s. The
-// returned string uses lua escape sequences (\t, \n, \123, \u0100) for
+// returned string uses lua escape sequences (\t, \n, \123, \u{100}) for
 // control characters and non-printable characters as defined by
-// IsPrint.
+// IsPrint. Bytes that are not valid UTF-8 are escaped one by one, so
+// the literal always represents s byte for byte.
 func Quote(s string) string {
+	return QuoteWith(s, QuoteOptions{})
+}
+
+// QuoteWith is like Quote but lets opts choose the kind of literal.
+func QuoteWith(s string, opts QuoteOptions) string {
 	b := &strings.Builder{}
 	b.Grow(3*len(s)/2)
-	quoteWith(b, s, '"')
+	if opts.Long && canQuoteLong(s) {
+		quoteLong(b, s)
+		return b.String()
+	}
+	quote := byte('"')
+	if opts.Smart && strings.Count(s, `"`) > strings.Count(s, `'`) {
+		quote = '\''
+	}
+	quoteWith(b, s, quote, opts.Hex)
 	return b.String()
 }
 
@@ -26,7 +58,9 @@ func convert(char int) string {
 	return strconv.Itoa(char)
 }
 
-func quoteWith(b *strings.Builder, s string, quote byte) {
+const hexDigits = "0123456789abcdef"
+
+func quoteWith(b *strings.Builder, s string, quote byte, hex bool) {
 	b.WriteByte(quote)
 	for width := 0; len(s) > 0; s = s[width:] {
 		r := rune(s[0])
@@ -35,16 +69,26 @@ func quoteWith(b *strings.Builder, s string, quote byte) {
 			r, width = utf8.DecodeRuneInString(s)
 		}
 		if width == 1 && r == utf8.RuneError {
-			b.WriteRune('\\')
-			b.WriteString(convert(int(s[0])))
+			appendEscapedByte(b, s[0], hex)
 			continue
 		}
-		appendEscapedRune(b, r, quote)
+		appendEscapedRune(b, r, quote, hex)
 	}
 	b.WriteByte(quote)
 }
 
-func appendEscapedRune(b *strings.Builder, r rune, quote byte) {
+func appendEscapedByte(b *strings.Builder, c byte, hex bool) {
+	if hex {
+		b.WriteString(`\x`)
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&0xF])
+		return
+	}
+	b.WriteRune('\\')
+	b.WriteString(convert(int(c)))
+}
+
+func appendEscapedRune(b *strings.Builder, r rune, quote byte, hex bool) {
 	var runeTmp [utf8.UTFMax]byte
 	if r == rune(quote) || r == '\\' { // always backslashed
 		b.WriteRune('\\')
@@ -72,7 +116,41 @@ func appendEscapedRune(b *strings.Builder, r rune, quote byte) {
 	case '\v':
 		b.WriteString(`\v`)
 	default:
-		b.WriteRune('\\')
-		b.WriteString(convert(int(r)))
+		if r < utf8.RuneSelf {
+			appendEscapedByte(b, byte(r), hex)
+			return
+		}
+		b.WriteString(`\u{`)
+		b.WriteString(strconv.FormatInt(int64(r), 16))
+		b.WriteByte('}')
+	}
+}
+
+// canQuoteLong reports whether s spans several lines and can be written
+// verbatim between long brackets.
+func canQuoteLong(s string) bool {
+	if !strings.Contains(s, "\n") || !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r != '\n' && r != '\t' && !strconv.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteLong writes s between long brackets with the lowest level whose
+// closing bracket does not occur in s.
+func quoteLong(b *strings.Builder, s string) {
+	eq := ""
+	for strings.Contains(s+"]", "]"+eq+"]") {
+		eq += "="
+	}
+	b.WriteString("[" + eq + "[")
+	if s[0] == '\n' { // lua skips a newline directly after the opening bracket
+		b.WriteByte('\n')
 	}
-}
\ No newline at end of file
+	b.WriteString(s)
+	b.WriteString("]" + eq + "]")
+}
@@ -63,4 +63,50 @@ func TestExtended(t *testing.T) {
 	if expected != result {
 		t.Errorf("Expected %s, got %s", expected, result)
 	}
-}
\ No newline at end of file
+}
+func TestQuoteWith(t *testing.T) {
+	tests := []struct {
+		s        string
+		opts     QuoteOptions
+		expected string
+	}{
+		{`say "hi"`, QuoteOptions{}, `"say \"hi\""`},
+		{`say "hi"`, QuoteOptions{Smart: true}, `'say "hi"'`},
+		{`it's "ok"`, QuoteOptions{Smart: true}, `'it\'s "ok"'`},
+		{`it's`, QuoteOptions{Smart: true}, `"it's"`},
+		{"a\nb", QuoteOptions{}, `"a\nb"`},
+		{"a\nb", QuoteOptions{Long: true}, "[[a\nb]]"},
+		{"\na\n", QuoteOptions{Long: true}, "[[\n\na\n]]"},
+		{"a]]\nb]", QuoteOptions{Long: true}, "[=[a]]\nb]]=]"},
+		{"a]=]\nb]", QuoteOptions{Long: true}, "[==[a]=]\nb]]==]"},
+		{"single line", QuoteOptions{Long: true}, `"single line"`},
+		{"a\r\nb", QuoteOptions{Long: true}, `"a\r\nb"`},
+		{"a\n\x00", QuoteOptions{Long: true}, `"a\n\000"`},
+		{"\x00\x1f\x7f\xff", QuoteOptions{Hex: true}, `"\x00\x1f\x7f\xff"`},
+		{"\xff\xfe", QuoteOptions{}, `"\255\254"`},
+		{"\u2028\u00ad", QuoteOptions{}, `"\u{2028}\u{ad}"`},
+	}
+
+	for _, test := range tests {
+		result := QuoteWith(test.s, test.opts)
+		if result != test.expected {
+			t.Errorf("%q %+v: Expected %s, got %s", test.s, test.opts, test.expected, result)
+		}
+		if s, err := Unquote(result); err != nil || s != test.s {
+			t.Errorf("%s: Expected to unquote to %q, got %q, %v", result, test.s, s, err)
+		}
+	}
+}
+
+func TestQuoteBinary(t *testing.T) {
+	var test []byte
+	for i := 0; i < 256; i++ {
+		test = append(test, byte(i), 0xe2, 0x80, byte(i))
+	}
+	for _, opts := range []QuoteOptions{{}, {Hex: true}, {Smart: true, Long: true}} {
+		result := QuoteWith(string(test), opts)
+		if s, err := Unquote(result); err != nil || s != string(test) {
+			t.Errorf("%+v: Expected %q, got %q, %v", opts, test, s, err)
+		}
+	}
+}
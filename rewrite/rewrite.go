@@ -0,0 +1,255 @@
+// Package rewrite applies rewrite rules such as
+//
+//	table.insert($t, #$t + 1, $v) -> table.insert($t, $v)
+//
+// to lua code. The pattern on the left of the arrow is matched with the
+// match package and every match is replaced by the replacement on the
+// right, with the metavariables bound by the pattern substituted into it.
+package rewrite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/match"
+)
+
+// Rule is a parsed rewrite rule.
+type Rule struct {
+	Pattern     *match.Pattern
+	Replacement *match.Pattern // nil if the matched statements are deleted
+}
+
+// Parse parses a rule of the form "pattern -> replacement". The replacement
+// of an expression pattern must be an expression and may only use the
+// metavariables of the pattern. The replacement of a statement pattern or
+// of a call may be empty, which deletes the matched statements. An arrow
+// in a string or a comment does not separate them.
+func Parse(rule string) (*Rule, error) {
+	parts := match.Split(rule, "->")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("rewrite: rule must be of the form 'pattern -> replacement'")
+	}
+	pattern, err := match.Compile(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	r := &Rule{Pattern: pattern}
+	if strings.TrimSpace(parts[1]) == "" {
+		if pattern.IsExpr() && !pattern.IsCall() {
+			return nil, fmt.Errorf("rewrite: replacement of an expression cannot be empty")
+		}
+		return r, nil
+	}
+	if r.Replacement, err = match.Compile(parts[1]); err != nil {
+		return nil, err
+	}
+	if pattern.IsExpr() && !r.Replacement.IsExpr() {
+		return nil, fmt.Errorf("rewrite: replacement of an expression must be an expression")
+	}
+
+	if r.Replacement.HasWildcard() {
+		return nil, fmt.Errorf("rewrite: $_ binds nothing and cannot be used in a replacement")
+	}
+	bound := make(map[string]bool)
+	for _, name := range pattern.Names() {
+		bound[name] = true
+	}
+	for _, name := range r.Replacement.Names() {
+		if !bound[name] {
+			return nil, fmt.Errorf("rewrite: %s is not bound by the pattern", name)
+		}
+	}
+	return r, nil
+}
+
+// Apply parses rule and applies it to chunk.
+func Apply(chunk ast.Chunk, rule string) (ast.Chunk, error) {
+	r, err := Parse(rule)
+	if err != nil {
+		return nil, err
+	}
+	return r.Apply(chunk), nil
+}
+
+// Apply replaces every match of the rule in chunk, including the ones in
+// nested blocks and function bodies, and returns the new chunk. The nodes
+// of chunk are modified in place. Matches are replaced bottom-up, so the
+// code bound to a metavariable has already been rewritten, and a
+// replacement is not matched again.
+//
+// A replacement that would not be valid where the match is, like an
+// expression other than a call in place of a call statement, is skipped.
+func (r *Rule) Apply(chunk ast.Chunk) ast.Chunk {
+	return r.chunk(chunk)
+}
+
+func (r *Rule) chunk(c ast.Chunk) ast.Chunk {
+	if c == nil {
+		return nil
+	}
+	for _, st := range c {
+		r.stmt(st)
+	}
+	if r.Replacement == nil && r.Pattern.IsExpr() {
+		return r.deleteCalls(c)
+	}
+	if r.Pattern.IsExpr() {
+		return c
+	}
+
+	out := make(ast.Chunk, 0, len(c))
+	for i := 0; i < len(c); {
+		n, bindings, ok := r.Pattern.MatchStmts(c[i:])
+		if !ok || n == 0 {
+			out = append(out, c[i])
+			i++
+			continue
+		}
+		if r.Replacement != nil {
+			stmts, err := r.Replacement.ExpandStmts(bindings, c[i].Line())
+			if err != nil {
+				out = append(out, c[i:i+n]...)
+				i += n
+				continue
+			}
+			out = append(out, stmts...)
+		}
+		i += n
+	}
+	return out
+}
+
+// deleteCalls removes the call statements matching the pattern from c.
+func (r *Rule) deleteCalls(c ast.Chunk) ast.Chunk {
+	out := c[:0]
+	for _, st := range c {
+		if call, ok := st.(*ast.FuncCallStmt); ok {
+			if _, ok := r.Pattern.MatchExpr(call.Expr); ok {
+				continue
+			}
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// expr returns ex or its replacement. valid reports whether the
+// replacement may be used in place of ex.
+func (r *Rule) expr(ex ast.Expr, valid func(ast.Expr) bool) ast.Expr {
+	if ex == nil {
+		return nil
+	}
+	r.children(ex)
+	if !r.Pattern.IsExpr() {
+		return ex
+	}
+	bindings, ok := r.Pattern.MatchExpr(ex)
+	if !ok || r.Replacement == nil {
+		return ex
+	}
+	repl, err := r.Replacement.ExpandExpr(bindings, ex.Line())
+	if err != nil || valid != nil && !valid(repl) {
+		return ex
+	}
+	return repl
+}
+
+func (r *Rule) exprs(exprs []ast.Expr, valid func(ast.Expr) bool) {
+	for i, ex := range exprs {
+		exprs[i] = r.expr(ex, valid)
+	}
+}
+
+// isVar reports whether ex can be assigned to.
+func isVar(ex ast.Expr) bool {
+	switch ex.(type) {
+	case *ast.IdentExpr, *ast.AttrGetExpr:
+		return true
+	}
+	return false
+}
+
+func isCall(ex ast.Expr) bool {
+	_, ok := ex.(*ast.FuncCallExpr)
+	return ok
+}
+
+// children rewrites the expressions and blocks in ex.
+func (r *Rule) children(ex ast.Expr) {
+	switch e := ex.(type) {
+	case *ast.AttrGetExpr:
+		e.Object = r.expr(e.Object, nil)
+		e.Key = r.expr(e.Key, nil)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			field.Key = r.expr(field.Key, nil)
+			field.Value = r.expr(field.Value, nil)
+		}
+	case *ast.FuncCallExpr:
+		e.Func = r.expr(e.Func, nil)
+		e.Receiver = r.expr(e.Receiver, nil)
+		r.exprs(e.Args, nil)
+	case *ast.LogicalOpExpr:
+		e.Lhs = r.expr(e.Lhs, nil)
+		e.Rhs = r.expr(e.Rhs, nil)
+	case *ast.RelationalOpExpr:
+		e.Lhs = r.expr(e.Lhs, nil)
+		e.Rhs = r.expr(e.Rhs, nil)
+	case *ast.StringConcatOpExpr:
+		e.Lhs = r.expr(e.Lhs, nil)
+		e.Rhs = r.expr(e.Rhs, nil)
+	case *ast.ArithmeticOpExpr:
+		e.Lhs = r.expr(e.Lhs, nil)
+		e.Rhs = r.expr(e.Rhs, nil)
+	case *ast.UnaryOpExpr:
+		e.Expr = r.expr(e.Expr, nil)
+	case *ast.FunctionExpr:
+		e.Chunk = r.chunk(e.Chunk)
+	}
+}
+
+// stmt rewrites the expressions and blocks in st.
+func (r *Rule) stmt(st ast.Stmt) {
+	switch s := st.(type) {
+	case *ast.AssignStmt:
+		r.exprs(s.Lhs, isVar)
+		r.exprs(s.Rhs, nil)
+	case *ast.CompoundAssignStmt:
+		r.exprs(s.Lhs, isVar)
+		r.exprs(s.Rhs, nil)
+	case *ast.LocalAssignStmt:
+		r.exprs(s.Exprs, nil)
+	case *ast.FuncCallStmt:
+		s.Expr = r.expr(s.Expr, isCall)
+	case *ast.DoBlockStmt:
+		s.Chunk = r.chunk(s.Chunk)
+	case *ast.WhileStmt:
+		s.Condition = r.expr(s.Condition, nil)
+		s.Chunk = r.chunk(s.Chunk)
+	case *ast.RepeatStmt:
+		s.Chunk = r.chunk(s.Chunk)
+		s.Condition = r.expr(s.Condition, nil)
+	case *ast.IfStmt:
+		s.Condition = r.expr(s.Condition, nil)
+		s.Then = r.chunk(s.Then)
+		s.Else = r.chunk(s.Else)
+	case *ast.NumberForStmt:
+		s.Init = r.expr(s.Init, nil)
+		s.Limit = r.expr(s.Limit, nil)
+		s.Step = r.expr(s.Step, nil)
+		s.Chunk = r.chunk(s.Chunk)
+	case *ast.GenericForStmt:
+		r.exprs(s.Exprs, nil)
+		s.Chunk = r.chunk(s.Chunk)
+	case *ast.LocalFunctionStmt:
+		s.Func.Chunk = r.chunk(s.Func.Chunk)
+	case *ast.FunctionStmt:
+		s.Name.Func = r.expr(s.Name.Func, isVar)
+		s.Name.Receiver = r.expr(s.Name.Receiver, isVar)
+		s.Func.Chunk = r.chunk(s.Func.Chunk)
+	case *ast.ReturnStmt:
+		r.exprs(s.Exprs, nil)
+	}
+}
@@ -0,0 +1,118 @@
+package rewrite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+func apply(t *testing.T, src, rule string) string {
+	t.Helper()
+	chunk, err := parse.Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err = Apply(chunk, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunk.String()
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		src, rule, expected string
+	}{
+		{
+			"table.insert(list, #list + 1, x)\nlocal f = function() table.insert(a.b, #a.b + 1, {}) end",
+			"table.insert($t, #$t + 1, $v) -> table.insert($t, $v)",
+			"table.insert(list, x);\nlocal f = function()\n\ttable.insert(a.b, {});\nend;\n",
+		},
+		{
+			// Nested matches are rewritten from the inside out.
+			"x = f(f(1))",
+			"f($x) -> g($x)",
+			"x = g(g(1));\n",
+		},
+		{
+			"x = (a + b) * c",
+			"$x * $y -> $y * $x",
+			"x = c * (a + b);\n",
+		},
+		{
+			"print(t.old, t:old())",
+			"$t.old -> $t.new",
+			"print(t.new, t:old());\n",
+		},
+		{
+			// A call statement cannot be replaced by other expressions.
+			"f(1)\nx = f(2)",
+			"f($x) -> $x",
+			"f(1);\nx = 2;\n",
+		},
+		{
+			"if ok then\n\tdebug.traceback()\n\tprint(1)\nend",
+			"debug.traceback() ->",
+			"if ok then\n\tprint(1);\nend;\n",
+		},
+		{
+			"local fh = io.open(name)\nlocal data = fh:read()\nfh:close()\nreturn data",
+			"local $f = io.open($n) $$body $f:close() -> local $f = assert(io.open($n)) $$body $f:close()",
+			"local fh = assert(io.open(name));\nlocal data = fh:read();\nfh:close();\nreturn data;\n",
+		},
+		{
+			"for i = 1, #t do print(t[i]) end",
+			"for $i = 1, #$t do $$body end -> for $i, _ in ipairs($t) do $$body end",
+			"for i, _ in ipairs(t) do\n\tprint(t[i]);\nend;\n",
+		},
+		{
+			// The arrow of a rule is not the one in a string.
+			"x = a .. b",
+			"$a .. $b -> $a .. \"->\" .. $b",
+			"x = a .. \"->\" .. b;\n",
+		},
+	}
+
+	for _, test := range tests {
+		if result := apply(t, test.src, test.rule); result != test.expected {
+			t.Errorf("%s\nExpected:\n%sGot:\n%s", test.rule, test.expected, result)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, rule := range []string{
+		"f($x)",
+		"f($x) -> g($x) -> h($x)",
+		"$x + 1 ->",
+		"f($x) -> g($y)",
+		"f($_) -> g($_)",
+		"f($$_) -> g($$_)",
+		"f($x) -> local y = $x",
+		"local $x = 1 -> $$x",
+		"f( -> g()",
+		"f($x) -- -> g($x)",
+	} {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("%q: Expected an error", rule)
+		}
+	}
+}
+
+func TestApplyCopies(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("y = t.a + 1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err = Apply(chunk, "$x + 1 -> $x * $x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each use of $x gets its own copy of t.a.
+	ex := chunk[0].(*ast.AssignStmt).Rhs[0].(*ast.ArithmeticOpExpr)
+	if ex.Lhs == ex.Rhs {
+		t.Errorf("%s: the operands share their nodes", chunk)
+	}
+}
@@ -6,8 +6,47 @@ import (
 	"github.com/hootrhino/beautiful-lua-go/ast"
 )
 
-type builder struct{}
+// BuilderMode is a bitmask of options for the builder.
+type BuilderMode uint
+
+const (
+	// LowerLogic lowers and and or into branches and φ-nodes, so that
+	// the right operand is evaluated only when lua evaluates it. The
+	// conditions of if, while and repeat become branches as well.
+	LowerLogic BuilderMode = 1 << iota
+
+	// LowerLoops lowers numeric and generic for loops into branches,
+	// arithmetic and calls of the iterator, instead of the NumberFor
+	// and GenericFor instructions.
+	LowerLoops
+
+	// SanityCheckFunctions checks the invariants of each function once
+	// built, and panics if the builder broke one. It is meant for
+	// debugging the builder and the passes it runs.
+	SanityCheckFunctions
+)
+
+type builder struct {
+	mode BuilderMode
+}
 
+// An Error is an error in a program that its syntax does not catch, like
+// a goto without a visible label.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ssa: line %d: %s", e.Line, e.Msg)
+}
+
+// errorf stops the building with an *Error.
+func (b *builder) errorf(line int, format string, args ...interface{}) {
+	panic(&Error{Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// expr emits to fn the instructions computing expr and returns its value.
 func (b *builder) expr(fn *Function, expr ast.Expr) Value {
 	switch ex := expr.(type) {
 	case *ast.NilExpr:
@@ -17,61 +56,67 @@ func (b *builder) expr(fn *Function, expr ast.Expr) Value {
 	case *ast.TrueExpr:
 		return True{}
 	case *ast.NumberExpr:
-		return Number{ex.Value}
+		return Number{ex.Value, ex.Float}
 	case *ast.StringExpr:
 		return String{ex.Value}
 	case *ast.IdentExpr:
-		return fn.lookup(ex.Value)
+		return b.variable(fn, ex.Value).load(fn)
 	case *ast.Comma3Expr:
 		return VarArg{}
 	case *ast.AttrGetExpr:
-		return AttrGet{
+		return fn.emit(&Index{
 			Object: b.expr(fn, ex.Object),
 			Key:    b.expr(fn, ex.Key),
-		}
+		})
 	case *ast.TableExpr:
-		tbl := Table{}
+		tbl := &NewTable{}
 		for _, fi := range ex.Fields {
-			field := &Field{
-				Value: b.expr(fn, fi.Value),
-			}
+			field := &Field{}
 			if fi.Key != nil {
 				field.Key = b.expr(fn, fi.Key)
 			}
+			field.Value = b.expr(fn, fi.Value)
 			tbl.Fields = append(tbl.Fields, field)
 		}
-		return tbl
+		if n := len(ex.Fields); n > 0 {
+			last := ex.Fields[n-1]
+			tbl.Spread = last.Key == nil && isMultiValue(last.Value)
+		}
+		return fn.emit(tbl)
 	case *ast.ArithmeticOpExpr:
-		return Arithmetic{
+		return fn.emit(&Arithmetic{
 			Op: ex.Operator,
 
 			Lhs: b.expr(fn, ex.Lhs),
 			Rhs: b.expr(fn, ex.Rhs),
-		}
+		})
 	case *ast.StringConcatOpExpr:
-		return Concat{
+		return fn.emit(&Concat{
 			Lhs: b.expr(fn, ex.Lhs),
 			Rhs: b.expr(fn, ex.Rhs),
-		}
+		})
 	case *ast.RelationalOpExpr:
-		return Relation{
+		return fn.emit(&Relation{
 			Op:  ex.Operator,
 			Lhs: b.expr(fn, ex.Lhs),
 			Rhs: b.expr(fn, ex.Rhs),
-		}
+		})
 	case *ast.LogicalOpExpr:
-		return Logic{
+		if b.mode&LowerLogic != 0 {
+			return b.logic(fn, ex)
+		}
+		return fn.emit(&Logic{
 			Op:  ex.Operator,
 			Lhs: b.expr(fn, ex.Lhs),
 			Rhs: b.expr(fn, ex.Rhs),
-		}
+		})
 	case *ast.UnaryOpExpr:
-		return Unary{
+		return fn.emit(&Unary{
 			Op:    ex.Operator,
 			Value: b.expr(fn, ex.Expr),
-		}
+		})
 	case *ast.FuncCallExpr:
-		return b.funcCallExpr(fn, ex)
+		return fn.emit(b.funcCallExpr(fn, ex))
 	case *ast.FunctionExpr:
 		return b.functionExpr(fn, ex)
 	default:
@@ -79,13 +124,148 @@ func (b *builder) expr(fn *Function, expr ast.Expr) Value {
 	}
 }
 
-func (b *builder) funcCallExpr(fn *Function, ex *ast.FuncCallExpr) Call {
-	call := Call{
-		Args: make([]Value, len(ex.Args)),
+// logic emits to fn the branches computing a and b or a or b, and
+// returns the temporary holding the result, which lifting turns into a
+// φ-node.
+func (b *builder) logic(fn *Function, ex *ast.LogicalOpExpr) Value {
+	tmp := fn.newLocal("")
+	lhs := b.expr(fn, ex.Lhs)
+	fn.EmitAssign(tmp, lhs)
+
+	rhs := fn.NewBasicBlock(ex.Operator + ".rhs")
+	done := fn.NewBasicBlock(ex.Operator + ".done")
+	if ex.Operator == "and" {
+		fn.emitIf(lhs, rhs, done)
+	} else {
+		fn.emitIf(lhs, done, rhs)
 	}
+	fn.currentBlock = rhs
+	fn.EmitAssign(tmp, b.expr(fn, ex.Rhs))
+	fn.emitJump(done)
+	fn.currentBlock = done
+	return tmp
+}
 
-	for i, arg := range ex.Args {
-		call.Args[i] = b.expr(fn, arg)
+// cond emits to fn code to evaluate the condition e and jump to t if
+// it is true and to f if it is false. With LowerLogic, and, or and not
+// become branches.
+func (b *builder) cond(fn *Function, e ast.Expr, t, f *BasicBlock) {
+	if b.mode&LowerLogic != 0 {
+		switch e := e.(type) {
+		case *ast.LogicalOpExpr:
+			rhs := fn.NewBasicBlock("cond." + e.Operator)
+			if e.Operator == "and" {
+				b.cond(fn, e.Lhs, rhs, f)
+			} else {
+				b.cond(fn, e.Lhs, t, rhs)
+			}
+			fn.currentBlock = rhs
+			b.cond(fn, e.Rhs, t, f)
+			return
+		case *ast.UnaryOpExpr:
+			if e.Operator == "not " {
+				b.cond(fn, e.Expr, f, t)
+				return
+			}
+		}
+	}
+	fn.emitIf(b.expr(fn, e), t, f)
+}
+
+// isMultiValue reports whether ex yields all its values when it ends a
+// list of expressions: a call that is not in parentheses, or ... .
+func isMultiValue(ex ast.Expr) bool {
+	switch ex := ex.(type) {
+	case *ast.FuncCallExpr:
+		return !ex.AdjustRet
+	case *ast.Comma3Expr:
+		return true
+	}
+	return false
+}
+
+// exprs emits to fn the expressions of list adjusted to n values, as
+// lua does in assignments: a trailing call or ... is spread over the
+// missing values, the values still missing are nil and the expressions
+// left over are evaluated but dropped.
+func (b *builder) exprs(fn *Function, list []ast.Expr, n int) []Value {
+	values := make([]Value, 0, n)
+	for i, ex := range list {
+		v := b.expr(fn, ex)
+		switch {
+		case len(values) >= n:
+			// dropped
+		case i == len(list)-1 && isMultiValue(ex) && n-len(values) > 1:
+			for j := 0; len(values) < n; j++ {
+				values = append(values, fn.emit(&Extract{Tuple: v, Index: j}))
+			}
+		default:
+			values = append(values, v)
+		}
+	}
+	for len(values) < n {
+		values = append(values, Nil{})
+	}
+	return values
+}
+
+// places emits to fn the targets of a multiple assignment. A variable
+// used as the object or key of a field is copied, so that the field
+// is the one lua evaluated before any of the assignments.
+func (b *builder) places(fn *Function, list []ast.Expr) []lvalue {
+	places := make([]lvalue, len(list))
+	for i, ex := range list {
+		places[i] = b.place(fn, ex)
+		if e, ok := places[i].(*element); ok && len(list) > 1 {
+			e.object = b.snapshot(fn, e.object)
+			e.key = b.snapshot(fn, e.key)
+		}
+	}
+	return places
+}
+
+// values is like exprs for the values of a multiple assignment. The
+// variables among them are copied, so that a, b = b, a swaps a and b.
+func (b *builder) values(fn *Function, list []ast.Expr, n int) []Value {
+	values := b.exprs(fn, list, n)
+	if n > 1 {
+		for i, v := range values {
+			values[i] = b.snapshot(fn, v)
+		}
+	}
+	return values
+}
+
+// place returns the target of an assignment to expr. The object and key
+// of a field are evaluated, but the field itself is not loaded.
+func (b *builder) place(fn *Function, expr ast.Expr) lvalue {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		return b.variable(fn, ex.Value)
+	case *ast.AttrGetExpr:
+		return &element{
+			object: b.expr(fn, ex.Object),
+			key:    b.expr(fn, ex.Key),
+		}
+	}
+	return &variable{b.expr(fn, expr)}
+}
+
+// variable returns the lvalue name refers to: a local in scope, or else
+// the global name, which is the field of _ENV.
+func (b *builder) variable(fn *Function, name string) lvalue {
+	if name == "_ENV" {
+		return &variable{fn.env()}
+	}
+	if v := fn.lookup(name); v != nil {
+		return &variable{v}
+	}
+	return &element{object: fn.env(), key: String{name}}
+}
+
+func (b *builder) funcCallExpr(fn *Function, ex *ast.FuncCallExpr) *Call {
+	call := &Call{
+		Args: make([]Value, len(ex.Args)),
 	}
 
 	if ex.Func != nil { // hoge.func()
@@ -94,6 +274,11 @@ func (b *builder) funcCallExpr(fn *Function, ex *ast.FuncCallExpr) Call {
 		call.Recv = b.expr(fn, ex.Receiver)
 		call.Method = ex.Method
 	}
+
+	for i, arg := range ex.Args {
+		call.Args[i] = b.expr(fn, arg)
+	}
+	call.Spread = len(ex.Args) > 0 && isMultiValue(ex.Args[len(ex.Args)-1])
 	return call
 }
 
@@ -112,34 +297,78 @@ func (b *builder) buildFunction(fn *Function) {
 	}
 	fn.VarArg = f.ParList.HasVargs
 	b.chunk(fn, f.Chunk)
+	fn.emitReturn(nil, false)
 	fn.finishBody()
+	if b.mode&SanityCheckFunctions != 0 {
+		mustSanityCheck(fn)
+	}
 }
 
-func Build(chunk ast.Chunk) *Function {
-	var b builder
-	fn := &Function{
+// Build builds the SSA code of a chunk, which becomes the main function.
+func Build(chunk ast.Chunk) (*Function, error) {
+	return BuildWith(chunk, 0)
+}
+
+// BuildWith is like Build with the options in mode.
+func BuildWith(chunk ast.Chunk, mode BuilderMode) (fn *Function, err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil:
+		case *Error:
+			fn, err = nil, e
+		default:
+			panic(e)
+		}
+	}()
+
+	b := builder{mode: mode}
+	fn = &Function{
 		syntax: &ast.FunctionExpr{
 			Chunk:   chunk,
 			ParList: &ast.ParList{HasVargs: true},
 		},
 		Name: "main",
 	}
+	fn.newScope()
 	b.buildFunction(fn)
-	return fn
+	return fn, nil
+}
+
+// loop emits to fn the body of a loop whose break and continue
+// statements jump to done and cont.
+func (b *builder) loop(fn *Function, list ast.Chunk, done, cont *BasicBlock) {
+	oldBreak, oldContinue := fn.breakBlock, fn.continueBlock
+	fn.breakBlock, fn.continueBlock = done, cont
+	b.chunk(fn, list)
+	fn.breakBlock, fn.continueBlock = oldBreak, oldContinue
 }
 
-// repeat stmtemits to fn code for the repeat statement s
+// repeatStmt emits to fn code for the repeat statement s.
 func (b *builder) repeatStmt(fn *Function, s *ast.RepeatStmt) {
-	loop := fn.NewBasicBlock("repeat.loop") // target of 'continue'
 	body := fn.NewBasicBlock("repeat.body")
+	loop := fn.NewBasicBlock("repeat.loop") // target of 'continue'
 	done := fn.NewBasicBlock("repeat.done") // target of 'break'
 
 	fn.emitJump(body)
-	fn.emitReturn(b.expr(fn, s.Condition), body, done)
-
 	fn.currentBlock = body
-	b.chunk(fn, s.Chunk)
+
+	// The condition sees the locals of the body.
+	old := fn.newScope()
+	b.declareLabels(fn, s.Chunk)
+	for _, l := range fn.currentScope.labels {
+		l.atEnd = false // the condition follows them
+	}
+	oldBreak, oldContinue := fn.breakBlock, fn.continueBlock
+	fn.breakBlock, fn.continueBlock = done, loop
+	for _, st := range s.Chunk {
+		b.stmt(fn, st)
+	}
+	fn.breakBlock, fn.continueBlock = oldBreak, oldContinue
 	fn.emitJump(loop)
+	fn.currentBlock = loop
+	b.cond(fn, s.Condition, done, body)
+	fn.currentScope = old
+
 	fn.currentBlock = done
 }
 
@@ -150,35 +379,49 @@ func (b *builder) whileStmt(fn *Function, s *ast.WhileStmt) {
 
 	fn.emitJump(loop)
 	fn.currentBlock = loop
-	fn.emitIf(b.expr(fn, s.Condition), body, done)
+	b.cond(fn, s.Condition, body, done)
 
 	fn.currentBlock = body
-	b.chunk(fn, s.Chunk)
+	b.loop(fn, s.Chunk, done, loop)
 	fn.emitJump(loop)
 	fn.currentBlock = done
 }
 
 func (b *builder) numberForStmt(fn *Function, s *ast.NumberForStmt) {
+	if b.mode&LowerLoops != 0 {
+		b.lowerNumberFor(fn, s)
+		return
+	}
 	loop := fn.NewBasicBlock("for.loop") // target of 'continue'
 	body := fn.NewBasicBlock("for.body")
 	done := fn.NewBasicBlock("for.done") // target of 'break'
 
-	local := fn.addLocal(s.Name)
-
-	limit := b.expr(fn, s.Limit)
 	init := b.expr(fn, s.Init)
-	step := b.expr(fn, s.Step)
+	limit := b.expr(fn, s.Limit)
+	var step Value = Number{Value: 1}
+	if s.Step != nil {
+		step = b.expr(fn, s.Step)
+	}
+
+	old := fn.newScope()
+	local := fn.addLocal(s.Name)
 
 	fn.emitJump(loop)
+	fn.currentBlock = loop
 	fn.emitNumberFor(local, init, limit, step, body, done)
 
 	fn.currentBlock = body
-	b.chunk(fn, s.Chunk)
+	b.loop(fn, s.Chunk, done, loop)
 	fn.emitJump(loop)
+	fn.currentScope = old
 	fn.currentBlock = done
 }
 
 func (b *builder) genericForStmt(fn *Function, s *ast.GenericForStmt) {
+	if b.mode&LowerLoops != 0 {
+		b.lowerGenericFor(fn, s)
+		return
+	}
 	loop := fn.NewBasicBlock("for.loop") // target of 'continue'
 	body := fn.NewBasicBlock("for.body")
 	done := fn.NewBasicBlock("for.done") // target of 'break'
@@ -186,18 +429,131 @@ func (b *builder) genericForStmt(fn *Function, s *ast.GenericForStmt) {
 	locals := make([]Value, len(s.Names))
 	values := make([]Value, len(s.Exprs))
 
-	for i, name := range s.Names {
-		locals[i] = fn.lookup(name)
-	}
-
 	for i, expr := range s.Exprs {
 		values[i] = b.expr(fn, expr)
 	}
 
+	old := fn.newScope()
+	for i, name := range s.Names {
+		locals[i] = fn.addLocal(name)
+	}
+
 	fn.emitJump(loop)
+	fn.currentBlock = loop
 	fn.emitGenericFor(locals, values, body, done)
 	fn.currentBlock = body
-	b.chunk(fn, s.Chunk)
+	b.loop(fn, s.Chunk, done, loop)
+	fn.emitJump(loop)
+	fn.currentScope = old
+	fn.currentBlock = done
+}
+
+// snapshot returns v, or a copy of it if it is a variable that can change
+// while its value is still needed.
+func (b *builder) snapshot(fn *Function, v Value) Value {
+	switch v.(type) {
+	case *Local, *Global:
+		tmp := fn.newLocal("")
+		fn.EmitAssign(tmp, v)
+		return tmp
+	}
+	return v
+}
+
+// lowerNumberFor emits to fn the numeric for loop s as
+//
+//	local var, limit, step = init, limit, step
+//	while (step > 0 and var <= limit) or (step <= 0 and var >= limit) do
+//		local name = var
+//		...
+//		var = var + step
+//	end
+//
+// The test of the sign of the step is left out if it is a constant.
+func (b *builder) lowerNumberFor(fn *Function, s *ast.NumberForStmt) {
+	counter := fn.newLocal("")
+	fn.EmitAssign(counter, b.expr(fn, s.Init))
+	limit := b.snapshot(fn, b.expr(fn, s.Limit))
+	var step Value = Number{Value: 1}
+	if s.Step != nil {
+		step = b.snapshot(fn, b.expr(fn, s.Step))
+	}
+
+	loop := fn.NewBasicBlock("for.loop")
+	body := fn.NewBasicBlock("for.body")
+	post := fn.NewBasicBlock("for.post") // target of 'continue'
+	done := fn.NewBasicBlock("for.done") // target of 'break'
+
+	fn.emitJump(loop)
+	fn.currentBlock = loop
+	up := func() { fn.emitIf(fn.emit(&Relation{Op: "<=", Lhs: counter, Rhs: limit}), body, done) }
+	down := func() { fn.emitIf(fn.emit(&Relation{Op: ">=", Lhs: counter, Rhs: limit}), body, done) }
+	if n, ok := step.(Number); ok {
+		if n.Value > 0 {
+			up()
+		} else {
+			down()
+		}
+	} else {
+		pos := fn.NewBasicBlock("for.up")
+		neg := fn.NewBasicBlock("for.down")
+		fn.emitIf(fn.emit(&Relation{Op: ">", Lhs: step, Rhs: Number{Value: 0}}), pos, neg)
+		fn.currentBlock = pos
+		up()
+		fn.currentBlock = neg
+		down()
+	}
+
+	fn.currentBlock = body
+	old := fn.newScope()
+	fn.EmitAssign(fn.addLocal(s.Name), counter)
+	b.loop(fn, s.Chunk, done, post)
+	fn.currentScope = old
+	fn.emitJump(post)
+
+	fn.currentBlock = post
+	fn.EmitAssign(counter, fn.emit(&Arithmetic{Op: "+", Lhs: counter, Rhs: step}))
+	fn.emitJump(loop)
+	fn.currentBlock = done
+}
+
+// lowerGenericFor emits to fn the generic for loop s as
+//
+//	local f, s, var = explist
+//	while true do
+//		local name1, ..., namen = f(s, var)
+//		if name1 == nil then break end
+//		var = name1
+//		...
+//	end
+func (b *builder) lowerGenericFor(fn *Function, s *ast.GenericForStmt) {
+	values := b.exprs(fn, s.Exprs, 3)
+	iter := b.snapshot(fn, values[0])
+	state := b.snapshot(fn, values[1])
+	control := fn.newLocal("")
+	fn.EmitAssign(control, values[2])
+
+	loop := fn.NewBasicBlock("for.loop") // target of 'continue'
+	body := fn.NewBasicBlock("for.body")
+	done := fn.NewBasicBlock("for.done") // target of 'break'
+
+	fn.emitJump(loop)
+	fn.currentBlock = loop
+	call := fn.emit(&Call{Func: iter, Args: []Value{state, control}})
+	vars := make([]Value, len(s.Names))
+	for i := range s.Names {
+		vars[i] = fn.emit(&Extract{Tuple: call, Index: i})
+	}
+	fn.EmitAssign(control, vars[0])
+	fn.emitIf(fn.emit(&Relation{Op: "==", Lhs: vars[0], Rhs: Nil{}}), done, body)
+
+	fn.currentBlock = body
+	old := fn.newScope()
+	for i, name := range s.Names {
+		fn.EmitAssign(fn.addLocal(name), vars[i])
+	}
+	b.loop(fn, s.Chunk, done, loop)
+	fn.currentScope = old
 	fn.emitJump(loop)
 	fn.currentBlock = done
 }
@@ -205,66 +561,94 @@ func (b *builder) genericForStmt(fn *Function, s *ast.GenericForStmt) {
 // chunk emits to fn code for all statements in list.
 func (b *builder) chunk(fn *Function, list ast.Chunk) {
 	old := fn.newScope()
+	b.declareLabels(fn, list)
 	for _, s := range list {
 		b.stmt(fn, s)
 	}
 	fn.currentScope = old
 }
 
+// declareLabels adds the labels of the block list to the current scope,
+// so that gotos can jump forward to them.
+func (b *builder) declareLabels(fn *Function, list ast.Chunk) {
+	for i, st := range list {
+		s, ok := st.(*ast.LabelStmt)
+		if !ok {
+			continue
+		}
+		if _, ok := fn.currentScope.labels[s.Name]; ok {
+			b.errorf(s.Line(), "label '%s' already defined", s.Name)
+		}
+		if l, _ := fn.currentScope.parent.label(s.Name); l != nil {
+			b.errorf(s.Line(), "label '%s' already visible", s.Name)
+		}
+		l := &lblock{
+			_goto: fn.NewBasicBlock("label." + s.Name),
+			atEnd: true,
+		}
+		for _, next := range list[i+1:] {
+			if _, ok := next.(*ast.LabelStmt); !ok {
+				l.atEnd = false
+				break
+			}
+		}
+		fn.currentScope.labels[s.Name] = l
+	}
+}
+
+func (b *builder) labelStmt(fn *Function, s *ast.LabelStmt) {
+	l := fn.currentScope.labels[s.Name]
+	l.defined = true
+	l.nlocals = len(fn.currentScope.locals)
+	for _, g := range l.pending {
+		// A jump into the scope of a local would skip its
+		// initialization. The end of a block is outside of their scope.
+		if g.nlocals < l.nlocals && !l.atEnd {
+			b.errorf(g.line, "<goto %s> jumps into the scope of local '%s'",
+				s.Name, fn.currentScope.locals[g.nlocals].Comment)
+		}
+	}
+	l.pending = nil
+	fn.emitJump(l._goto)
+	fn.currentBlock = l._goto
+}
+
+func (b *builder) gotoStmt(fn *Function, s *ast.GotoStmt) {
+	l, scope := fn.currentScope.label(s.Label)
+	if l == nil {
+		b.errorf(s.Line(), "no visible label '%s' for goto", s.Label)
+	}
+	if !l.defined {
+		l.pending = append(l.pending, pendingGoto{line: s.Line(), nlocals: len(scope.locals)})
+	}
+	fn.emitJump(l._goto)
+	fn.currentBlock = fn.NewBasicBlock("unreachable")
+}
+
 // stmt lowers statement s to SSA form, emitting code to fn.
 func (b *builder) stmt(fn *Function, st ast.Stmt) {
 
 	switch s := st.(type) {
 	case *ast.AssignStmt:
-		if len(s.Lhs) <= len(s.Rhs) { // a, b = 1, 2 or a, b = 1, 2, 3
-			for i, ex := range s.Lhs {
-				fn.EmitAssign(b.expr(fn, ex), b.expr(fn, s.Rhs[i]))
-			}
-		} else { // a, b = 1
-			i, l, r := 0, len(s.Lhs), len(s.Rhs)
-			for ; i < l; i++ {
-				fn.EmitAssign(b.expr(fn, s.Lhs[i]), b.expr(fn, s.Rhs[i]))
-			}
-			for ; i < r; i++ {
-				fn.EmitAssign(b.expr(fn, s.Lhs[i]), b.expr(fn, &ast.NilExpr{}))
-			}
+		places := b.places(fn, s.Lhs)
+		values := b.values(fn, s.Rhs, len(s.Lhs))
+		for i, place := range places {
+			place.store(fn, values[i])
 		}
 	case *ast.CompoundAssignStmt:
-		if len(s.Lhs) <= len(s.Rhs) { // a, b = 1, 2 or a, b = 1, 2, 3
-			for i, ex := range s.Lhs {
-				fn.emitCompoundAssign(s.Operator, b.expr(fn, ex), b.expr(fn, s.Rhs[i]))
-			}
-		} else { // a, b = 1
-			i, l, r := 0, len(s.Lhs), len(s.Rhs)
-			for ; i < l; i++ {
-				fn.emitCompoundAssign(s.Operator, b.expr(fn, s.Lhs[i]), b.expr(fn, s.Rhs[i]))
-			}
-			for ; i < r; i++ {
-				fn.emitCompoundAssign(s.Operator, b.expr(fn, s.Lhs[i]), b.expr(fn, &ast.NilExpr{}))
-			}
+		places := b.places(fn, s.Lhs)
+		values := b.values(fn, s.Rhs, len(s.Lhs))
+		for i, place := range places {
+			fn.emitCompoundAssign(s.Operator, place, values[i])
 		}
 	case *ast.LocalAssignStmt:
-		switch {
-		case len(s.Names) <= len(s.Exprs): // local a, b = 1, 2
-			for i, name := range s.Names {
-				fn.emitLocalAssign(name, b.expr(fn, s.Exprs[i]))
-			}
-		case len(s.Exprs) == 0: // local a, b
-			for _, name := range s.Names {
-				fn.emitLocalAssign(name, b.expr(fn, &ast.NilExpr{}))
-			}
-		default: // local a, b = 1
-			i, e, n := 0, len(s.Exprs), len(s.Names)
-			for ; i < e; i++ {
-				fn.emitLocalAssign(s.Names[i], b.expr(fn, s.Exprs[i]))
-			}
-			for ; i < n; i++ {
-				fn.emitLocalAssign(s.Names[i], b.expr(fn, &ast.NilExpr{}))
-			}
+		// The names are not in scope in the expressions.
+		values := b.exprs(fn, s.Exprs, len(s.Names))
+		for i, name := range s.Names {
+			fn.emitLocalAssign(name, values[i])
 		}
 	case *ast.FuncCallStmt:
-		call := b.funcCallExpr(fn, s.Expr.(*ast.FuncCallExpr))
-		fn.emit(&call)
+		fn.emit(b.funcCallExpr(fn, s.Expr.(*ast.FuncCallExpr)))
 	case *ast.DoBlockStmt:
 		b.chunk(fn, s.Chunk)
 	case *ast.WhileStmt:
@@ -277,10 +661,10 @@ func (b *builder) stmt(fn *Function, st ast.Stmt) {
 		fn.emitLocalAssign(s.Name, f)
 		b.buildFunction(f)
 	case *ast.FunctionStmt:
-		var lhs Value
+		var lhs lvalue
 		f := fn.addFunction(s.Func)
 		if s.Name.Func != nil {
-			lhs = b.expr(fn, s.Name.Func)
+			lhs = b.place(fn, s.Name.Func)
 			switch e := s.Name.Func.(type) {
 			case *ast.IdentExpr: // function func()
 				f.Name = e.Value
@@ -288,17 +672,18 @@ func (b *builder) stmt(fn *Function, st ast.Stmt) {
 				f.Name = e.Key.(*ast.StringExpr).Value
 			}
 		} else { // function hoge:func(). We need to prepend self to args and convert the recv and method fields to recv.method .
-			lhs = AttrGet{b.expr(fn, s.Name.Receiver), String{s.Name.Method}}
+			lhs = &element{object: b.expr(fn, s.Name.Receiver), key: String{s.Name.Method}}
 			f.Name = s.Name.Method
 			f.addParam("self")
 		}
 		b.buildFunction(f)
-		fn.EmitAssign(lhs, f)
+		lhs.store(fn, f)
 	case *ast.ReturnStmt:
-		// some some trickery to convert the exprs into useable values
-		// something like
-		// exprs(s.Exprs)
-		//fn.emit(&Return{Results: s.Exprs, pos: s.Return})
+		results := make([]Value, len(s.Exprs))
+		for i, ex := range s.Exprs {
+			results[i] = b.expr(fn, ex)
+		}
+		fn.emitReturn(results, len(s.Exprs) > 0 && isMultiValue(s.Exprs[len(s.Exprs)-1]))
 		fn.currentBlock = fn.NewBasicBlock("unreachable")
 	case *ast.IfStmt:
 		then := fn.NewBasicBlock("if.then")
@@ -308,7 +693,7 @@ func (b *builder) stmt(fn *Function, st ast.Stmt) {
 			els = fn.NewBasicBlock("if.else")
 		}
 
-		fn.emitIf(b.expr(fn, s.Condition), then, els) //TODO: convert to jmp stuff like while is
+		b.cond(fn, s.Condition, then, els)
 		fn.currentBlock = then
 		b.chunk(fn, s.Then)
 		fn.emitJump(done)
@@ -320,15 +705,25 @@ func (b *builder) stmt(fn *Function, st ast.Stmt) {
 		}
 		fn.currentBlock = done
 	case *ast.BreakStmt:
+		if fn.breakBlock == nil {
+			b.errorf(s.Line(), "break outside a loop")
+		}
 		fn.emitJump(fn.breakBlock)
 		fn.currentBlock = fn.NewBasicBlock("unreachable")
 	case *ast.ContinueStmt:
+		if fn.continueBlock == nil {
+			b.errorf(s.Line(), "continue outside a loop")
+		}
 		fn.emitJump(fn.continueBlock)
 		fn.currentBlock = fn.NewBasicBlock("unreachable")
 	case *ast.NumberForStmt:
 		b.numberForStmt(fn, s)
 	case *ast.GenericForStmt:
 		b.genericForStmt(fn, s)
+	case *ast.LabelStmt:
+		b.labelStmt(fn, s)
+	case *ast.GotoStmt:
+		b.gotoStmt(fn, s)
 	default:
 		panic(fmt.Sprintf("unexpected statement kind: %T", s))
 	}
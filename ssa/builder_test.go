@@ -1,6 +1,7 @@
 package ssa
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -13,7 +14,24 @@ func build(str string, t *testing.T) *Function {
 	if err != nil {
 		t.Fatal(err)
 	}
-	return Build(chunk)
+	fn, err := BuildWith(chunk, SanityCheckFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+// runPasses builds input, runs passes on it in order, checks the
+// invariants of the result and returns it decompiled.
+func runPasses(input string, t *testing.T, passes ...func(*Function)) string {
+	fn := build(input, t)
+	for _, pass := range passes {
+		pass(fn)
+	}
+	if err := SanityCheck(fn); err != nil {
+		t.Errorf("%q: %v", input, err)
+	}
+	return decompile(fn, t)
 }
 
 func TestClosure(t *testing.T) {
@@ -82,3 +100,344 @@ func TestBuild(t *testing.T) {
 	fn := build(input, t)
 	t.Error(fn.String())
 }
+
+func TestReturn(t *testing.T) {
+	fn := build(`
+	local function f(a, ...)
+		if a then
+			return a, f(a)
+		end
+		if b then
+			return (g())
+		end
+		return ...
+	end
+	`, t)
+	f := fn.Functions[0]
+	if f.Exit == nil || f.Exit != f.Blocks[len(f.Blocks)-1] {
+		t.Fatalf("exit block is not the last block of\n%s", f)
+	}
+	var got []string
+	for _, pred := range f.Exit.Preds {
+		ret, ok := pred.Instrs[len(pred.Instrs)-1].(*Return)
+		if !ok || len(pred.Succs) != 1 {
+			t.Fatalf("exit predecessor %s does not end in a return", pred)
+		}
+		got = append(got, ret.String())
+	}
+	want := []string{"return t1, t2...", "return t5", "return ..."}
+	if len(got) != len(want) {
+		t.Fatalf("got returns %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got %q, want %q", got[i], want[i])
+		}
+	}
+
+	// The main function ends with an implicit return.
+	if ret, ok := fn.Exit.Preds[0].Instrs[len(fn.Exit.Preds[0].Instrs)-1].(*Return); !ok || len(ret.Results) != 0 {
+		t.Errorf("main does not end with an empty return:\n%s", fn)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	fn := build(`
+	local a, b = 1, 2
+	a, b = b, a
+	local x, y, z = f()
+	local p, q = f(), 1
+	local r, s = 1
+	t[a], a = a, 3
+	a, b = 4, 5, g()
+	h(a, b, x, y, z, p, q, r, s)
+	h(f())
+	h(f(), a)
+	`, t)
+	checkSSA(t, fn)
+
+	// value follows the copies of the lifted locals.
+	value := func(v Value) string {
+		for {
+			local, ok := v.(*Local)
+			if !ok || local.Value == nil {
+				break
+			}
+			v = local.Value
+		}
+		switch v := v.(type) {
+		case *Call:
+			return "call"
+		case *Extract:
+			return fmt.Sprintf("#%d", v.Index)
+		}
+		return v.String()
+	}
+	var calls []*Call
+	var store *Store
+	for _, instr := range fn.Blocks[0].Instrs {
+		switch instr := instr.(type) {
+		case *Call:
+			calls = append(calls, instr)
+		case *Store:
+			store = instr
+		}
+	}
+	if len(calls) != 8 {
+		t.Fatalf("got %d calls, want 8:\n%s", len(calls), fn)
+	}
+
+	// The values are evaluated before any of the assignments.
+	if store == nil || value(store.Key) != "2" || value(store.Value) != "2" {
+		t.Errorf("t[a] does not use the value of a before the assignment:\n%s", fn)
+	}
+
+	var got []string
+	for _, arg := range calls[3].Args {
+		got = append(got, value(arg))
+	}
+	want := []string{"4", "5", "#0", "#1", "#2", "call", "1", "1", "nil"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got h(%s), want h(%s):\n%s", strings.Join(got, ", "), strings.Join(want, ", "), fn)
+	}
+
+	// Only a call last in the arguments passes all its results.
+	for i, spread := range []bool{false, true, false} {
+		if call := calls[3+2*i]; call.Spread != spread {
+			t.Errorf("%s: got spread %v, want %v", call, call.Spread, spread)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	fn := build(`
+	local t = {1, x = 2, [3] = f(), g()}
+	t.x = t.y
+	t[1] += 2
+	function t:m() end
+	print({f(), ...}, {f(), 1}, {})
+	`, t)
+	checkSSA(t, fn)
+
+	var tables, loads, stores []string
+	var spread []bool
+	for _, instr := range fn.Blocks[0].Instrs {
+		switch instr := instr.(type) {
+		case *NewTable:
+			tables = append(tables, instr.String())
+			spread = append(spread, instr.Spread)
+		case *Index:
+			if _, ok := instr.Object.(*Global); !ok {
+				loads = append(loads, instr.String())
+			}
+		case *Store:
+			stores = append(stores, instr.String())
+		case *Assign:
+			if _, ok := instr.Lhs.(*Local); !ok {
+				t.Errorf("%s assigns a field", instr)
+			}
+		}
+	}
+	if len(tables) != 4 || !spread[0] || !spread[1] || spread[2] || spread[3] {
+		t.Errorf("got tables %q spread %v:\n%s", tables, spread, fn)
+	}
+	if len(tables) > 0 && tables[0] != `{1, ["x"] = 2, [3] = t2, t4...}` {
+		t.Errorf("got table %s", tables[0])
+	}
+	if len(loads) != 2 || len(stores) != 3 {
+		t.Errorf("got loads %q, stores %q:\n%s", loads, stores, fn)
+	}
+}
+
+func TestGoto(t *testing.T) {
+	fn := build(`
+	local i = 1
+	::top::
+	i = i + 1
+	if i < 10 then
+		goto top
+	end
+	do
+		goto done
+	end
+	print(i)
+	::done::
+	`, t)
+	sanityCheckDomTree(fn)
+	checkSSA(t, fn)
+
+	var top, done *BasicBlock
+	for _, b := range fn.Blocks {
+		switch b.Comment {
+		case "label.top":
+			top = b
+		case "label.done":
+			done = b
+		}
+	}
+	if top == nil || len(top.Preds) != 2 {
+		t.Fatalf("label top is not a join of the entry and the backward goto:\n%s", fn)
+	}
+	if done == nil || len(done.Preds) != 1 {
+		t.Fatalf("label done is not reached by the forward goto alone:\n%s", fn)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if call, ok := instr.(*Call); ok {
+				t.Errorf("unreachable %s was kept", call)
+			}
+		}
+	}
+	if len(phis(fn)) != 1 {
+		t.Errorf("want a phi for i at label top:\n%s", fn)
+	}
+}
+
+func TestGotoErrors(t *testing.T) {
+	for _, test := range []struct{ input, err string }{
+		{"goto l", "ssa: line 1: no visible label 'l' for goto"},
+		{"do ::l:: end goto l", "ssa: line 1: no visible label 'l' for goto"},
+		{"::l:: local function f() goto l end", "ssa: line 1: no visible label 'l' for goto"},
+		{"goto l local a = 1 ::l:: print(a)", "ssa: line 1: <goto l> jumps into the scope of local 'a'"},
+		{"::l:: ::l::", "ssa: line 1: label 'l' already defined"},
+		{"::l:: do ::l:: end", "ssa: line 1: label 'l' already visible"},
+		{"repeat goto l local x = 1 ::l:: until x", "ssa: line 1: <goto l> jumps into the scope of local 'x'"},
+		{"break", "ssa: line 1: break outside a loop"},
+		{"while x do local function f() break end end", "ssa: line 1: break outside a loop"},
+		{"do\n\tcontinue\nend", "ssa: line 2: continue outside a loop"},
+
+		// Allowed jumps.
+		{"goto l local a = 1 ::l::", ""},
+		{"do goto l end ::l::", ""},
+		{"local a ::l:: do goto l end", ""},
+		{"while x do goto next local a = 1 ::next:: end", ""},
+		{"repeat goto l ::l:: until x", ""},
+	} {
+		chunk, err := parse.Parse(strings.NewReader(test.input), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Build(chunk)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.err {
+			t.Errorf("%q: got error %q, want %q", test.input, got, test.err)
+		}
+	}
+}
+
+func TestLowerLogic(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(`
+	local x = f() and g()
+	print(x)
+	if a and not b or c then
+		print(1)
+	end
+	`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, err := BuildWith(chunk, LowerLogic|SanityCheckFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSSA(t, fn)
+	sanityCheckDomTree(fn)
+
+	var ifs int
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *Logic, *Unary:
+				t.Errorf("%s was not lowered", instr)
+			case *If:
+				ifs++
+			case *Call:
+				// g() only runs when f() is true.
+				if instr.Func.String() == "g" && (len(b.Preds) != 1 || b.Preds[0] != fn.Blocks[0]) {
+					t.Errorf("g() is not guarded by f():\n%s", fn)
+				}
+			}
+		}
+	}
+	if ifs != 4 {
+		t.Errorf("got %d branches, want 4:\n%s", ifs, fn)
+	}
+
+	// The result of and is a φ-node merging both operands.
+	ps := phis(fn)
+	if len(ps) != 1 || len(ps[0].Edges) != 2 {
+		t.Fatalf("want one phi merging f() and g():\n%s", fn)
+	}
+	refs := *ps[0].Local.Referrers()
+	if len(refs) != 1 {
+		t.Errorf("phi %s is not used by the assignment of x:\n%s", ps[0], fn)
+	}
+}
+
+func TestLowerLoops(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(`
+	local s = 0
+	for i = 1, n do
+		if i == 3 then
+			continue
+		end
+		s = s + i
+	end
+	for i = 10, 1, step do
+		s = s + i
+	end
+	for k, v in pairs(t) do
+		if v then
+			break
+		end
+		s = s + v
+	end
+	print(s)
+	`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, err := BuildWith(chunk, LowerLoops|SanityCheckFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSSA(t, fn)
+	sanityCheckDomTree(fn)
+
+	blocks := make(map[string]int)
+	for _, b := range fn.Blocks {
+		blocks[b.Comment]++
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *NumberFor, *GenericFor:
+				t.Errorf("%s was not lowered", instr)
+			case *Call:
+				// The iterator, the only call with two arguments, is
+				// called at the head of the loop.
+				if len(instr.Args) == 2 && b.Comment != "for.loop" {
+					t.Errorf("iterator %s called in %s", instr, b.Comment)
+				}
+			}
+		}
+	}
+	// Only the loop with a variable step tests its sign.
+	if blocks["for.up"] != 1 || blocks["for.down"] != 1 {
+		t.Errorf("got %d up and %d down blocks, want 1:\n%s", blocks["for.up"], blocks["for.down"], fn)
+	}
+	if blocks["for.loop"] != 3 || blocks["for.post"] != 2 {
+		t.Errorf("got blocks %v:\n%s", blocks, fn)
+	}
+
+	// Each loop merges s and its hidden control variable, and continue
+	// merges s before the increment of the first loop.
+	var merged []string
+	for _, phi := range phis(fn) {
+		merged = append(merged, phi.Comment)
+	}
+	if len(merged) != 7 {
+		t.Errorf("got phis for %q, want s and the control variable of each loop:\n%s", merged, fn)
+	}
+}
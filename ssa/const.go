@@ -0,0 +1,453 @@
+package ssa
+
+// This file defines the lua semantics of the operators on constants,
+// for constant folding.
+//
+// A Number is an integer or a float, as in lua 5.3: + - * // % give an
+// integer on integers, wrapping around, and a float otherwise, and / and
+// ^ always give a float. The value of a Number is a float64, so an
+// integer beyond 2^53, which it may not hold exactly, is not folded, and
+// neither is a result that no literal reads back as: an infinity or a
+// NaN. Operations raising an error, such as 1//0 or 1 < "2", are not
+// folded either, and neither are those that depend on the lua version:
+// bitwise operators convert strings to numbers in lua 5.3 but not in
+// 5.4.
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A number is a lua integer or float.
+type number struct {
+	float bool
+	i     int64
+	f     float64
+}
+
+func integer(i int64) number { return number{i: i} }
+func float(f float64) number { return number{float: true, f: f} }
+func (n number) toFloat() float64 {
+	if n.float {
+		return n.f
+	}
+	return float64(n.i)
+}
+
+// maxExact is the largest integer such that every integer of smaller
+// magnitude is a float64.
+const maxExact = 1 << 53
+
+// toNumber returns the number of the constant n, or false if it is an
+// integer whose value may not be exact.
+func toNumber(n Number) (number, bool) {
+	if n.Float || n.Value != math.Trunc(n.Value) {
+		return float(n.Value), true
+	}
+	if math.Abs(n.Value) > maxExact {
+		return number{}, false
+	}
+	return integer(int64(n.Value)), true
+}
+
+// fromNumber returns the constant holding n, or false if none does.
+func fromNumber(n number) (Value, bool) {
+	if !n.float {
+		if n.i < -maxExact || n.i > maxExact {
+			return nil, false
+		}
+		return Number{Value: float64(n.i)}, true
+	}
+	if math.IsInf(n.f, 0) || math.IsNaN(n.f) {
+		return nil, false
+	}
+	return Number{Value: n.f, Float: true}, true
+}
+
+var (
+	decimalFloat = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+	hexFloat     = regexp.MustCompile(`^[+-]?0[xX]([0-9a-fA-F]+\.?[0-9a-fA-F]*|\.[0-9a-fA-F]+)([pP][+-]?[0-9]+)?$`)
+)
+
+// stringToNumber converts s to a number like lua_stringtonumber: an
+// integer if s is a decimal integer that fits or a hexadecimal integer,
+// which wraps around, and else a float.
+func stringToNumber(s string) (number, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v")
+	digits, neg := s, false
+	if strings.HasPrefix(digits, "-") {
+		digits, neg = digits[1:], true
+	} else if strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	if hex := strings.TrimPrefix(strings.TrimPrefix(digits, "0x"), "0X"); hex != digits {
+		if u, ok := parseHex(hex); ok {
+			if neg {
+				u = -u
+			}
+			return integer(int64(u)), true
+		}
+	} else if i, err := strconv.ParseInt(digits, 10, 64); err == nil && digits != "" && digits[0] != '+' && digits[0] != '-' {
+		if neg {
+			i = -i
+		}
+		return integer(i), true
+	}
+	switch {
+	case decimalFloat.MatchString(s):
+	case hexFloat.MatchString(s):
+		if !strings.ContainsAny(s, "pP") {
+			s += "p0"
+		}
+	default:
+		return number{}, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !math.IsInf(f, 0) {
+		return number{}, false
+	}
+	return float(f), true
+}
+
+// parseHex parses the hexadecimal integer s modulo 2^64.
+func parseHex(s string) (uint64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	var u uint64
+	for _, c := range s {
+		d, err := strconv.ParseUint(string(c), 16, 8)
+		if err != nil {
+			return 0, false
+		}
+		u = u<<4 | d
+	}
+	return u, true
+}
+
+// arithOperand returns the number of the operand v of an arithmetic
+// operator, converting strings.
+func arithOperand(v Value) (number, bool) {
+	switch v := v.(type) {
+	case Number:
+		return toNumber(v)
+	case String:
+		return stringToNumber(v.Value)
+	}
+	return number{}, false
+}
+
+// toInteger returns the integer of the operand v of a bitwise operator.
+// A float converts to the same integer if it is integral.
+func toInteger(v Value) (int64, bool) {
+	n, ok := v.(Number)
+	if !ok {
+		return 0, false
+	}
+	x, ok := toNumber(n)
+	switch {
+	case !ok:
+		return 0, false
+	case !x.float:
+		return x.i, true
+	case x.f == math.Trunc(x.f) && math.Abs(x.f) <= maxExact:
+		return int64(x.f), true
+	}
+	return 0, false // number has no integer representation
+}
+
+// arith returns the constant result of the binary operator op on the
+// constants x and y, or false if it cannot be folded.
+func arith(op string, x, y Value) (Value, bool) {
+	r, ok := arithNumber(op, x, y)
+	if !ok {
+		return nil, false
+	}
+	return fromNumber(r)
+}
+
+// arithNumber returns the result of the binary operator op on the
+// constants x and y, or false if it raises an error.
+func arithNumber(op string, x, y Value) (number, bool) {
+	switch op {
+	case "&", "|", "~", "<<", ">>":
+		a, ok := toInteger(x)
+		if !ok {
+			return number{}, false
+		}
+		b, ok := toInteger(y)
+		if !ok {
+			return number{}, false
+		}
+		return integer(bitwise(op, a, b)), true
+	}
+	a, ok := arithOperand(x)
+	if !ok {
+		return number{}, false
+	}
+	b, ok := arithOperand(y)
+	if !ok {
+		return number{}, false
+	}
+	var r number
+	if !a.float && !b.float && op != "/" && op != "^" {
+		i, j := a.i, b.i
+		switch op {
+		case "+":
+			r = integer(i + j)
+		case "-":
+			r = integer(i - j)
+		case "*":
+			r = integer(i * j)
+		case "//":
+			if j == 0 {
+				return number{}, false // attempt to perform 'n//0'
+			}
+			q := i / j
+			if i%j != 0 && (i < 0) != (j < 0) {
+				q--
+			}
+			r = integer(q)
+		case "%":
+			if j == 0 {
+				return number{}, false // attempt to perform 'n%%0'
+			}
+			m := i % j
+			if m != 0 && (m < 0) != (j < 0) {
+				m += j
+			}
+			r = integer(m)
+		default:
+			return number{}, false
+		}
+		return r, true
+	}
+	f, g := a.toFloat(), b.toFloat()
+	switch op {
+	case "+":
+		r = float(f + g)
+	case "-":
+		r = float(f - g)
+	case "*":
+		r = float(f * g)
+	case "/":
+		r = float(f / g)
+	case "^":
+		r = float(math.Pow(f, g))
+	case "//":
+		r = float(math.Floor(f / g))
+	case "%":
+		m := math.Mod(f, g)
+		if m != 0 && (m < 0) != (g < 0) {
+			m += g
+		}
+		r = float(m)
+	default:
+		return number{}, false
+	}
+	return r, true
+}
+
+// bitwise returns the result of the bitwise operator op on a and b.
+func bitwise(op string, a, b int64) int64 {
+	switch op {
+	case "&":
+		return a & b
+	case "|":
+		return a | b
+	case "~":
+		return a ^ b
+	case ">>":
+		b = -b
+	}
+	// Shifts are logical, and shifting by 64 bits or more gives 0.
+	switch {
+	case b <= -64 || b >= 64:
+		return 0
+	case b < 0:
+		return int64(uint64(a) >> uint(-b))
+	default:
+		return int64(uint64(a) << uint(b))
+	}
+}
+
+// unary returns the constant result of the unary operator op on the
+// constant x, or false if it cannot be folded.
+func unary(op string, x Value) (Value, bool) {
+	switch op {
+	case "not ":
+		if isConst(x) {
+			return boolean(!truthy(x)), true
+		}
+	case "#":
+		if s, ok := x.(String); ok {
+			return fromNumber(integer(int64(len(s.Value))))
+		}
+	case "-":
+		switch x := x.(type) {
+		case Number:
+			if n, ok := toNumber(x); ok {
+				if n.float {
+					return fromNumber(float(-n.f))
+				}
+				return fromNumber(integer(-n.i))
+			}
+		case String:
+			if n, ok := stringToNumber(x.Value); ok {
+				if n.float {
+					return fromNumber(float(-n.f))
+				}
+				return fromNumber(integer(-n.i))
+			}
+		}
+	case "~":
+		if i, ok := toInteger(x); ok {
+			return fromNumber(integer(^i))
+		}
+	}
+	return nil, false
+}
+
+// concat returns the constant result of x .. y, or false if it cannot
+// be folded.
+func concat(x, y Value) (Value, bool) {
+	a, ok := toString(x)
+	if !ok {
+		return nil, false
+	}
+	b, ok := toString(y)
+	if !ok {
+		return nil, false
+	}
+	return String{a + b}, true
+}
+
+// toString returns the string of the operand v of .., formatting
+// floats like lua with "%.14g", and a ".0" suffix if they would look
+// like integers.
+func toString(v Value) (string, bool) {
+	switch v := v.(type) {
+	case String:
+		return v.Value, true
+	case Number:
+		n, ok := toNumber(v)
+		if !ok {
+			return "", false
+		}
+		if !n.float {
+			return strconv.FormatInt(n.i, 10), true
+		}
+		s := strconv.FormatFloat(n.f, 'g', 14, 64)
+		if strings.Trim(s, "-0123456789") == "" {
+			s += ".0"
+		}
+		return s, true
+	}
+	return "", false
+}
+
+// compare returns the constant result of the relational operator op on
+// the constants x and y, or false if it cannot be folded.
+func compare(op string, x, y Value) (Value, bool) {
+	switch op {
+	case "==":
+		return boolean(equal(x, y)), isConst(x) && isConst(y)
+	case "~=":
+		return boolean(!equal(x, y)), isConst(x) && isConst(y)
+	case "<<", ">>":
+		return arith(op, x, y)
+	}
+	var less, eq bool
+	switch x := x.(type) {
+	case Number:
+		y, ok := y.(Number)
+		if !ok {
+			return nil, false
+		}
+		less, eq = x.Value < y.Value, x.Value == y.Value
+	case String:
+		y, ok := y.(String)
+		if !ok {
+			return nil, false
+		}
+		less, eq = x.Value < y.Value, x.Value == y.Value
+	default:
+		return nil, false // attempt to compare
+	}
+	switch op {
+	case "<":
+		return boolean(less), true
+	case "<=":
+		return boolean(less || eq), true
+	case ">":
+		return boolean(!less && !eq), true
+	case ">=":
+		return boolean(!less), true
+	}
+	return nil, false
+}
+
+// equal reports whether the constants x and y are equal. Constants of
+// different types never are, but an integer and a float are if they
+// have the same value.
+func equal(x, y Value) bool {
+	if x, ok := x.(Number); ok {
+		y, ok := y.(Number)
+		return ok && x.Value == y.Value
+	}
+	return x == y
+}
+
+// safe reports whether the operation instr neither raises an error nor
+// calls a metamethod, whether or not it can be folded: its operands are
+// constants that it accepts.
+func safe(instr Instruction) bool {
+	switch instr := instr.(type) {
+	case *Arithmetic:
+		_, ok := arithNumber(instr.Op, instr.Lhs, instr.Rhs)
+		return ok
+	case *Concat:
+		return isNumberOrString(instr.Lhs) && isNumberOrString(instr.Rhs)
+	case *Unary:
+		if _, ok := instr.Value.(Number); ok && instr.Op == "-" {
+			return true
+		}
+	}
+	_, ok := fold(instr)
+	return ok
+}
+
+func isNumberOrString(v Value) bool {
+	switch v.(type) {
+	case Number, String:
+		return true
+	}
+	return false
+}
+
+// isConst reports whether v is a constant with a single value.
+func isConst(v Value) bool {
+	switch v.(type) {
+	case Nil, True, False, Number, String:
+		return true
+	}
+	return false
+}
+
+// truthy reports whether the constant v is neither nil nor false.
+func truthy(v Value) bool {
+	switch v.(type) {
+	case Nil, False:
+		return false
+	}
+	return true
+}
+
+func boolean(b bool) Value {
+	if b {
+		return True{}
+	}
+	return False{}
+}
@@ -0,0 +1,142 @@
+package ssa
+
+import (
+	"testing"
+)
+
+func TestFoldOperators(t *testing.T) {
+	i := func(v float64) Value { return Number{Value: v} }
+	f := func(v float64) Value { return Number{Value: v, Float: true} }
+	s := func(s string) Value { return String{s} }
+	tests := []struct {
+		op   string
+		x, y Value
+		want Value // nil if not folded
+	}{
+		{"+", i(1), i(2), i(3)},
+		{"+", f(1), i(2), f(3)},
+		{"+", s("1"), s("2"), i(3)},
+		{"+", f(1.5), f(1.5), f(3)},
+		{"+", s("0x4000000000000000"), s("0x4000000000000000"), nil}, // beyond 2^53
+		{"*", s("0x4000000000000000"), s("4"), i(0)},                 // integers wrap around
+		{"+", i(1 << 53), i(1), nil},
+		{"+", f(1.5), i(1), f(2.5)},
+		{"/", i(3), i(2), f(1.5)},
+		{"/", i(4), i(2), f(2)},
+		{"/", i(1), i(0), nil},
+		{"^", i(2), i(-1), f(0.5)},
+		{"^", i(2), i(3), f(8)},
+		{"//", i(7), i(2), i(3)},
+		{"//", i(-7), i(2), i(-4)},
+		{"//", i(7), i(-2), i(-4)},
+		{"//", i(7), i(0), nil},
+		{"//", f(7), i(2), f(3)},
+		{"//", f(7.5), i(2), f(3)},
+		{"//", f(7), i(0), nil},
+		{"%", i(7), i(3), i(1)},
+		{"%", i(-7), i(3), i(2)},
+		{"%", i(7), i(-3), i(-2)},
+		{"%", i(7), i(0), nil},
+		{"%", f(-5.5), i(2), f(0.5)},
+		{"%", f(5.5), i(-2), f(-0.5)},
+		{"+", s("10"), i(1), i(11)},
+		{"+", s("10"), s("1"), i(11)},
+		{"+", s(" 0x10 "), s("0"), i(16)},
+		{"+", s("0xffffffffffffffff"), s("0"), i(-1)},
+		{"*", s("1e1"), f(0.25), f(2.5)},
+		{"+", s("0x.8"), i(0), f(0.5)},
+		{"+", s("1.5"), s("1"), f(2.5)},
+		{"+", s("inf"), i(0), nil},
+		{"+", s("1 2"), i(0), nil},
+		{"+", s(""), i(0), nil},
+		{"+", True{}, i(0), nil},
+		{"&", i(12), i(10), i(8)},
+		{"|", i(12), i(10), i(14)},
+		{"~", i(12), i(10), i(6)},
+		{"&", f(3), i(1), i(1)},
+		{"&", f(1.5), i(1), nil},
+		{"&", s("3"), i(1), nil},
+		{"<<", i(1), i(4), i(16)},
+		{"<<", i(1), i(64), i(0)},
+		{">>", i(-1), i(60), i(15)},
+		{">>", i(16), i(-2), i(64)},
+		{"..", s("a"), i(1), s("a1")},
+		{"..", s("a"), f(1), s("a1.0")},
+		{"..", i(1e15), s(""), s("1000000000000000")},
+		{"..", f(1e15), s(""), s("1e+15")},
+		{"..", f(1.5), s(""), s("1.5")},
+		{"..", f(0.1), s(""), s("0.1")},
+		{"..", f(1.0 / 3), s(""), s("0.33333333333333")},
+		{"..", f(1e100), s(""), s("1e+100")},
+		{"..", f(-2.5e-7), s(""), s("-2.5e-07")},
+		{"..", f(1 << 63), s(""), s("9.2233720368548e+18")},
+		{"..", s("a"), Nil{}, nil},
+		{"==", i(1), i(1), True{}},
+		{"==", i(1), f(1), True{}},
+		{"==", i(1), s("1"), False{}},
+		{"~=", Nil{}, False{}, True{}},
+		{"<", i(1), i(2), True{}},
+		{"<", i(1), f(1.5), True{}},
+		{"<=", s("b"), s("a"), False{}},
+		{">", s("b"), s("a"), True{}},
+		{">=", i(1), f(1), True{}},
+		{"<", i(1), s("2"), nil},
+		{"<", Nil{}, Nil{}, nil},
+		{"and", i(1), s("x"), s("x")},
+		{"and", False{}, s("x"), False{}},
+		{"or", Nil{}, s("x"), s("x")},
+		{"or", i(0), s("x"), i(0)},
+		{"or", Nil{}, VarArg{}, nil},
+	}
+	for _, test := range tests {
+		var instr Instruction
+		switch test.op {
+		case "..":
+			instr = &Concat{Lhs: test.x, Rhs: test.y}
+		case "==", "~=", "<", "<=", ">", ">=", "<<", ">>":
+			instr = &Relation{Op: test.op, Lhs: test.x, Rhs: test.y}
+		case "and", "or":
+			instr = &Logic{Op: test.op, Lhs: test.x, Rhs: test.y}
+		default:
+			instr = &Arithmetic{Op: test.op, Lhs: test.x, Rhs: test.y}
+		}
+		got, ok := fold(instr)
+		if !ok {
+			got = nil
+		}
+		if got != test.want {
+			t.Errorf("%s %s %s = %v, want %v", test.x, test.op, test.y, got, test.want)
+		}
+	}
+}
+
+func TestFoldUnary(t *testing.T) {
+	tests := []struct {
+		op   string
+		x    Value
+		want Value // nil if not folded
+	}{
+		{"-", Number{Value: 5}, Number{Value: -5}},
+		{"-", Number{Value: -1.5, Float: true}, Number{Value: 1.5, Float: true}},
+		{"-", Number{Value: 2, Float: true}, Number{Value: -2, Float: true}},
+		{"-", String{"2"}, Number{Value: -2}},
+		{"-", Nil{}, nil},
+		{"~", Number{Value: 0}, Number{Value: -1}},
+		{"~", Number{Value: 2, Float: true}, Number{Value: -3}},
+		{"~", Number{Value: 0.5, Float: true}, nil},
+		{"not ", Nil{}, True{}},
+		{"not ", Number{Value: 0}, False{}},
+		{"not ", VarArg{}, nil},
+		{"#", String{"abc"}, Number{Value: 3}},
+		{"#", Number{Value: 3}, nil},
+	}
+	for _, test := range tests {
+		got, ok := fold(&Unary{Op: test.op, Value: test.x})
+		if !ok {
+			got = nil
+		}
+		if got != test.want {
+			t.Errorf("%s%s = %v, want %v", test.op, test.x, got, test.want)
+		}
+	}
+}
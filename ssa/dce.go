@@ -0,0 +1,379 @@
+package ssa
+
+// This file defines the dead code elimination pass, which deletes the
+// instructions and the blocks that do not contribute to the behaviour
+// of a function, and then simplifies its control flow graph.
+//
+// An instruction is live if it has side effects or if a live
+// instruction uses its value; for a local, the definitions of the local
+// are live. Calls and stores have side effects. So may the operators
+// and the indexing of tables: they raise errors on some values, such as
+// nil, and call the metamethods of others. So may the table
+// constructors, whose keys raise errors if they are nil or NaN. Unless
+// the AssumePlainValues option says otherwise, they are taken to have
+// side effects when they are not operations on constants that raise no
+// error, or constructors whose keys are all constants other than nil.
+//
+// An assignment to a local is a dead store if the local is not live
+// after it, which also removes the assignments overwritten before they
+// are read once the function is out of SSA form. Assignments to locals
+// captured by closures are never dead stores.
+//
+// Last, a block holding only a jump is bypassed, and a block with a
+// single successor is fused with it when it is its single predecessor.
+
+// DeadCodeMode is a bitmask of options for EliminateDeadCode.
+type DeadCodeMode uint
+
+const (
+	// AssumePlainValues assumes that the operators and the indexing of
+	// tables neither raise errors nor call metamethods, and that the
+	// keys of table constructors are neither nil nor NaN, so that they
+	// have no side effects.
+	AssumePlainValues DeadCodeMode = 1 << iota
+)
+
+// EliminateDeadCode deletes the dead code of fn and of the functions
+// nested in it.
+func EliminateDeadCode(fn *Function, mode DeadCodeMode) {
+	deleteDeadBlocks(fn)
+	for {
+		for eliminateDeadInstrs(fn, mode) || eliminateDeadStores(fn) {
+		}
+		if !simplifyBlocks(fn) {
+			break
+		}
+	}
+	pruneLocals(fn)
+	numberRegisters(fn)
+	for _, nested := range fn.Functions {
+		EliminateDeadCode(nested, mode)
+	}
+}
+
+// deleteDeadBlocks deletes the blocks of fn that are unreachable from
+// its entry.
+func deleteDeadBlocks(fn *Function) {
+	reachable := make(map[*BasicBlock]bool)
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		reachable[b] = true
+		for _, succ := range b.Succs {
+			if !reachable[succ] {
+				visit(succ)
+			}
+		}
+	}
+	visit(fn.Blocks[0])
+	if len(reachable) == len(fn.Blocks) {
+		return
+	}
+	for _, b := range fn.Blocks {
+		if !reachable[b] {
+			for _, instr := range b.Instrs {
+				removeUses(instr)
+			}
+		}
+	}
+	deleteUnreachableBlocks(fn)
+	if fn.Exit != nil && !reachable[fn.Exit] {
+		fn.Exit = nil // the function never returns
+	}
+}
+
+// hasSideEffects reports whether instr has side effects, or whether it
+// must stay for the structure of fn.
+func hasSideEffects(instr Instruction, mode DeadCodeMode) bool {
+	switch instr := instr.(type) {
+	case *Phi, *Logic, *Extract:
+		return false
+	case *NewTable:
+		if mode&AssumePlainValues != 0 {
+			return false
+		}
+		for _, f := range instr.Fields {
+			if _, isNil := f.Key.(Nil); isNil || f.Key != nil && !isConst(f.Key) {
+				return true
+			}
+		}
+		return false
+	case *Arithmetic, *Relation, *Concat, *Unary:
+		if u, ok := instr.(*Unary); ok && u.Op == "not " {
+			return false
+		}
+		if mode&AssumePlainValues != 0 {
+			return false
+		}
+		return !safe(instr)
+	case *Index:
+		return mode&AssumePlainValues == 0
+	case *Assign:
+		l, ok := instr.Lhs.(*Local)
+		return !ok || l.Outer != nil || l.Escapes
+	}
+	return true // calls, stores and terminators
+}
+
+// eliminateDeadInstrs deletes the instructions of fn that are not live,
+// and reports whether it deleted any.
+func eliminateDeadInstrs(fn *Function, mode DeadCodeMode) bool {
+	definitions := make(map[*Local][]Instruction)
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				definitions[l] = append(definitions[l], instr)
+			}
+		}
+	}
+
+	live := make(map[Instruction]bool)
+	var work []Instruction
+	mark := func(instr Instruction) {
+		if !live[instr] {
+			live[instr] = true
+			work = append(work, instr)
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if hasSideEffects(instr, mode) {
+				mark(instr)
+			}
+		}
+	}
+	for len(work) > 0 {
+		instr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, rand := range instr.Operands(nil) {
+			switch v := (*rand).(type) {
+			case *Local:
+				for _, def := range definitions[v] {
+					mark(def)
+				}
+			case Instruction:
+				if v.Parent() == fn {
+					mark(v)
+				}
+			}
+		}
+	}
+
+	changed := false
+	for _, b := range fn.Blocks {
+		for i, instr := range b.Instrs {
+			if !live[instr] {
+				removeUses(instr)
+				b.Instrs[i] = nil
+				changed = true
+			}
+		}
+		removeNilInstrs(b)
+	}
+	return changed
+}
+
+// eliminateDeadStores deletes the assignments to the locals of fn that
+// are not live after them, and reports whether it deleted any.
+func eliminateDeadStores(fn *Function) bool {
+	_, liveOut := localLiveness(fn)
+	changed := false
+	for _, b := range fn.Blocks {
+		live := make(map[*Local]bool)
+		for l := range liveOut[b.Index] {
+			live[l] = true
+		}
+		for i := len(b.Instrs) - 1; i >= 0; i-- {
+			instr := b.Instrs[i]
+			if a, ok := instr.(*Assign); ok && !hasSideEffects(a, 0) && !live[a.Lhs.(*Local)] {
+				removeUses(a)
+				b.Instrs[i] = nil
+				changed = true
+				continue
+			}
+			for _, l := range defs(instr) {
+				delete(live, l)
+			}
+			if _, ok := instr.(*Phi); ok {
+				continue // its edges are used in the predecessors
+			}
+			for _, rand := range instr.Operands(nil) {
+				if l, ok := (*rand).(*Local); ok {
+					live[l] = true
+				}
+			}
+		}
+		removeNilInstrs(b)
+	}
+	return changed
+}
+
+// localLiveness returns the locals of fn live at the start and at the
+// end of each block, by Block.Index. The edges of a φ-node are used at
+// the end of the corresponding predecessors.
+func localLiveness(fn *Function) (liveIn, liveOut []map[*Local]bool) {
+	n := len(fn.Blocks)
+	use := make([]map[*Local]bool, n) // used before any definition in the block
+	def := make([]map[*Local]bool, n)
+	liveIn = make([]map[*Local]bool, n)
+	liveOut = make([]map[*Local]bool, n)
+	for i := range fn.Blocks {
+		use[i], def[i] = make(map[*Local]bool), make(map[*Local]bool)
+		liveIn[i], liveOut[i] = make(map[*Local]bool), make(map[*Local]bool)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok {
+				for i, v := range phi.Edges {
+					if l, ok := v.(*Local); ok {
+						liveOut[b.Preds[i].Index][l] = true
+					}
+				}
+			} else {
+				for _, rand := range instr.Operands(nil) {
+					if l, ok := (*rand).(*Local); ok && !def[b.Index][l] {
+						use[b.Index][l] = true
+					}
+				}
+			}
+			for _, l := range defs(instr) {
+				def[b.Index][l] = true
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			b := fn.Blocks[i]
+			out, in := liveOut[i], liveIn[i]
+			for _, s := range b.Succs {
+				for l := range liveIn[s.Index] {
+					out[l] = true
+				}
+			}
+			for l := range use[i] {
+				if !in[l] {
+					in[l], changed = true, true
+				}
+			}
+			for l := range out {
+				if !def[i][l] && !in[l] {
+					in[l], changed = true, true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
+
+// simplifyBlocks bypasses the blocks of fn holding only a jump, and
+// fuses each block with its single successor when it is the single
+// predecessor of the successor. It reports whether it changed fn.
+func simplifyBlocks(fn *Function) bool {
+	simplified := false
+	for changed := true; changed; {
+		changed = false
+		for _, b := range fn.Blocks {
+			if b != nil && (bypass(fn, b) || fuse(fn, b)) {
+				changed = true
+			}
+		}
+		fn.removeNilBlocks()
+		simplified = simplified || changed
+	}
+	return simplified
+}
+
+// bypass redirects the predecessors of b to its successor and deletes
+// b if b holds only a jump, and reports whether it did.
+func bypass(fn *Function, b *BasicBlock) bool {
+	if b == fn.Blocks[0] || len(b.Instrs) != 1 || len(b.Succs) != 1 {
+		return false
+	}
+	if _, ok := b.Instrs[0].(*Jump); !ok {
+		return false
+	}
+	succ := b.Succs[0]
+	if succ == b || succ.hasPhi() {
+		return false
+	}
+	for _, p := range b.Preds {
+		if _, ok := p.Instrs[len(p.Instrs)-1].(*If); !ok && count(p.Succs, succ) > 0 {
+			return false
+		}
+	}
+	succ.removePred(b)
+	for _, p := range b.Preds {
+		p.replaceSucc(b, succ)
+		if count(succ.Preds, p) == 0 {
+			succ.Preds = append(succ.Preds, p)
+		}
+	}
+	for _, p := range b.Preds {
+		// An If whose branches both go to succ decides nothing.
+		if len(p.Succs) == 2 && p.Succs[0] == succ && p.Succs[1] == succ {
+			removeUses(p.Instrs[len(p.Instrs)-1])
+			jump := new(Jump)
+			jump.setBlock(p)
+			p.Instrs[len(p.Instrs)-1] = jump
+			p.Succs = p.Succs[:1]
+		}
+	}
+	fn.Blocks[b.Index] = nil
+	return true
+}
+
+// fuse moves to b the instructions of its single successor and deletes
+// the successor if b is its single predecessor, and reports whether it
+// did.
+func fuse(fn *Function, b *BasicBlock) bool {
+	if len(b.Succs) != 1 {
+		return false
+	}
+	succ := b.Succs[0]
+	if succ == b || succ == fn.Exit || len(succ.Preds) != 1 {
+		return false
+	}
+	if _, ok := b.Instrs[len(b.Instrs)-1].(*Jump); !ok {
+		return false
+	}
+	for _, instr := range succ.phis() {
+		phi := instr.(*Phi)
+		replaceAll(phi.Local, phi.Edges[0])
+		removeUses(phi)
+	}
+	b.Instrs = b.Instrs[:len(b.Instrs)-1]
+	for _, instr := range succ.Instrs[len(succ.phis()):] {
+		instr.setBlock(b)
+		b.Instrs = append(b.Instrs, instr)
+	}
+	b.Succs = append(b.Succs[:0], succ.Succs...)
+	for _, s := range b.Succs {
+		s.replacePred(succ, b)
+	}
+	fn.Blocks[succ.Index] = nil
+	return true
+}
+
+// pruneLocals deletes from fn.Locals the locals no longer defined or
+// used.
+func pruneLocals(fn *Function) {
+	keep := make(map[*Local]bool)
+	for _, p := range fn.Params {
+		keep[p] = true
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				keep[l] = true
+			}
+		}
+	}
+	locals := fn.Locals[:0]
+	for _, l := range fn.Locals {
+		if keep[l] || len(l.referrers) > 0 {
+			locals = append(locals, l)
+		}
+	}
+	fn.Locals = locals
+}
@@ -0,0 +1,98 @@
+package ssa
+
+import (
+	"testing"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		input string
+		mode  DeadCodeMode
+		want  string
+	}{
+		{
+			"local a = 1\nlocal b = a + x\nlocal c = {}\nf()\nreturn 2", 0,
+			"local _ = 1 + x;\nf();\nreturn 2;\n",
+		},
+		{
+			"local a = 1\nlocal b = a + x\nlocal c = {}\nf()\nreturn 2", AssumePlainValues,
+			"f();\nreturn 2;\n",
+		},
+		{
+			// A key may be nil.
+			"local t = {x = 1, [k] = 2}\nlocal u = {1, ['y'] = 2}\nreturn 3", 0,
+			"local _ = {\n\tx = 1,\n\t[k] = 2\n};\nreturn 3;\n",
+		},
+		{
+			"local t = {x = 1, [k] = 2}\nlocal u = {1, ['y'] = 2}\nreturn 3", AssumePlainValues,
+			"return 3;\n",
+		},
+		{
+			"local x = f()\nx = g()\nx = 3\nprint(x)", 0,
+			"f();\ng();\nprint(3);\n",
+		},
+		{
+			"local s = 0\nfor i = 1, 10 do local junk = s .. i s = s + i end\nreturn s", AssumePlainValues,
+			"local s = 0;\nfor i = 1, 10 do\n\ts = s + i;\nend;\nreturn s;\n",
+		},
+		{
+			"local y\nlocal function h() return y end\ny = 1\nreturn h", 0,
+			"local y;\nlocal function h()\n\treturn y;\nend;\ny = 1;\nreturn h;\n",
+		},
+		{
+			"local x = 10\nif c then x = 10 end\nprint(x / 4)", AssumePlainValues,
+			"print(2.5);\n",
+		},
+		{
+			"local i = 0\nwhile i < 10 do i = i + 1 end\nif i then return i end", 0,
+			"local i = 0;\nwhile i < 10 do\n\ti = i + 1;\nend;\nif i then\n\treturn i;\nend;\n",
+		},
+		{
+			// The header of the loop is fused with the entry.
+			"local a = f()\nfor k, v in pairs(a) do return k end\nprint(a)", 0,
+			"local a = f();\nfor k, v in pairs(a) do\n\treturn k;\nend;\nprint(a);\n",
+		},
+	}
+	for _, test := range tests {
+		dce := func(fn *Function) { EliminateDeadCode(fn, test.mode) }
+		if got := runPasses(test.input, t, FoldConstants, SCCP, dce); got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.input, got, test.want)
+		}
+	}
+}
+
+// TestEliminateDeadStores checks the dead stores out of SSA form, where
+// a local is assigned more than once.
+func TestEliminateDeadStores(t *testing.T) {
+	const src = `
+	local x = f()
+	if c then
+		x = g()
+		x = 1
+	end
+	return x
+	`
+	fn := build(src, t)
+	OutOfSSA(fn)
+	EliminateDeadCode(fn, 0)
+	if err := SanityCheck(fn); err != nil {
+		t.Fatal(err)
+	}
+	const want = "local x = f();\nif c then\n\tg();\n\tx = 1;\nend;\nreturn x;\n"
+	if got := decompile(fn, t); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSimplifyBlocks(t *testing.T) {
+	fn := build("if 1 / 2 == 0.5 then print('a') else print('b') end", t)
+	SCCP(fn)
+	EliminateDeadCode(fn, 0)
+	if err := SanityCheck(fn); err != nil {
+		t.Fatal(err)
+	}
+	// The entry holds all the code, and the exit block follows.
+	if len(fn.Blocks) != 2 || fn.Blocks[1] != fn.Exit {
+		t.Errorf("got %d blocks in\n%s", len(fn.Blocks), fn)
+	}
+}
@@ -1,5 +1,7 @@
 package ssa
 
+import "strings"
+
 func (f *Function) emitIf(cond Value, tblock, fblock *BasicBlock) {
 	b := f.currentBlock
 	b.emit(&If{Cond: cond})
@@ -32,12 +34,17 @@ func (f *Function) emitNumberFor(local, init, limit, step Value, body, done *Bas
 	f.currentBlock = nil
 }
 
-func (f *Function) emitCompoundAssign(op string, lhs Value, rhs Value) {
-	f.emit(&CompoundAssign{
-		Op:  op,
-		Lhs: lhs,
-		Rhs: rhs,
-	})
+// emitCompoundAssign emits lhs op rhs as a load of lhs, the operation
+// and a store of its result, so that locals can be lifted.
+func (f *Function) emitCompoundAssign(op string, lhs lvalue, rhs Value) {
+	var v Value
+	switch op = strings.TrimSuffix(op, "="); op {
+	case "..":
+		v = f.emit(&Concat{Lhs: lhs.load(f), Rhs: rhs})
+	default:
+		v = f.emit(&Arithmetic{Op: op, Lhs: lhs.load(f), Rhs: rhs})
+	}
+	lhs.store(f, v)
 }
 
 func (f *Function) EmitAssign(lhs Value, rhs Value) {
@@ -47,8 +54,13 @@ func (f *Function) EmitAssign(lhs Value, rhs Value) {
 	})
 }
 
-func (f *Function) emitReturn(cond Value, body *BasicBlock, done *BasicBlock) {
-	f.emit(&Return{})
+// emitReturn emits a return of results, which ends the current block
+// with an edge to the exit block.
+func (f *Function) emitReturn(results []Value, spread bool) {
+	b := f.currentBlock
+	b.emit(&Return{Results: results, Spread: spread})
+	addEdge(b, f.Exit)
+	f.currentBlock = nil
 }
 
 func (f *Function) emitLocalAssign(name string, value Value) {
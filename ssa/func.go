@@ -4,6 +4,7 @@ package ssa
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hootrhino/beautiful-lua-go/ast"
@@ -23,8 +24,10 @@ func (b *BasicBlock) Parent() *Function { return b.parent }
 func (b *BasicBlock) emit(i Instruction) Value {
 	i.setBlock(b)
 	b.Instrs = append(b.Instrs, i)
-	v, _ := i.(Value)
-	return v
+	if _, ok := i.(interface{ Name() string }); ok {
+		return i.(Value)
+	}
+	return nil
 }
 
 // predIndex returns the i such that b.Preds[i] == c or panics if
@@ -40,6 +43,9 @@ func (b *BasicBlock) predIndex(c *BasicBlock) int {
 
 // hasPhi returns true if b.Instrs contains φ-nodes.
 func (b *BasicBlock) hasPhi() bool {
+	if len(b.Instrs) == 0 {
+		return false
+	}
 	_, ok := b.Instrs[0].(*Phi)
 	return ok
 }
@@ -51,7 +57,7 @@ func (b *BasicBlock) phis() []Instruction {
 			return b.Instrs[:i]
 		}
 	}
-	return nil // unreachable in well-formed blocks
+	return b.Instrs // a block without a terminator yet
 }
 
 // replacePred replaces all occurrences of p in b's predecessor list with q.
@@ -111,9 +117,19 @@ func (b *BasicBlock) removePred(p *BasicBlock) {
 // We populate these as labels are encountered in forward gotos or
 // labelled statements.
 type lblock struct {
-	_goto     *BasicBlock
-	_break    *BasicBlock
-	_continue *BasicBlock
+	_goto   *BasicBlock
+	defined bool // the label statement has been built
+	atEnd   bool // only labels follow the label in its block
+	nlocals int  // locals of the scope of the label before it, once defined
+
+	// Forward gotos, with the locals of the scope of the label before
+	// each of them.
+	pending []pendingGoto
+}
+
+type pendingGoto struct {
+	line    int
+	nlocals int
 }
 
 // addParam adds a (non-escaping) parameter to f.Params of the
@@ -122,51 +138,101 @@ func (f *Function) addParam(name string) {
 	f.Params = append(f.Params, f.addLocal(name))
 }
 
-func (f *Function) addLocal(name string) *Local {
+// newLocal adds to f a local that is not in scope, like a temporary.
+func (f *Function) newLocal(name string) *Local {
 	local := &Local{
 		Comment: name,
 		Value:   Nil{},
 		Num:     len(f.Locals) + 1,
+		parent:  f,
 	}
 	f.Locals = append(f.Locals, local)
+	return local
+}
+
+func (f *Function) addLocal(name string) *Local {
+	local := f.newLocal(name)
 	f.currentScope.names[name] = local
+	f.currentScope.locals = append(f.currentScope.locals, local)
 	return local
 }
 
-func (f *Function) addGlobal(name string) *Global {
-	global := &Global{
-		Comment: name,
+// env returns the _ENV in scope: a local named _ENV, or else the
+// environment of the chunk.
+func (f *Function) env() Value {
+	if v := f.lookup("_ENV"); v != nil {
+		return v
+	}
+	root := f
+	for root.parent != nil {
+		root = root.parent
 	}
-	//f.Globals = append(f.Globals, global)
-	return global
+	if root.Env == nil {
+		root.Env = &Global{Comment: "_ENV", parent: root}
+	}
+	return root.Env
 }
 
+// addFunction adds to f a function nested in its current scope, whose
+// names the function can capture.
 func (f *Function) addFunction(syntax *ast.FunctionExpr) *Function {
 	fn := &Function{
-		parent: f,
-		syntax: syntax,
-		num:    len(f.Functions) + 1,
+		parent:       f,
+		syntax:       syntax,
+		num:          len(f.Functions) + 1,
+		currentScope: f.currentScope,
 	}
+	fn.newScope()
 	f.Functions = append(f.Functions, fn)
 	return fn
 }
 
+// upValue returns the upvalue of f capturing local, a local of an
+// enclosing function, adding it and the upvalues of the functions in
+// between as needed.
+func (f *Function) upValue(local *Local) *Local {
+	if local.parent == f {
+		return local
+	}
+	outer := f.parent.upValue(local)
+	for _, u := range f.UpValues {
+		if u.Outer == outer {
+			return u
+		}
+	}
+	outer.Escapes = true
+	u := &Local{
+		Comment: local.Comment,
+		Value:   Nil{},
+		Num:     len(f.UpValues) + 1,
+		Outer:   outer,
+		parent:  f,
+	}
+	f.UpValues = append(f.UpValues, u)
+	return u
+}
+
 // StartBody initializes the function prior to generating SSA code for its body.
 // Precondition: f.Type() already set.
 func (f *Function) StartBody() {
 	f.currentBlock = f.NewBasicBlock("entry")
+	f.Exit = f.NewBasicBlock("exit")
 }
 
 func (f *Function) newScope() *Scope {
 	old := f.currentScope
-	f.currentScope = &Scope{f, f.currentScope, make(map[string]Variable)}
+	f.currentScope = &Scope{
+		function: f,
+		parent:   f.currentScope,
+		names:    make(map[string]Variable),
+		labels:   make(map[string]*lblock),
+	}
 	return old
 }
 
 // buildReferrers populates the def/use information in all non-nil
 // Value.Referrers slice.
 // Precondition: all such slices are initially empty.
-/*
 func buildReferrers(f *Function) {
 	var rands []*Value
 	for _, b := range f.Blocks {
@@ -182,14 +248,101 @@ func buildReferrers(f *Function) {
 		}
 	}
 }
-*/
+
+// replaceAll replaces all intraprocedural uses of x with y,
+// updating x.Referrers and y.Referrers.
+func replaceAll(x, y Value) {
+	var rands []*Value
+	pxrefs := x.Referrers()
+	pyrefs := y.Referrers()
+	for _, instr := range *pxrefs {
+		rands = instr.Operands(rands[:0]) // recycle storage
+		for _, rand := range rands {
+			if *rand != nil {
+				if *rand == x {
+					*rand = y
+				}
+			}
+		}
+		if pyrefs != nil {
+			*pyrefs = append(*pyrefs, instr) // dups ok
+		}
+	}
+	*pxrefs = nil // x is now unreferenced
+}
+
+// removeInstr removes all occurrences of instr from refs, a list of
+// Referrers. Use it when an instruction stops using a value.
+func removeInstr(refs []Instruction, instr Instruction) []Instruction {
+	i := 0
+	for _, ref := range refs {
+		if ref == instr {
+			continue
+		}
+		refs[i] = ref
+		i++
+	}
+	for j := i; j != len(refs); j++ {
+		refs[j] = nil // aid GC
+	}
+	return refs[:i]
+}
+
+// numberRegisters assigns numbers to the locals and the registers of f
+// in the order of their definitions. Calls whose results are unused do
+// not get a number.
+func numberRegisters(f *Function) {
+	n := 0
+	seen := make(map[*Local]bool)
+	local := func(local *Local) {
+		if !seen[local] && local.Outer == nil {
+			seen[local] = true
+			n++
+			local.Num = n
+		}
+	}
+	for _, p := range f.Params {
+		local(p)
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if r, ok := instr.(interface{ setNum(int) }); ok && !isUnusedCall(instr) {
+				n++
+				r.setNum(n)
+			}
+			for _, l := range defs(instr) {
+				local(l)
+			}
+		}
+	}
+}
+
 // finishBody() finalizes the function after SSA code generation of its body.
 func (f *Function) finishBody() {
 	f.currentBlock = nil
+	f.currentScope = nil
 
-	//buildReferrers(f)
+	// Move the exit block to the end.
+	f.Blocks = append(f.Blocks[:f.Exit.Index], f.Blocks[f.Exit.Index+1:]...)
+	f.Blocks = append(f.Blocks, f.Exit)
+	for i, b := range f.Blocks {
+		b.Index = i
+	}
+	deleteUnreachableBlocks(f)
+	if f.Blocks[len(f.Blocks)-1] != f.Exit {
+		f.Exit = nil // the function never returns
+	}
 
-	//lift(f)
+	lift(f)
+	buildReferrers(f)
+	numberRegisters(f)
+}
+
+// isUnusedCall reports whether instr is a call statement, whose results
+// are not used.
+func isUnusedCall(instr Instruction) bool {
+	call, ok := instr.(*Call)
+	return ok && len(call.referrers) == 0
 }
 
 // removeNilBlocks eliminates nils from f.Blocks and updates each
@@ -210,18 +363,40 @@ func (f *Function) removeNilBlocks() {
 	f.Blocks = f.Blocks[:j]
 }
 
-func (s *Scope) lookup(name string) Value {
-	if v, ok := s.names[name]; ok {
-		return v
+// lookup returns the local that name refers to in s, or nil if name is
+// not declared.
+func (s *Scope) lookup(name string) Variable {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.names[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// label returns the label visible from s by that name and the scope
+// declaring it. Labels are not visible in nested functions.
+func (s *Scope) label(name string) (*lblock, *Scope) {
+	if s == nil {
+		return nil, nil
 	}
-	if s.parent == nil {
-		return s.function.addGlobal(name)
+	for fn := s.function; s != nil && s.function == fn; s = s.parent {
+		if l, ok := s.labels[name]; ok {
+			return l, s
+		}
 	}
-	return s.parent.lookup(name)
+	return nil, nil
 }
 
-func (f *Function) lookup(name string) Value {
-	return f.currentScope.lookup(name)
+// lookup returns the local that name refers to in the current scope of
+// f, or nil if name is not declared. A local of an enclosing function is
+// captured as an upvalue.
+func (f *Function) lookup(name string) Variable {
+	v := f.currentScope.lookup(name)
+	if local, ok := v.(*Local); ok {
+		return f.upValue(local)
+	}
+	return v
 }
 
 // emit emits the specified instruction to function f.
@@ -245,6 +420,46 @@ func (f *Function) NewBasicBlock(comment string) *BasicBlock {
 
 func (f *Function) Syntax() *ast.FunctionExpr { return f.syntax }
 
+// Globals returns the sorted names of the globals f reads and writes,
+// not counting its nested functions. Globals are the fields of the
+// environment of the chunk with a constant name; the fields of a local
+// _ENV are not.
+func (f *Function) Globals() (reads, writes []string) {
+	add := func(names []string, object, key Value) []string {
+		if _, ok := object.(*Global); !ok {
+			return names
+		}
+		if key, ok := key.(String); ok {
+			names = append(names, key.Value)
+		}
+		return names
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *Index:
+				reads = add(reads, instr.Object, instr.Key)
+			case *Store:
+				writes = add(writes, instr.Object, instr.Key)
+			}
+		}
+	}
+	return uniq(reads), uniq(writes)
+}
+
+// uniq sorts names and removes the duplicates.
+func uniq(names []string) []string {
+	sort.Strings(names)
+	j := 0
+	for i, name := range names {
+		if i == 0 || name != names[j-1] {
+			names[j] = name
+			j++
+		}
+	}
+	return names[:j]
+}
+
 func WriteFunction(b *strings.Builder, f *Function) {
 	for _, fn := range f.Functions {
 		WriteFunction(b, fn)
@@ -252,7 +467,9 @@ func WriteFunction(b *strings.Builder, f *Function) {
 
 	const punchcard = 80
 
-	b.WriteString("\nfunction ")
+	b.WriteString("\n")
+	start := b.Len()
+	b.WriteString("function ")
 	b.WriteString(f.Name)
 	b.WriteString("(")
 	for i, arg := range f.Params {
@@ -268,7 +485,15 @@ func WriteFunction(b *strings.Builder, f *Function) {
 		b.WriteString("...")
 	}
 	bmsg := fmt.Sprintf("locals:%d upvalues:%d", len(f.Locals), len(f.UpValues))
-	fmt.Fprintf(b, ")%*s%s\n", punchcard-1-len(bmsg)-len(b.String()), "", bmsg)
+	fmt.Fprintf(b, ")%*s%s\n", punchcard-2-len(bmsg)-(b.Len()-start), "", bmsg)
+	for _, u := range f.UpValues {
+		fmt.Fprintf(b, "# %s %s = %s of %s\n", u.Name(), u.Comment, u.Outer.Name(), relName(u.Outer.parent))
+	}
+	for _, l := range f.Locals {
+		if l.Escapes {
+			fmt.Fprintf(b, "# %s %s escapes\n", l.Name(), l.Comment)
+		}
+	}
 
 	for _, block := range f.Blocks {
 		if block == nil {
@@ -291,6 +516,9 @@ func WriteFunction(b *strings.Builder, f *Function) {
 				b.WriteString("<deleted>\n")
 				continue
 			}
+			if v, ok := instr.(interface{ Name() string }); ok && !isUnusedCall(instr) {
+				fmt.Fprintf(b, "%s = ", v.Name())
+			}
 			b.WriteString(instr.String())
 			b.WriteString("\n")
 		}
@@ -0,0 +1,198 @@
+package ssa
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkReferrers reports operands whose referrers do not list the
+// instructions using them, and referrers that do not use the value.
+func checkReferrers(t *testing.T, fn *Function) {
+	t.Helper()
+	uses := func(instr Instruction, v Value) bool {
+		for _, rand := range instr.Operands(nil) {
+			if *rand == v {
+				return true
+			}
+		}
+		return false
+	}
+	check := func(v Value) {
+		refs := v.Referrers()
+		if refs == nil {
+			return
+		}
+		for _, ref := range *refs {
+			if !uses(ref, v) {
+				t.Errorf("%s lists referrer %q which does not use it", relName(v), ref)
+			}
+		}
+	}
+	for _, local := range fn.Locals {
+		check(local)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if v, ok := instr.(Value); ok {
+				check(v)
+			}
+			for _, rand := range instr.Operands(nil) {
+				if *rand == nil || (*rand).Referrers() == nil {
+					continue
+				}
+				found := false
+				for _, ref := range *(*rand).Referrers() {
+					found = found || ref == instr
+				}
+				if !found {
+					t.Errorf("%q is not a referrer of its operand %s", instr, relName(*rand))
+				}
+			}
+		}
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	fn := build(`
+	local t = {1, x = y}
+	local a = t.x + f(t, 2) * -t[1]
+	t.y = a .. "s"
+	if a < 3 and b then
+		a = a + 1
+	end
+	g:m(a, t)
+	`, t)
+	checkSSA(t, fn)
+	checkReferrers(t, fn)
+
+	// Expressions are flat: every operand is a constant, a local, a
+	// global or a register.
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, rand := range instr.Operands(nil) {
+				switch v := (*rand).(type) {
+				case nil, Nil, True, False, Number, String, VarArg, *Local, *Global, *Function:
+				default:
+					if v.Parent() != fn {
+						t.Errorf("operand %s of %q is not in %s", relName(v), instr, fn.Name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	fn := build(`
+	local a = x + 1
+	print(a, a * 2)
+	`, t)
+	var add *Arithmetic
+	for _, instr := range fn.Blocks[0].Instrs {
+		if v, ok := instr.(*Arithmetic); ok && v.Op == "+" {
+			add = v
+		}
+	}
+	if add == nil {
+		t.Fatalf("no addition in\n%s", fn)
+	}
+	a := (*add.Referrers())[0].(*Assign).Lhs.(*Local)
+	n := len(*a.Referrers())
+	if n != 2 {
+		t.Fatalf("%s has %d referrers, want 2", a, n)
+	}
+	replaceAll(a, Number{Value: 3})
+	if len(*a.Referrers()) != 0 {
+		t.Errorf("%s still has referrers %v", a, *a.Referrers())
+	}
+	for _, instr := range fn.Blocks[0].Instrs {
+		for _, rand := range instr.Operands(nil) {
+			if *rand == Value(a) {
+				t.Errorf("%q still uses %s", instr, a)
+			}
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	fn := build(`
+	x = y + 1
+	g += 1
+	print(_ENV.z)
+	do
+		local _ENV = {print = print}
+		print(w)
+		v = 1
+	end
+	_ENV = {}
+	q = 1
+	`, t)
+	checkReferrers(t, fn)
+	reads, writes := fn.Globals()
+	if got, want := strings.Join(reads, " "), "g print y z"; got != want {
+		t.Errorf("got reads %q, want %q", got, want)
+	}
+	if got, want := strings.Join(writes, " "), "g q x"; got != want {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+	if fn.Env == nil || len(*fn.Env.Referrers()) != 9 {
+		t.Errorf("_ENV is not used by the globals:\n%s", fn)
+	}
+}
+
+func TestUpValues(t *testing.T) {
+	fn := build(`
+	local a, b, c = 1, 2, 3
+	local function f(x)
+		a = a + x
+		return function() return a + b + x end
+	end
+	b = c
+	print(f(1), a)
+	`, t)
+	checkReferrers(t, fn)
+	f := fn.Functions[0]
+	g := f.Functions[0]
+
+	var escapes []string
+	for _, l := range fn.Locals {
+		if l.Escapes {
+			escapes = append(escapes, l.Comment)
+		}
+	}
+	if got := strings.Join(escapes, " "); got != "a b" {
+		t.Errorf("got escaping locals %q, want %q:\n%s", got, "a b", fn)
+	}
+
+	// The upvalues of g capture the ones of f, which capture the locals
+	// of main.
+	want := map[string]*Local{}
+	for _, l := range fn.Locals {
+		want[l.Comment] = l
+	}
+	if len(f.UpValues) != 2 {
+		t.Fatalf("got %d upvalues, want 2:\n%s", len(f.UpValues), f)
+	}
+	for _, u := range f.UpValues {
+		if u.Outer != want[u.Comment] || u.Parent() != f {
+			t.Errorf("upvalue %s of f captures %s", u.Comment, u.Outer)
+		}
+		want[u.Comment] = u
+	}
+	want["x"] = f.Params[0]
+	if len(g.UpValues) != 3 {
+		t.Fatalf("got %d upvalues, want 3:\n%s", len(g.UpValues), g)
+	}
+	for _, u := range g.UpValues {
+		if u.Outer != want[u.Comment] || !u.Outer.Escapes {
+			t.Errorf("upvalue %s of g captures %s", u.Comment, u.Outer)
+		}
+	}
+
+	// An assignment in f is an assignment of its upvalue.
+	for _, instr := range f.Blocks[0].Instrs {
+		if assign, ok := instr.(*Assign); ok && assign.Lhs != Value(f.UpValues[0]) {
+			t.Errorf("%s does not assign the upvalue a", assign)
+		}
+	}
+}
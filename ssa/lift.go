@@ -1,4 +1,20 @@
-package ssa 
+package ssa
+
+// This file defines the lifting pass which turns the mutable locals of a
+// function into SSA values, inserting φ-nodes at the points where
+// control flow merges.
+//
+// The algorithm is the one of Cytron et al, Efficiently computing static
+// single assignment form and the control dependence graph, TOPLAS 1991:
+// φ-nodes are placed on the iterated dominance frontier of the blocks
+// assigning each local, then every use is renamed to its reaching
+// definition by a walk over the dominator tree. φ-nodes whose value is
+// never used are pruned at the end.
+//
+// Every definition of a lifted local gets a *Local of its own, so
+// after lifting each *Local has exactly one definition: a parameter, an
+// Assign, a Phi or a loop instruction. The versions of a source variable
+// share its Comment. Locals captured by nested functions are not lifted.
 
 // domFrontier maps each block to the set of blocks in its dominance
 // frontier.  The outer slice is conceptually a map keyed by
@@ -7,8 +23,6 @@ package ssa
 //
 // domFrontier's methods mutate the slice's elements but not its
 // length, so their receivers needn't be pointers.
-//
-
 type domFrontier [][]*BasicBlock
 
 func (df domFrontier) add(u, v *BasicBlock) {
@@ -38,4 +52,292 @@ func buildDomFrontier(fn *Function) domFrontier {
 	df := make(domFrontier, len(fn.Blocks))
 	df.build(fn.Blocks[0])
 	return df
-}
\ No newline at end of file
+}
+
+// lifter holds the state of the lifting of one function.
+type lifter struct {
+	fn     *Function
+	lifted map[*Local]bool     // the locals being lifted
+	orig   map[*Phi]*Local     // the local each inserted φ-node merges
+	stacks map[*Local][]*Local // the reaching version of each lifted local
+}
+
+// lift replaces the lifted locals of fn with SSA values.
+// Precondition: all blocks are reachable.
+func lift(fn *Function) {
+	buildDomTree(fn)
+	df := buildDomFrontier(fn)
+
+	l := &lifter{
+		fn:     fn,
+		lifted: make(map[*Local]bool),
+		orig:   make(map[*Phi]*Local),
+		stacks: make(map[*Local][]*Local),
+	}
+	for _, local := range fn.Locals {
+		if !local.Escapes {
+			l.lifted[local] = true
+		}
+	}
+	for _, p := range fn.Params {
+		if l.lifted[p] {
+			l.stacks[p] = []*Local{p}
+		}
+	}
+
+	l.placePhis(df)
+	l.rename(fn.Blocks[0])
+	l.prunePhis()
+	l.renumber()
+}
+
+// defs returns the locals assigned by instr.
+func defs(instr Instruction) []*Local {
+	var locals []*Local
+	add := func(v Value) {
+		if local, ok := v.(*Local); ok {
+			locals = append(locals, local)
+		}
+	}
+	switch instr := instr.(type) {
+	case *Assign:
+		add(instr.Lhs)
+	case *NumberFor:
+		add(instr.Local)
+	case *GenericFor:
+		for _, v := range instr.Locals {
+			add(v)
+		}
+	case *Phi:
+		if instr.Local != nil {
+			add(instr.Local)
+		}
+	}
+	return locals
+}
+
+// placePhis inserts a φ-node for each lifted local at the start of every
+// block on the iterated dominance frontier of the blocks assigning it.
+func (l *lifter) placePhis(df domFrontier) {
+	defblocks := make(map[*Local][]*BasicBlock)
+	var order []*Local // for a deterministic result
+	note := func(local *Local, b *BasicBlock) {
+		if !l.lifted[local] {
+			return
+		}
+		if _, ok := defblocks[local]; !ok {
+			order = append(order, local)
+		}
+		defblocks[local] = append(defblocks[local], b)
+	}
+	for _, p := range l.fn.Params {
+		note(p, l.fn.Blocks[0])
+	}
+	for _, b := range l.fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, local := range defs(instr) {
+				note(local, b)
+			}
+		}
+	}
+
+	newPhis := make([][]Instruction, len(l.fn.Blocks))
+	for _, local := range order {
+		hasPhi := make(map[*BasicBlock]bool)
+		queued := make(map[*BasicBlock]bool)
+		work := append([]*BasicBlock(nil), defblocks[local]...)
+		for _, b := range work {
+			queued[b] = true
+		}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, y := range df[b.Index] {
+				if hasPhi[y] {
+					continue
+				}
+				hasPhi[y] = true
+				phi := &Phi{
+					Comment: local.Comment,
+					Edges:   make([]Value, len(y.Preds)),
+				}
+				phi.setBlock(y)
+				l.orig[phi] = local
+				newPhis[y.Index] = append(newPhis[y.Index], phi)
+				if !queued[y] {
+					queued[y] = true
+					work = append(work, y)
+				}
+			}
+		}
+	}
+	for i, phis := range newPhis {
+		if len(phis) > 0 {
+			b := l.fn.Blocks[i]
+			b.Instrs = append(phis, b.Instrs...)
+		}
+	}
+}
+
+// version returns a new definition of the lifted local orig.
+func (l *lifter) version(orig *Local, value Value) *Local {
+	v := &Local{Comment: orig.Comment, Value: value, parent: l.fn, orig: orig}
+	l.stacks[orig] = append(l.stacks[orig], v)
+	return v
+}
+
+// current returns the definition of local reaching the current point,
+// or Nil if local is not defined there.
+func (l *lifter) current(local *Local) Value {
+	if !l.lifted[local] {
+		return local
+	}
+	stack := l.stacks[local]
+	if len(stack) == 0 {
+		return Nil{}
+	}
+	return stack[len(stack)-1]
+}
+
+// rename replaces the uses and definitions of the lifted locals in b
+// and the blocks it dominates by their versions.
+func (l *lifter) rename(b *BasicBlock) {
+	depth := make(map[*Local]int)
+	for orig, stack := range l.stacks {
+		depth[orig] = len(stack)
+	}
+
+	for _, instr := range b.Instrs {
+		if phi, ok := instr.(*Phi); ok {
+			if orig, ok := l.orig[phi]; ok {
+				phi.Local = l.version(orig, phi)
+			}
+			continue
+		}
+		l.renameInstr(instr)
+	}
+
+	for _, succ := range b.Succs {
+		j := succ.predIndex(b)
+		for _, instr := range succ.Instrs {
+			phi, ok := instr.(*Phi)
+			if !ok {
+				break
+			}
+			if orig, ok := l.orig[phi]; ok {
+				phi.Edges[j] = l.current(orig)
+			}
+		}
+	}
+
+	for _, child := range b.dom.children {
+		l.rename(child)
+	}
+
+	for orig := range l.stacks {
+		l.stacks[orig] = l.stacks[orig][:depth[orig]]
+	}
+}
+
+// renameInstr renames the locals used and defined by instr.
+func (l *lifter) renameInstr(instr Instruction) {
+	for _, rand := range instr.Operands(nil) {
+		if local, ok := (*rand).(*Local); ok {
+			*rand = l.current(local)
+		}
+	}
+	def := func(v Value, value Value) Value {
+		if local, ok := v.(*Local); ok && l.lifted[local] {
+			return l.version(local, value)
+		}
+		return v
+	}
+	switch instr := instr.(type) {
+	case *Assign:
+		instr.Lhs = def(instr.Lhs, instr.Rhs)
+	case *NumberFor:
+		instr.Local = def(instr.Local, Nil{})
+	case *GenericFor:
+		for i, v := range instr.Locals {
+			instr.Locals[i] = def(v, Nil{})
+		}
+	}
+}
+
+// prunePhis deletes the φ-nodes whose value is not used, directly or
+// through other φ-nodes, by any other instruction.
+func (l *lifter) prunePhis() {
+	defPhi := make(map[*Local]*Phi)
+	for _, b := range l.fn.Blocks {
+		for _, instr := range b.phis() {
+			phi := instr.(*Phi)
+			defPhi[phi.Local] = phi
+		}
+	}
+
+	live := make(map[*Phi]bool)
+	var work []*Phi
+	mark := func(v Value) {
+		local, _ := v.(*Local)
+		if phi, ok := defPhi[local]; ok && !live[phi] {
+			live[phi] = true
+			work = append(work, phi)
+		}
+	}
+	var rands []*Value
+	for _, b := range l.fn.Blocks {
+		for _, instr := range b.Instrs {
+			if _, ok := instr.(*Phi); !ok {
+				for _, rand := range instr.Operands(rands[:0]) {
+					mark(*rand)
+				}
+			}
+		}
+	}
+	for len(work) > 0 {
+		phi := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, edge := range phi.Edges {
+			mark(edge)
+		}
+	}
+
+	for _, b := range l.fn.Blocks {
+		j := 0
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok && !live[phi] {
+				continue
+			}
+			b.Instrs[j] = instr
+			j++
+		}
+		for i := j; i < len(b.Instrs); i++ {
+			b.Instrs[i] = nil
+		}
+		b.Instrs = b.Instrs[:j]
+	}
+}
+
+// renumber rebuilds fn.Locals from the definitions left in fn, in order.
+func (l *lifter) renumber() {
+	fn := l.fn
+	seen := make(map[*Local]bool)
+	locals := fn.Locals[:0]
+	add := func(local *Local) {
+		if !seen[local] && local.Outer == nil {
+			seen[local] = true
+			locals = append(locals, local)
+		}
+	}
+	for _, p := range fn.Params {
+		add(p)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, local := range defs(instr) {
+				add(local)
+			}
+		}
+	}
+	fn.Locals = locals
+}
@@ -0,0 +1,144 @@
+package ssa
+
+import (
+	"testing"
+)
+
+// phis returns the φ-nodes of fn.
+func phis(fn *Function) []*Phi {
+	var phis []*Phi
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok {
+				phis = append(phis, phi)
+			}
+		}
+	}
+	return phis
+}
+
+// checkSSA reports locals of fn defined more than once or used without a
+// definition.
+func checkSSA(t *testing.T, fn *Function) {
+	t.Helper()
+	defined := make(map[*Local]bool)
+	for _, p := range fn.Params {
+		defined[p] = true
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, local := range defs(instr) {
+				if defined[local] {
+					t.Errorf("%s defined twice in\n%s", local, fn)
+				}
+				defined[local] = true
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, rand := range instr.Operands(nil) {
+				if local, ok := (*rand).(*Local); ok && !defined[local] {
+					t.Errorf("%s used in %q but not defined", local, instr)
+				}
+			}
+		}
+	}
+}
+
+func TestLift(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		phis  []string
+	}{
+		{"straight", `local x = 1 x = x + 1 print(x)`, nil},
+		{"if", `local x = 1 if c then x = 2 end print(x)`, []string{"x"}},
+		{"ifelse", `local x if c then x = 1 else x = 2 end print(x)`, []string{"x"}},
+		{"dead", `local x = 1 if c then x = 2 end`, nil},
+		{"scoped", `if c then local x = 1 print(x) end print(x)`, nil},
+		{"while", `local i = 0 while i < 10 do i += 1 end`, []string{"i"}},
+		{"break", `local i = 0 while true do i = i + 1 if i > 5 then break end end print(i)`, []string{"i", "i"}},
+		{"repeat", `local i = 0 repeat local j = i i = j + 1 until j > 3`, []string{"i"}},
+		{"for", `local s = 0 for i = 1, 10 do s = s + i end print(s)`, []string{"s"}},
+		{"generic", `local s for k, v in pairs(t) do s = v end print(s)`, []string{"s"}},
+	}
+	for _, test := range tests {
+		fn := build(test.input, t)
+		checkSSA(t, fn)
+		var got []string
+		for _, phi := range phis(fn) {
+			got = append(got, phi.Comment)
+		}
+		if len(got) != len(test.phis) {
+			t.Errorf("%s: got phis %q, want %q in\n%s", test.name, got, test.phis, fn)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.phis[i] {
+				t.Errorf("%s: got phis %q, want %q in\n%s", test.name, got, test.phis, fn)
+				break
+			}
+		}
+	}
+}
+
+func TestLiftEdges(t *testing.T) {
+	fn := build(`
+	local x = 1
+	if c then
+		x = 2
+	end
+	print(x)
+	`, t)
+	ps := phis(fn)
+	if len(ps) != 1 {
+		t.Fatalf("got %d phis, want 1 in\n%s", len(ps), fn)
+	}
+	phi := ps[0]
+	if len(phi.Edges) != len(phi.Block().Preds) {
+		t.Fatalf("phi %s has %d edges for %d preds", phi, len(phi.Edges), len(phi.Block().Preds))
+	}
+	want := map[string]bool{"1": true, "2": true}
+	for _, edge := range phi.Edges {
+		local, ok := edge.(*Local)
+		if !ok || !want[local.Value.String()] {
+			t.Errorf("unexpected edge %s in %s", edge, phi)
+			continue
+		}
+		delete(want, local.Value.String())
+	}
+
+	// The call uses the φ-node.
+	refs := *phi.Local.Referrers()
+	if len(refs) != 1 {
+		t.Fatalf("%s has referrers %v, want the call", phi.Local, refs)
+	}
+	if call, ok := refs[0].(*Call); !ok || call.Args[0] != phi.Local {
+		t.Errorf("%s used by %s, want print(%s)", phi.Local, refs[0], phi.Local)
+	}
+}
+
+func TestLiftParams(t *testing.T) {
+	fn := build(`
+	local function f(a)
+		if a then
+			a = 1
+		end
+		print(a)
+	end
+	`, t)
+	f := fn.Functions[0]
+	checkSSA(t, f)
+	ps := phis(f)
+	if len(ps) != 1 || ps[0].Comment != "a" {
+		t.Fatalf("got phis %v, want one for a in\n%s", ps, f)
+	}
+	found := false
+	for _, edge := range ps[0].Edges {
+		found = found || edge == f.Params[0]
+	}
+	if !found {
+		t.Errorf("phi %s does not merge parameter %s", ps[0], f.Params[0])
+	}
+}
@@ -0,0 +1,44 @@
+package ssa
+
+// lvalues are the union of addressable expressions: the locals, the
+// globals and the fields of tables.
+
+// An lvalue represents an assignable location that may appear on the
+// left-hand side of an assignment.
+type lvalue interface {
+	store(fn *Function, v Value) // stores v into the location
+	load(fn *Function) Value     // loads the contents of the location
+}
+
+// A variable is an lvalue for a local or a global.
+type variable struct {
+	v Value
+}
+
+func (v *variable) store(fn *Function, val Value) {
+	fn.EmitAssign(v.v, val)
+}
+
+func (v *variable) load(fn *Function) Value {
+	return v.v
+}
+
+// An element is an lvalue for the field key of the table object.
+type element struct {
+	object, key Value
+}
+
+func (e *element) store(fn *Function, v Value) {
+	fn.emit(&Store{
+		Object: e.object,
+		Key:    e.key,
+		Value:  v,
+	})
+}
+
+func (e *element) load(fn *Function) Value {
+	return fn.emit(&Index{
+		Object: e.object,
+		Key:    e.key,
+	})
+}
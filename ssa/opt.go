@@ -0,0 +1,197 @@
+package ssa
+
+// This file defines the constant folding pass, which computes the
+// operations on constants and propagates their results.
+//
+// An operation whose operands are all constants is replaced by its
+// result, with the semantics of const.go. So is `a and b` or `a or b`
+// when a is a constant, and a φ-node all of whose edges are the same
+// constant. Then a local assigned a constant once, and not captured by
+// closures, is replaced by the constant at its uses, but for the edges
+// of the φ-nodes of its source variable: out-of-SSA translation
+// coalesces it with them, and the variable holds the constant already.
+// The assignment is left in place for dead code elimination.
+
+// FoldConstants folds the constant operations of fn and of the
+// functions nested in it, and propagates the constants through the
+// assignments to locals.
+func FoldConstants(fn *Function) {
+	foldConstants(fn)
+	for _, nested := range fn.Functions {
+		FoldConstants(nested)
+	}
+}
+
+func foldConstants(fn *Function) {
+	removed := make(map[*Local]bool) // the locals of the removed φ-nodes
+	for changed := true; changed; {
+		changed = false
+		assigns := constAssigns(fn)
+		for _, b := range fn.Blocks {
+			for i, instr := range b.Instrs {
+				if a, ok := instr.(*Assign); ok {
+					if l, ok := a.Lhs.(*Local); ok && assigns[l] != nil && replaceConst(l, a.Rhs) {
+						changed = true
+					}
+					continue
+				}
+				var v Value
+				var ok bool
+				if phi, isPhi := instr.(*Phi); isPhi {
+					v, ok = foldPhi(phi, assigns)
+				} else {
+					v, ok = fold(instr)
+				}
+				if !ok {
+					continue
+				}
+				removeUses(instr)
+				if phi, ok := instr.(*Phi); ok {
+					replaceAll(phi.Local, v)
+					removed[phi.Local] = true
+				} else {
+					replaceAll(instr.(Value), v)
+				}
+				b.Instrs[i] = nil
+				changed = true
+			}
+			removeNilInstrs(b)
+		}
+	}
+	if len(removed) > 0 {
+		locals := fn.Locals[:0]
+		for _, l := range fn.Locals {
+			if !removed[l] {
+				locals = append(locals, l)
+			}
+		}
+		fn.Locals = locals
+	}
+	numberRegisters(fn)
+}
+
+// constAssigns returns the locals of fn whose only definition assigns
+// them a constant, and their constants. The definition dominates their
+// uses, since fn is in SSA form or they have only one.
+func constAssigns(fn *Function) map[*Local]Value {
+	n := make(map[*Local]int)
+	for _, p := range fn.Params {
+		n[p]++
+	}
+	consts := make(map[*Local]Value)
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				n[l]++
+				if a, ok := instr.(*Assign); ok && isConst(a.Rhs) && l.Outer == nil && !l.Escapes {
+					consts[l] = a.Rhs
+				}
+			}
+		}
+	}
+	for l := range consts {
+		if n[l] != 1 {
+			delete(consts, l)
+		}
+	}
+	return consts
+}
+
+// replaceConst replaces the local l, assigned the constant c, by c at
+// its uses but for the edges of the φ-nodes of its source variable, and
+// reports whether it replaced any.
+func replaceConst(l *Local, c Value) bool {
+	replaced := false
+	kept := l.referrers[:0]
+	var rands []*Value
+	for _, instr := range l.referrers {
+		if phi, ok := instr.(*Phi); ok && l.Comment != "" && phi.Local.Comment == l.Comment {
+			kept = append(kept, instr)
+			continue
+		}
+		rands = instr.Operands(rands[:0])
+		for _, rand := range rands {
+			if *rand == l {
+				*rand = c
+				replaced = true
+			}
+		}
+	}
+	l.referrers = kept
+	return replaced
+}
+
+// fold returns the constant value computed by instr, or for a φ-node
+// the value of its local, or false if it is not constant.
+func fold(instr Instruction) (Value, bool) {
+	switch instr := instr.(type) {
+	case *Arithmetic:
+		return arith(instr.Op, instr.Lhs, instr.Rhs)
+	case *Relation:
+		return compare(instr.Op, instr.Lhs, instr.Rhs)
+	case *Concat:
+		return concat(instr.Lhs, instr.Rhs)
+	case *Unary:
+		return unary(instr.Op, instr.Value)
+	case *Logic:
+		if !isConst(instr.Lhs) {
+			break
+		}
+		if truthy(instr.Lhs) == (instr.Op == "or") {
+			return instr.Lhs, true
+		}
+		// The value of ... as an operand is its first value, and it
+		// must stay one.
+		if _, ok := instr.Rhs.(VarArg); !ok {
+			return instr.Rhs, true
+		}
+	case *Phi:
+		return foldPhi(instr, nil)
+	}
+	return nil, false
+}
+
+// foldPhi returns the constant all the edges of phi are, either
+// directly or as locals that consts maps to it, or false if there is
+// none.
+func foldPhi(phi *Phi, consts map[*Local]Value) (Value, bool) {
+	var c Value
+	for _, v := range phi.Edges {
+		if l, ok := v.(*Local); ok && consts[l] != nil {
+			v = consts[l]
+		}
+		if !isConst(v) || c != nil && v != c {
+			return nil, false
+		}
+		c = v
+	}
+	return c, c != nil
+}
+
+// removeUses removes instr from the referrers of its operands, before
+// it is deleted.
+func removeUses(instr Instruction) {
+	for _, rand := range instr.Operands(nil) {
+		if *rand == nil {
+			continue
+		}
+		if refs := (*rand).Referrers(); refs != nil {
+			*refs = removeInstr(*refs, instr)
+		}
+	}
+}
+
+// removeNilInstrs eliminates the nils from b.Instrs.
+func removeNilInstrs(b *BasicBlock) {
+	j := 0
+	for _, instr := range b.Instrs {
+		if instr != nil {
+			b.Instrs[j] = instr
+			j++
+		}
+	}
+	for i := j; i < len(b.Instrs); i++ {
+		b.Instrs[i] = nil
+	}
+	b.Instrs = b.Instrs[:j]
+}
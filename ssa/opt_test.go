@@ -0,0 +1,48 @@
+package ssa
+
+import (
+	"testing"
+)
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			"local a = 0.25 + 1.5 * 3\nprint(a .. 'x', '7' // '-2', '10' + '1', 2 ^ 3)",
+			"local a = 4.75;\nprint(\"4.75x\", -4, 11, 8.0);\n",
+		},
+		{
+			"local x = 10\nif c then x = 10 end\nprint(x / 4)",
+			"local x = 10;\nif c then\n\tx = 10;\nend;\nprint(2.5);\n",
+		},
+		{
+			// The versions of x keep their locals in the φ-node.
+			"local x = 1\nif c then x = 2 end\nreturn x",
+			"local x = 1;\nif c then\n\tx = 2;\nend;\nreturn x;\n",
+		},
+		{
+			"local k = 'k' .. 'v'\nlocal function f() return k end\nreturn k, 1 == 1 and f()",
+			"local k = \"kv\";\nlocal function f()\n\treturn k;\nend;\nreturn k, (f());\n",
+		},
+		{
+			"return 0.5 - 5, -(-2) ^ 2, 5 // 0",
+			"return -4.5, -4.0, 5 // 0;\n",
+		},
+		{
+			"return 1 + 2, 7 // 2, 'a' .. 1, 1 < 2, 1 / 2",
+			"return 3, 3, \"a1\", true, 0.5;\n",
+		},
+		{
+			// Floats stay floats, even when they are integral.
+			"return 1.0 + 2, 7 // 2.0, 'a' .. 1.0, 3 / 1, 1e2 | 1",
+			"return 3.0, 3.0, \"a1.0\", 3.0, 101;\n",
+		},
+	}
+	for _, test := range tests {
+		if got := runPasses(test.input, t, FoldConstants); got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package ssa
+
+// This file defines the out-of-SSA translation, which replaces the
+// φ-nodes of a function with copies so that it can be decompiled.
+//
+// The algorithm is the one of Boissinot et al, Revisiting Out-of-SSA
+// Translation for Correctness, Code Quality, and Efficiency, CGO 2009,
+// without its fast liveness checks. The critical edges entering blocks
+// with φ-nodes are split first, so that the copies of an edge can go at
+// the end of its predecessor. The locals related by a copy or a φ-node
+// are then coalesced into one variable when they do not interfere: when
+// neither is live at the definition of the other, or they hold the same
+// value. The versions of a source variable are coalesced first, and
+// locals named after different source variables never are. Last, the
+// copies of each edge, which happen in parallel, are sequentialized,
+// with a temporary to break each cycle of copies such as a swap; the
+// constants are copied directly, after the variables.
+//
+// Afterwards a variable may have several definitions, and each one is
+// a *Local of its own named after the Comment of its locals. The loop
+// variables of NumberFor and GenericFor, and the locals shared with
+// closures, are left alone.
+
+// outOfSSA holds the state of the translation of one function.
+type outOfSSA struct {
+	fn      *Function
+	defs    map[*Local]Instruction // the definition of each SSA local; nil for parameters
+	pos     map[*Local]int         // its index in its block: -2 for parameters, -1 for φ-nodes
+	liveIn  []map[*Local]bool      // by Block.Index
+	liveOut []map[*Local]bool
+	value   map[*Local]Value    // the value each local is a copy of
+	class   map[*Local]*Local   // the union-find forest of the coalesced locals
+	members map[*Local][]*Local // the members of each class, by root
+}
+
+// OutOfSSA replaces the φ-nodes of fn and of the functions nested in it
+// with copies, coalescing the locals they relate where possible.
+func OutOfSSA(fn *Function) {
+	outOfSSAFunction(fn)
+	for _, nested := range fn.Functions {
+		OutOfSSA(nested)
+	}
+}
+
+func outOfSSAFunction(fn *Function) {
+	hasPhi := false
+	for _, b := range fn.Blocks {
+		hasPhi = hasPhi || b.hasPhi()
+	}
+	o := &outOfSSA{
+		fn:      fn,
+		defs:    make(map[*Local]Instruction),
+		pos:     make(map[*Local]int),
+		value:   make(map[*Local]Value),
+		class:   make(map[*Local]*Local),
+		members: make(map[*Local][]*Local),
+	}
+	if hasPhi {
+		splitCriticalEdges(fn)
+	}
+	buildDomTree(fn)
+	o.findDefs()
+	o.buildLiveness()
+	o.coalesce()
+	o.rename()
+	if hasPhi {
+		o.insertCopies()
+	}
+	o.removeSelfCopies()
+	o.renumber()
+}
+
+// splitCriticalEdges inserts a block on each edge from a block with
+// several successors to a block with several predecessors and φ-nodes.
+func splitCriticalEdges(fn *Function) {
+	for _, b := range fn.Blocks {
+		if !b.hasPhi() || len(b.Preds) < 2 {
+			continue
+		}
+		for i, p := range b.Preds {
+			if len(p.Succs) < 2 {
+				continue
+			}
+			c := fn.NewBasicBlock("split")
+			c.emit(new(Jump))
+			c.Preds = append(c.Preds, p)
+			c.Succs = append(c.Succs, b)
+			b.Preds[i] = c
+			for j, s := range p.Succs {
+				if s == b {
+					p.Succs[j] = c
+					break
+				}
+			}
+		}
+	}
+}
+
+// isSSA reports whether l is a local the translation may coalesce.
+func (o *outOfSSA) isSSA(l *Local) bool {
+	_, ok := o.pos[l]
+	return ok
+}
+
+// findDefs finds the definitions of the locals with exactly one.
+func (o *outOfSSA) findDefs() {
+	fn := o.fn
+	n := make(map[*Local]int)
+	for _, p := range fn.Params {
+		n[p]++
+		o.pos[p] = -2
+	}
+	for _, b := range fn.Blocks {
+		for i, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				n[l]++
+				o.defs[l] = instr
+				o.pos[l] = i
+				switch instr.(type) {
+				case *Phi:
+					o.pos[l] = -1
+				case *NumberFor, *GenericFor:
+					n[l]++ // a loop variable is never coalesced
+				}
+			}
+		}
+	}
+	for l, n := range n {
+		if n != 1 || l.Outer != nil || l.Escapes {
+			delete(o.pos, l)
+			delete(o.defs, l)
+		}
+	}
+	// A copy holds the value of its source.
+	var value func(l *Local) Value
+	value = func(l *Local) Value {
+		if v, ok := o.value[l]; ok {
+			return v
+		}
+		o.value[l] = l
+		if a, ok := o.defs[l].(*Assign); ok {
+			if src, ok := a.Rhs.(*Local); ok && o.isSSA(src) {
+				o.value[l] = value(src)
+			}
+		}
+		return o.value[l]
+	}
+	for l := range o.pos {
+		value(l)
+	}
+}
+
+// defBlock returns the block defining the SSA local l.
+func (o *outOfSSA) defBlock(l *Local) *BasicBlock {
+	if def := o.defs[l]; def != nil {
+		return def.Block()
+	}
+	return o.fn.Blocks[0]
+}
+
+// buildLiveness computes the SSA locals live at the start and the end of
+// each block. The operands of a φ-node are used at the end of the
+// corresponding predecessors.
+func (o *outOfSSA) buildLiveness() {
+	fn := o.fn
+	n := len(fn.Blocks)
+	use := make([]map[*Local]bool, n) // used before any definition in the block
+	def := make([]map[*Local]bool, n)
+	phiUse := make([]map[*Local]bool, n) // used by the φ-nodes of successors
+	o.liveIn = make([]map[*Local]bool, n)
+	o.liveOut = make([]map[*Local]bool, n)
+	for i := range fn.Blocks {
+		use[i], def[i], phiUse[i] = make(map[*Local]bool), make(map[*Local]bool), make(map[*Local]bool)
+		o.liveIn[i], o.liveOut[i] = make(map[*Local]bool), make(map[*Local]bool)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok {
+				for i, v := range phi.Edges {
+					if l, ok := v.(*Local); ok && o.isSSA(l) {
+						phiUse[b.Preds[i].Index][l] = true
+					}
+				}
+			} else {
+				for _, v := range operands(instr) {
+					if l, ok := v.(*Local); ok && o.isSSA(l) && !def[b.Index][l] {
+						use[b.Index][l] = true
+					}
+				}
+			}
+			for _, l := range defs(instr) {
+				def[b.Index][l] = true
+			}
+		}
+	}
+	for _, p := range fn.Params {
+		def[0][p] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			b := fn.Blocks[i]
+			out, in := o.liveOut[i], o.liveIn[i]
+			for l := range phiUse[i] {
+				out[l] = true
+			}
+			for _, s := range b.Succs {
+				for l := range o.liveIn[s.Index] {
+					out[l] = true
+				}
+			}
+			for l := range use[i] {
+				if !in[l] {
+					in[l], changed = true, true
+				}
+			}
+			for l := range out {
+				if !def[i][l] && !in[l] {
+					in[l], changed = true, true
+				}
+			}
+		}
+	}
+}
+
+// liveAfter reports whether a is live right after the definition of b.
+func (o *outOfSSA) liveAfter(a, b *Local) bool {
+	block := o.defBlock(b)
+	if o.defBlock(a) == block && o.pos[a] >= o.pos[b] {
+		// Defined later, or at once as parameters or φ-nodes.
+		return a != b && o.pos[a] == o.pos[b] && o.pos[a] < 0
+	}
+	if o.liveOut[block.Index][a] {
+		return true
+	}
+	start := o.pos[b] + 1
+	if start < 0 {
+		start = 0
+	}
+	for _, instr := range block.Instrs[start:] {
+		if _, ok := instr.(*Phi); ok {
+			continue
+		}
+		for _, v := range operands(instr) {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// interfere reports whether the SSA locals a and b cannot share a
+// variable.
+func (o *outOfSSA) interfere(a, b *Local) bool {
+	if o.value[a] == o.value[b] {
+		return false
+	}
+	return o.liveAfter(a, b) || o.liveAfter(b, a)
+}
+
+func (o *outOfSSA) find(l *Local) *Local {
+	root, ok := o.class[l]
+	if !ok || root == l {
+		return l
+	}
+	root = o.find(root)
+	o.class[l] = root
+	return root
+}
+
+// union coalesces the classes of a and b if they do not interfere and
+// are not named after different variables, and reports whether it did.
+func (o *outOfSSA) union(a, b *Local) bool {
+	ra, rb := o.find(a), o.find(b)
+	if ra == rb {
+		return false
+	}
+	ma, mb := o.membersOf(ra), o.membersOf(rb)
+	if name, other := comment(ma), comment(mb); name != "" && other != "" && name != other {
+		return false
+	}
+	for _, x := range ma {
+		for _, y := range mb {
+			if o.interfere(x, y) {
+				return false
+			}
+		}
+	}
+	o.class[rb] = ra
+	o.members[ra] = append(ma, mb...)
+	delete(o.members, rb)
+	return true
+}
+
+func (o *outOfSSA) membersOf(root *Local) []*Local {
+	if m, ok := o.members[root]; ok {
+		return m
+	}
+	return []*Local{root}
+}
+
+// comment returns the name of the source variable of locals, if any.
+func comment(locals []*Local) string {
+	for _, l := range locals {
+		if l.Comment != "" {
+			return l.Comment
+		}
+	}
+	return ""
+}
+
+// coalesce coalesces the locals related by copies and φ-nodes, the
+// versions of a source variable first.
+func (o *outOfSSA) coalesce() {
+	type affinity struct{ a, b *Local }
+	var same, other []affinity
+	add := func(a *Local, v Value) {
+		if b, ok := v.(*Local); ok && o.isSSA(a) && o.isSSA(b) {
+			if a.Comment != "" && a.Comment == b.Comment {
+				same = append(same, affinity{a, b})
+			} else {
+				other = append(other, affinity{a, b})
+			}
+		}
+	}
+	for _, b := range o.fn.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *Phi:
+				for _, v := range instr.Edges {
+					add(instr.Local, v)
+				}
+			case *Assign:
+				if l, ok := instr.Lhs.(*Local); ok {
+					add(l, instr.Rhs)
+				}
+			}
+		}
+	}
+	for _, a := range same {
+		o.union(a.a, a.b)
+	}
+	for _, a := range other {
+		o.union(a.a, a.b)
+	}
+	// Versions of a variable not related by a copy may share it too.
+	byName := make(map[string][]*Local)
+	for _, l := range o.fn.Locals {
+		if !o.isSSA(l) || l.Comment == "" {
+			continue
+		}
+		for _, other := range byName[l.Comment] {
+			o.union(other, l)
+		}
+		byName[l.Comment] = append(byName[l.Comment], l)
+	}
+}
+
+// rep returns the variable replacing the SSA local l: a parameter of its
+// class, or else its first local named after a source variable.
+func (o *outOfSSA) rep(l *Local) *Local {
+	members := o.membersOf(o.find(l))
+	var rep *Local
+	for _, m := range members {
+		switch {
+		case o.pos[m] == -2:
+			return m
+		case rep == nil, rep.Comment == "" && m.Comment != "":
+			rep = m
+		}
+	}
+	return rep
+}
+
+// rename replaces the coalesced locals by their variable.
+func (o *outOfSSA) rename() {
+	reps := make(map[*Local]*Local)
+	for l := range o.pos {
+		rep := o.rep(l)
+		reps[l] = rep
+		rep.orig = nil
+	}
+	subst := func(v *Value) {
+		if l, ok := (*v).(*Local); ok && reps[l] != nil {
+			*v = reps[l]
+		}
+	}
+	for _, b := range o.fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, rand := range instr.Operands(nil) {
+				subst(rand)
+			}
+			switch instr := instr.(type) {
+			case *Phi:
+				for i := range instr.Edges {
+					subst(&instr.Edges[i])
+				}
+				instr.Local = reps[instr.Local]
+			case *Assign:
+				subst(&instr.Lhs)
+			}
+		}
+	}
+}
+
+// insertCopies replaces the φ-nodes by copies at the end of the
+// predecessors of their blocks.
+func (o *outOfSSA) insertCopies() {
+	for _, b := range o.fn.Blocks {
+		phis := b.phis()
+		if len(phis) == 0 {
+			continue
+		}
+		for i, p := range b.Preds {
+			var dsts []*Local
+			var srcs []Value
+			for _, instr := range phis {
+				phi := instr.(*Phi)
+				if phi.Edges[i] != phi.Local {
+					dsts = append(dsts, phi.Local)
+					srcs = append(srcs, phi.Edges[i])
+				}
+			}
+			term := p.Instrs[len(p.Instrs)-1]
+			p.Instrs = p.Instrs[:len(p.Instrs)-1]
+			for _, c := range o.sequentialize(dsts, srcs) {
+				p.emit(c)
+			}
+			p.emit(term)
+		}
+		b.Instrs = b.Instrs[len(phis):]
+	}
+}
+
+// sequentialize returns the copies performing the parallel copies of
+// srcs to the distinct variables dsts, one at a time. The values that
+// are not variables, which no copy overwrites, are copied last.
+func (o *outOfSSA) sequentialize(dsts []*Local, srcs []Value) []*Assign {
+	var copies, consts []*Assign
+	loc := make(map[*Local]*Local)  // where the initial value of a variable is now
+	pred := make(map[*Local]*Local) // the source of the copy to a variable
+	done := make(map[*Local]bool)   // the variables copied to
+	var ready, todo []*Local
+	for i, b := range dsts {
+		a, ok := srcs[i].(*Local)
+		if !ok {
+			consts = append(consts, &Assign{Lhs: b, Rhs: srcs[i]})
+			continue
+		}
+		loc[a] = a
+		pred[b] = a
+		todo = append(todo, b)
+	}
+	for _, b := range todo {
+		if loc[b] == nil {
+			ready = append(ready, b) // b is not needed as a source
+		}
+	}
+	for len(todo) > 0 {
+		for len(ready) > 0 {
+			b := ready[len(ready)-1]
+			ready = ready[:len(ready)-1]
+			a := pred[b]
+			c := loc[a]
+			copies = append(copies, &Assign{Lhs: b, Rhs: c})
+			done[b] = true
+			loc[a] = b
+			if a == c && pred[a] != nil {
+				ready = append(ready, a)
+			}
+		}
+		b := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if !done[b] {
+			// b is in a cycle of copies: save its value.
+			tmp := o.fn.newLocal("")
+			copies = append(copies, &Assign{Lhs: tmp, Rhs: b})
+			loc[b] = tmp
+			ready = append(ready, b)
+		}
+	}
+	return append(copies, consts...)
+}
+
+// removeSelfCopies deletes the copies of a variable to itself.
+func (o *outOfSSA) removeSelfCopies() {
+	for _, b := range o.fn.Blocks {
+		j := 0
+		for _, instr := range b.Instrs {
+			if a, ok := instr.(*Assign); ok && a.Lhs == a.Rhs {
+				continue
+			}
+			b.Instrs[j] = instr
+			j++
+		}
+		for i := j; i < len(b.Instrs); i++ {
+			b.Instrs[i] = nil
+		}
+		b.Instrs = b.Instrs[:j]
+	}
+}
+
+// renumber rebuilds the locals and the referrers of the function.
+func (o *outOfSSA) renumber() {
+	fn := o.fn
+	seen := make(map[*Local]bool)
+	locals := fn.Locals[:0]
+	add := func(v Value) {
+		if l, ok := v.(*Local); ok && l.parent == fn && l.Outer == nil && !seen[l] {
+			seen[l] = true
+			locals = append(locals, l)
+		}
+	}
+	for _, p := range fn.Params {
+		add(p)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				add(l)
+			}
+			for _, v := range operands(instr) {
+				add(v)
+			}
+		}
+	}
+	fn.Locals = locals
+
+	for _, l := range fn.Locals {
+		l.referrers = nil
+	}
+	for _, l := range fn.UpValues {
+		l.referrers = nil
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if v, ok := instr.(Value); ok && v.Referrers() != nil {
+				*v.Referrers() = nil
+			}
+		}
+	}
+	buildReferrers(fn)
+	numberRegisters(fn)
+}
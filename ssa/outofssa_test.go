@@ -0,0 +1,100 @@
+package ssa
+
+import (
+	"testing"
+)
+
+func TestOutOfSSA(t *testing.T) {
+	tests := []struct {
+		input string
+		fold  bool // run FoldConstants and SCCP first
+		want  string
+	}{
+		{
+			"local a, b = 1, 2\nwhile a < 10 do a, b = b, a + b end\nreturn a, b", false,
+			"local t4;\nlocal a = 1;\nlocal b = 2;\nwhile a < 10 do\n\tt4 = a + b;\n\ta = b;\n\tb = t4;\nend;\nreturn a, b;\n",
+		},
+		{
+			"local a, b = f()\nif c then a, b = b, a end\nreturn a, b", false,
+			"local t8;\nlocal a, b = f();\nif c then\n\tt8 = a;\n\ta = b;\n\tb = t8;\nend;\nreturn a, b;\n",
+		},
+		{
+			"local x = 1\nif c then x = 2 else x = 3 end\nlocal y = x\ny = y + 1\nreturn y", false,
+			"local x = 1;\nif c then\n\tx = 2;\nelse\n\tx = 3;\nend;\nlocal y = x;\ny = y + 1;\nreturn y;\n",
+		},
+		{
+			"local function f(n) local r = 1 while n > 1 do r = r * n n = n - 1 end return r end return f(5)", false,
+			"local function f(n)\n\tlocal r = 1;\n\twhile n > 1 do\n\t\tr = r * n;\n\t\tn = n - 1;\n\tend;\n\treturn r;\nend;\nreturn f(5);\n",
+		},
+		{
+			// The variables hold the folded constants already.
+			"local x = 1\nif c then x = 2 end\nreturn x", true,
+			"local x = 1;\nif c then\n\tx = 2;\nend;\nreturn x;\n",
+		},
+		{
+			"local x = 0\nlocal y = 0\nwhile c() do y = x x = x + 1 end\nprint(y, x)", true,
+			"local x = 0;\nlocal y = 0;\nwhile c() do\n\ty = x;\n\tx = x + 1;\nend;\nprint(y, x);\n",
+		},
+		{
+			"local a, b = 1, 2\nwhile c() do a, b = b, a end\nprint(a, b)", true,
+			"local t5;\nlocal a = 1;\nlocal b = 2;\nwhile c() do\n\tt5 = a;\n\ta = b;\n\tb = t5;\nend;\nprint(a, b);\n",
+		},
+	}
+	for _, test := range tests {
+		var passes []func(*Function)
+		if test.fold {
+			passes = append(passes, FoldConstants, SCCP)
+		}
+		outOfSSA := func(fn *Function) {
+			OutOfSSA(fn)
+			if n := len(phis(fn)); n > 0 {
+				t.Errorf("%q: %d φ-nodes left in\n%s", test.input, n, fn)
+			}
+		}
+		if got := runPasses(test.input, t, append(passes, outOfSSA)...); got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestSequentialize(t *testing.T) {
+	fn := &Function{}
+	a, b, c, d := fn.newLocal("a"), fn.newLocal("b"), fn.newLocal("c"), fn.newLocal("d")
+	tests := []struct {
+		dsts []*Local
+		srcs []Value
+		n    int // copies, with one more for each temporary
+	}{
+		{[]*Local{a, b}, []Value{b, a}, 3},
+		{[]*Local{a, b, c}, []Value{b, c, a}, 4},
+		{[]*Local{a, b, c, d}, []Value{b, a, a, c}, 4},
+		{[]*Local{b, c}, []Value{a, b}, 2},
+		{[]*Local{a, b}, []Value{Number{Value: 1}, a}, 2},
+		{[]*Local{a, b}, []Value{Number{Value: 0}, Number{Value: 0}}, 2},
+		{[]*Local{a, b, c}, []Value{c, c, a}, 3},
+		{[]*Local{a, b, c}, []Value{b, Number{Value: 0}, b}, 3},
+	}
+	for _, test := range tests {
+		o := &outOfSSA{fn: fn}
+		copies := o.sequentialize(test.dsts, test.srcs)
+		if len(copies) != test.n {
+			t.Errorf("%v := %v: %d copies %v, want %d", test.dsts, test.srcs, len(copies), copies, test.n)
+		}
+		// Run the copies on distinct initial values.
+		env := make(map[Value]Value)
+		get := func(v Value) Value {
+			if x, ok := env[v]; ok {
+				return x
+			}
+			return v
+		}
+		for _, c := range copies {
+			env[c.Lhs] = get(c.Rhs)
+		}
+		for i, dst := range test.dsts {
+			if got := get(dst); got != test.srcs[i] {
+				t.Errorf("%v := %v: %v holds %v after %v", test.dsts, test.srcs, dst, got, copies)
+			}
+		}
+	}
+}
@@ -12,14 +12,39 @@ func (s False) String() string { return "false" }
 func (s VarArg) String() string { return "..." }
 
 func (s Number) String() string {
-	return strconv.FormatFloat(s.Value, 'f', -1, 64)
+	str := strconv.FormatFloat(s.Value, 'f', -1, 64)
+	if s.Float && strings.Trim(str, "-0123456789") == "" {
+		str += ".0"
+	}
+	return str
 }
 
 func (s String) String() string {
 	return strconv.Quote(s.Value)
 }
 
-func (s Table) String() string {
+// relName returns the name of v as an operand: the register holding it,
+// or its code for constants and globals.
+func relName(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "<nil>"
+	case interface{ Name() string }:
+		return v.Name()
+	case *Function:
+		if v.Name != "" {
+			return v.Name
+		}
+		return fmt.Sprintf("func:%d", v.num)
+	}
+	return v.String()
+}
+
+func (s *Extract) String() string {
+	return fmt.Sprintf("extract %s #%d", relName(s.Tuple), s.Index)
+}
+
+func (s *NewTable) String() string {
 	b := &strings.Builder{}
 	b.WriteRune('{')
 	for i, field := range s.Fields {
@@ -27,54 +52,58 @@ func (s Table) String() string {
 			b.WriteString(", ")
 		}
 		if field.Key != nil {
-			fmt.Fprintf(b, "[%s] = ", field.Key.String())
+			fmt.Fprintf(b, "[%s] = ", relName(field.Key))
+		}
+		b.WriteString(relName(field.Value))
+	}
+	if s.Spread {
+		if _, ok := s.Fields[len(s.Fields)-1].Value.(VarArg); !ok {
+			b.WriteString("...")
 		}
-		b.WriteString(field.Value.String())
 	}
 	b.WriteRune('}')
 	return b.String()
 }
 
-func (s AttrGet) String() string {
-	return fmt.Sprintf("%s[%s]", s.Object, s.Key)
+func (s *Index) String() string {
+	return fmt.Sprintf("%s[%s]", relName(s.Object), relName(s.Key))
 }
 
-func (s Arithmetic) String() string {
-	return fmt.Sprintf("%s %s %s", s.Lhs, s.Op, s.Rhs)
+func (s *Arithmetic) String() string {
+	return fmt.Sprintf("%s %s %s", relName(s.Lhs), s.Op, relName(s.Rhs))
 }
 
-func (s Unary) String() string {
-	return fmt.Sprintf("%s%s", s.Op, s.Value)
+func (s *Unary) String() string {
+	return fmt.Sprintf("%s%s", s.Op, relName(s.Value))
 }
 
-func (s Concat) String() string {
-	return fmt.Sprintf("%s .. %s", s.Lhs, s.Rhs)
+func (s *Concat) String() string {
+	return fmt.Sprintf("%s .. %s", relName(s.Lhs), relName(s.Rhs))
 }
 
-func (s Relation) String() string {
-	return fmt.Sprintf("%s %s %s", s.Lhs, s.Op, s.Rhs)
+func (s *Relation) String() string {
+	return fmt.Sprintf("%s %s %s", relName(s.Lhs), s.Op, relName(s.Rhs))
 }
 
-func (s Logic) String() string {
-	return fmt.Sprintf("%s %s %s", s.Lhs, s.Op, s.Rhs)
+func (s *Logic) String() string {
+	return fmt.Sprintf("%s %s %s", relName(s.Lhs), s.Op, relName(s.Rhs))
 }
 
 func (s *Local) String() string {
 	return s.Name()
 }
 
-func (v *Global) String() string { 
+func (v *Global) String() string {
 	return v.Comment
 }
 
-
-func (s Call) String() string {
+func (s *Call) String() string {
 	b := &strings.Builder{}
 
 	if s.Func != nil { // func()
-		b.WriteString(s.Func.String())
+		b.WriteString(relName(s.Func))
 	} else { // hoge:method()
-		b.WriteString(s.Recv.String())
+		b.WriteString(relName(s.Recv))
 		b.WriteRune(':')
 		b.WriteString(s.Method)
 	}
@@ -84,14 +113,36 @@ func (s Call) String() string {
 		if i != 0 {
 			b.WriteString(", ")
 		}
-		b.WriteString(arg.String())
+		b.WriteString(relName(arg))
+	}
+	if s.Spread {
+		if _, ok := s.Args[len(s.Args)-1].(VarArg); !ok {
+			b.WriteString("...")
+		}
 	}
 	b.WriteRune(')')
 
 	return b.String()
 }
 
-func (s *Return) String() string { return "" }
+func (s *Return) String() string {
+	b := &strings.Builder{}
+	b.WriteString("return")
+	for i, v := range s.Results {
+		if i == 0 {
+			b.WriteRune(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(relName(v))
+	}
+	if s.Spread {
+		if _, ok := s.Results[len(s.Results)-1].(VarArg); !ok {
+			b.WriteString("...")
+		}
+	}
+	return b.String()
+}
 
 func (f *Function) String() string {
 	b := &strings.Builder{}
@@ -115,35 +166,47 @@ func (s *If) String() string {
 		tblock = s.block.Succs[0].Index
 		fblock = s.block.Succs[1].Index
 	}
-	return fmt.Sprintf("if %s goto %d else %d", s.Cond, tblock, fblock)
+	return fmt.Sprintf("if %s goto %d else %d", relName(s.Cond), tblock, fblock)
 }
 
 func (v *Assign) String() string {
-	return fmt.Sprintf("%s = %s", v.Lhs, v.Rhs)
+	return fmt.Sprintf("%s = %s", relName(v.Lhs), relName(v.Rhs))
 }
 
-func (v *CompoundAssign) String() string {
-	return fmt.Sprintf("%s %s= %s", v.Lhs, v.Op, v.Rhs)
+func (v *Store) String() string {
+	return fmt.Sprintf("%s[%s] = %s", relName(v.Object), relName(v.Key), relName(v.Value))
 }
 
 func (v *NumberFor) String() string {
-	return fmt.Sprintf("for %s = %s, %s, %d do", v.Local, v.Init, v.Limit, v.Step)
+	return fmt.Sprintf("for %s = %s, %s, %s do", relName(v.Local), relName(v.Init), relName(v.Limit), relName(v.Step))
 }
 
 func (v *GenericFor) String() string {
 	var b strings.Builder
 	b.WriteString("for ")
-	b.WriteString(fmt.Sprintf("%s", v.Locals[0]))
-	for i := 1; i < len(v.Locals); i++ {
-		b.WriteString(fmt.Sprintf("%s,", v.Locals[0]))
+	for i, local := range v.Locals {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(relName(local))
 	}
 	b.WriteString(" in ")
+	for i, value := range v.Values {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(relName(value))
+	}
+	b.WriteString(" do")
 	return b.String()
 }
 
 func (v *Phi) String() string {
 	var b strings.Builder
-	/*b.WriteString("phi [")
+	if v.Local != nil {
+		fmt.Fprintf(&b, "%s = ", v.Local)
+	}
+	b.WriteString("phi [")
 	for i, edge := range v.Edges {
 		if i > 0 {
 			b.WriteString(", ")
@@ -159,16 +222,16 @@ func (v *Phi) String() string {
 		}
 		fmt.Fprintf(&b, "%d: ", block)
 		edgeVal := "<nil>" // be robust
-		//if edge != nil {
-			//edgeVal = relName(edge, v)
-		//}
+		if edge != nil {
+			edgeVal = relName(edge)
+		}
 		b.WriteString(edgeVal)
 	}
 	b.WriteString("]")
 	if v.Comment != "" {
 		b.WriteString(" #")
 		b.WriteString(v.Comment)
-	}*/
+	}
 	return b.String()
 }
 
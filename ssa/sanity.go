@@ -0,0 +1,338 @@
+package ssa
+
+// An optional pass for sanity-checking invariants of the SSA
+// representation: the shape of the CFG, the placement of φ-nodes and
+// terminators, and the definitions and uses of values.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type sanity struct {
+	fn     *Function
+	block  *BasicBlock
+	errors []string
+	defs   map[*Local][]Instruction // the definitions of the locals of fn
+}
+
+// SanityCheck checks the invariants of the SSA code of fn and of the
+// functions nested in it. The error lists every violation found.
+func SanityCheck(fn *Function) error {
+	var errs []string
+	var check func(fn *Function)
+	check = func(fn *Function) {
+		errs = append(errs, sanityCheck(fn)...)
+		for _, nested := range fn.Functions {
+			check(nested)
+		}
+	}
+	check(fn)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "\n"))
+}
+
+// mustSanityCheck is like SanityCheck for fn alone, but panics on
+// failure: the builder made invalid code.
+func mustSanityCheck(fn *Function) {
+	if errs := sanityCheck(fn); len(errs) > 0 {
+		panic(fmt.Sprintf("ssa: sanity check failed for %s:\n%s\n%s", fn.Name, strings.Join(errs, "\n"), fn))
+	}
+}
+
+// sanityCheck returns the violations of the invariants by fn.
+func sanityCheck(fn *Function) []string {
+	s := &sanity{fn: fn, defs: make(map[*Local][]Instruction)}
+	s.checkFunction()
+	return s.errors
+}
+
+func (s *sanity) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if s.block != nil {
+		msg = fmt.Sprintf("%s, block %s: %s", s.fn.Name, s.block, msg)
+	} else {
+		msg = fmt.Sprintf("%s: %s", s.fn.Name, msg)
+	}
+	s.errors = append(s.errors, msg)
+}
+
+func (s *sanity) checkFunction() {
+	fn := s.fn
+	if len(fn.Blocks) == 0 {
+		s.errorf("function has no blocks")
+		return
+	}
+	for i, b := range fn.Blocks {
+		if b == nil {
+			s.errorf("nil block at index %d", i)
+			return
+		}
+		if b.Index != i {
+			s.errorf("block %s has Index %d, but is at index %d", b, b.Index, i)
+		}
+		if b.parent != fn {
+			s.errorf("block %s belongs to %s", b, b.parent.Name)
+		}
+	}
+	if fn.Exit != nil && (fn.Exit.Index >= len(fn.Blocks) || fn.Blocks[fn.Exit.Index] != fn.Exit) {
+		s.errorf("exit block %s is not in Blocks", fn.Exit)
+	}
+	for _, nested := range fn.Functions {
+		if nested.parent != fn {
+			s.errorf("nested function %s has parent %v", nested.Name, nested.parent)
+		}
+	}
+
+	for _, b := range fn.Blocks {
+		s.block = b
+		s.checkBlock(b)
+	}
+	s.block = nil
+	if len(s.errors) > 0 {
+		return // the CFG is malformed: dominance is meaningless
+	}
+
+	for _, p := range fn.Params {
+		s.defs[p] = append(s.defs[p], nil)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, local := range defs(instr) {
+				s.defs[local] = append(s.defs[local], instr)
+			}
+		}
+	}
+	buildDomTree(fn)
+	var rands []*Value
+	for _, b := range fn.Blocks {
+		s.block = b
+		for _, instr := range b.Instrs {
+			for i, rand := range instr.Operands(rands[:0]) {
+				s.checkOperand(instr, i, *rand)
+			}
+		}
+	}
+	s.block = nil
+	s.checkReferrers()
+}
+
+func (s *sanity) checkBlock(b *BasicBlock) {
+	if b.Index == 0 && len(b.Preds) > 0 {
+		s.errorf("entry block has predecessors %s", b.Preds)
+	}
+	if b.Index > 0 && len(b.Preds) == 0 {
+		s.errorf("unreachable block")
+	}
+	for _, p := range b.Preds {
+		if p.parent != s.fn {
+			s.errorf("predecessor %s belongs to %s", p, p.parent.Name)
+		}
+		if count(p.Succs, b) != count(b.Preds, p) {
+			s.errorf("predecessor %s does not have this block as successor", p)
+		}
+	}
+	for _, c := range b.Succs {
+		if c.parent != s.fn {
+			s.errorf("successor %s belongs to %s", c, c.parent.Name)
+		}
+		if count(c.Preds, b) != count(b.Succs, c) {
+			s.errorf("successor %s does not have this block as predecessor", c)
+		}
+	}
+
+	if b == s.fn.Exit {
+		if len(b.Instrs) > 0 || len(b.Succs) > 0 {
+			s.errorf("exit block has instructions or successors")
+		}
+		return
+	}
+	if len(b.Instrs) == 0 {
+		s.errorf("block has no terminator")
+		return
+	}
+	phis := true
+	for i, instr := range b.Instrs {
+		if instr == nil {
+			s.errorf("nil instruction at index %d", i)
+			continue
+		}
+		if instr.Block() != b {
+			s.errorf("instruction %s is in block %s", instr, instr.Block())
+		}
+		phi, ok := instr.(*Phi)
+		switch {
+		case ok && !phis:
+			s.errorf("φ-node %s follows a non-φ instruction", phi)
+		case ok && len(phi.Edges) != len(b.Preds):
+			s.errorf("φ-node %s has %d edges, but the block has %d predecessors", phi, len(phi.Edges), len(b.Preds))
+		case !ok:
+			phis = false
+		}
+		if isTerminator(instr) != (i == len(b.Instrs)-1) {
+			if i == len(b.Instrs)-1 {
+				s.errorf("block does not end in a terminator, but %s", instr)
+			} else {
+				s.errorf("terminator %s is followed by %s", instr, b.Instrs[i+1])
+			}
+		}
+	}
+	s.checkFinalInstr(b.Instrs[len(b.Instrs)-1])
+}
+
+// isTerminator reports whether instr ends its block.
+func isTerminator(instr Instruction) bool {
+	switch instr.(type) {
+	case *Jump, *If, *Return, *NumberFor, *GenericFor:
+		return true
+	}
+	return false
+}
+
+func (s *sanity) checkFinalInstr(instr Instruction) {
+	b := s.block
+	switch instr.(type) {
+	case *Jump:
+		if len(b.Succs) != 1 {
+			s.errorf("jump has %d successors, want 1", len(b.Succs))
+		}
+	case *If, *NumberFor, *GenericFor:
+		if len(b.Succs) != 2 {
+			s.errorf("%s has %d successors, want 2", instr, len(b.Succs))
+		}
+	case *Return:
+		if len(b.Succs) != 1 || b.Succs[0] != s.fn.Exit {
+			s.errorf("return does not have the exit block as only successor")
+		}
+	}
+}
+
+// checkOperand checks that the ith operand v of instr is a value of
+// the function defined before instr.
+func (s *sanity) checkOperand(instr Instruction, i int, v Value) {
+	switch v := v.(type) {
+	case nil, Nil, True, False, Number, String, VarArg, *Global:
+		// Call.Func or Recv, and the keys of positional fields, are nil.
+	case *Function:
+		if v.parent != s.fn {
+			s.errorf("%s uses function %s of %v", instr, v.Name, v.parent)
+		}
+	case *Local:
+		if v.parent != s.fn {
+			s.errorf("%s uses %s of another function", instr, v)
+			return
+		}
+		if v.Outer != nil || v.Escapes {
+			return // shared with closures: assigned anywhere
+		}
+		switch defs := s.defs[v]; {
+		case len(defs) == 0:
+			s.errorf("%s uses %s, which is never defined", instr, v)
+		case len(defs) == 1 && defs[0] != nil:
+			s.checkDominates(instr, i, defs[0], v)
+		}
+	case Instruction:
+		if v.Parent() != s.fn {
+			s.errorf("%s uses %s of another function", instr, v)
+			return
+		}
+		if def := v.Block(); def == nil || def.Index >= len(s.fn.Blocks) || s.fn.Blocks[def.Index] != def {
+			s.errorf("%s uses %s, which is not in a block of the function", instr, v)
+			return
+		}
+		s.checkDominates(instr, i, v, v.(Value))
+	default:
+		s.errorf("%s uses %s of unknown type %T", instr, v, v)
+	}
+}
+
+// checkDominates checks that def, defining v, dominates the ith operand
+// of instr. The edges of a φ-node are used at the end of the
+// predecessors.
+func (s *sanity) checkDominates(instr Instruction, i int, def Instruction, v Value) {
+	use := instr.Block()
+	if _, ok := instr.(*Phi); ok {
+		use = use.Preds[i]
+		if def.Block().Dominates(use) {
+			return
+		}
+	} else if def.Block() == use {
+		if index(use.Instrs, def) < index(use.Instrs, instr) {
+			return
+		}
+	} else if def.Block().Dominates(use) {
+		return
+	}
+	s.errorf("%s uses %s before its definition %s", instr, relName(v), def)
+}
+
+// checkReferrers checks that the referrers of the values of the
+// function are the instructions using them.
+func (s *sanity) checkReferrers() {
+	uses := func(instr Instruction, v Value) bool {
+		for _, rand := range instr.Operands(nil) {
+			if *rand == v {
+				return true
+			}
+		}
+		return false
+	}
+	check := func(v Value) {
+		for _, ref := range *v.Referrers() {
+			if ref.Block() == nil || ref.Parent() != s.fn {
+				s.errorf("%s has referrer %s outside the function", relName(v), ref)
+			} else if !uses(ref, v) {
+				s.errorf("%s has referrer %s, which does not use it", relName(v), ref)
+			}
+		}
+	}
+	for _, l := range s.fn.Params {
+		check(l)
+	}
+	for _, l := range s.fn.Locals {
+		check(l)
+	}
+	for _, l := range s.fn.UpValues {
+		check(l)
+	}
+	for _, b := range s.fn.Blocks {
+		for _, instr := range b.Instrs {
+			if v, ok := instr.(Value); ok && v.Referrers() != nil {
+				check(v)
+			}
+			for _, rand := range instr.Operands(nil) {
+				v := *rand
+				if v == nil || v.Referrers() == nil || v.Parent() != s.fn {
+					continue
+				}
+				if index(*v.Referrers(), instr) < 0 {
+					s.errorf("%s uses %s, but is not among its referrers", instr, relName(v))
+				}
+			}
+		}
+	}
+}
+
+// count returns the number of occurrences of b in blocks.
+func count(blocks []*BasicBlock, b *BasicBlock) int {
+	n := 0
+	for _, c := range blocks {
+		if c == b {
+			n++
+		}
+	}
+	return n
+}
+
+// index returns the index of instr in instrs, or -1.
+func index(instrs []Instruction, instr Instruction) int {
+	for i, x := range instrs {
+		if x == instr {
+			return i
+		}
+	}
+	return -1
+}
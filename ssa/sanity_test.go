@@ -0,0 +1,73 @@
+package ssa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanityCheck(t *testing.T) {
+	const src = `
+	local a = 1
+	if x then
+		a = f(a)
+	end
+	print(a + 1)
+	`
+	if err := SanityCheck(build(src, t)); err != nil {
+		t.Fatalf("valid function: %v", err)
+	}
+
+	// block returns the block of fn whose comment is name.
+	block := func(fn *Function, name string) *BasicBlock {
+		for _, b := range fn.Blocks {
+			if b.Comment == name {
+				return b
+			}
+		}
+		t.Fatalf("no block %s in\n%s", name, fn)
+		return nil
+	}
+	tests := []struct {
+		name    string
+		corrupt func(fn *Function)
+		want    string
+	}{
+		{"asymmetric edge", func(fn *Function) {
+			b := block(fn, "if.done")
+			b.Preds = b.Preds[:1]
+		}, "does not have this block as predecessor"},
+		{"phi edges", func(fn *Function) {
+			phi := block(fn, "if.done").Instrs[0].(*Phi)
+			phi.Edges = phi.Edges[:1]
+		}, "has 1 edges, but the block has 2 predecessors"},
+		{"after terminator", func(fn *Function) {
+			b := fn.Blocks[0]
+			b.Instrs = append(b.Instrs, b.Instrs[0])
+		}, "is followed by"},
+		{"no terminator", func(fn *Function) {
+			b := block(fn, "if.then")
+			b.Instrs = b.Instrs[:len(b.Instrs)-1]
+		}, "does not end in a terminator"},
+		{"index", func(fn *Function) {
+			fn.Blocks[1].Index = 5
+		}, "has Index 5, but is at index 1"},
+		{"use before definition", func(fn *Function) {
+			b := block(fn, "if.done")
+			n := len(b.Instrs)
+			b.Instrs[n-3], b.Instrs[n-2] = b.Instrs[n-2], b.Instrs[n-3]
+		}, "before its definition"},
+		{"referrers", func(fn *Function) {
+			b := block(fn, "if.done")
+			add := b.Instrs[len(b.Instrs)-3].(*Arithmetic)
+			add.referrers = nil
+		}, "is not among its referrers"},
+	}
+	for _, test := range tests {
+		fn := build(src, t)
+		test.corrupt(fn)
+		err := SanityCheck(fn)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package ssa
+
+// This file defines the sparse conditional constant propagation pass,
+// which finds the values that are constant and the blocks that are
+// unreachable given them, and prunes the branches never taken.
+//
+// The algorithm is the one of Wegman and Zadeck, Constant propagation
+// with conditional branches, TOPLAS 1991. Each value starts as
+// undefined and only goes down the lattice to a constant, then to
+// overdefined, and only the edges found executable are followed: an If
+// whose condition is a constant takes only one of them. So a φ-node
+// merging a constant with a value from a branch never taken is the
+// constant, as x in
+//
+//	local x = 1
+//	if 1 + 1 ~= 2 then x = f() end
+//	return x
+//
+// The values of the operations are those of FoldConstants. The locals
+// assigned more than once, captured by closures or defined by loops
+// are overdefined.
+
+// A cell is the lattice value of an SSA value.
+type cell struct {
+	kind int // undefined, constant or overdefined
+	c    Value
+}
+
+const (
+	undefined = iota
+	constant
+	overdefined
+)
+
+// An edge is an edge of the control flow graph.
+type edge struct{ from, to *BasicBlock }
+
+// sccp holds the state of the propagation in one function.
+type sccp struct {
+	fn         *Function
+	tracked    map[Value]bool // the values whose cell is computed
+	cells      map[Value]cell
+	executable map[*BasicBlock]bool
+	edges      map[edge]bool // the executable edges
+	flow       []edge
+	ssa        []Instruction
+}
+
+// SCCP propagates the constants of fn and of the functions nested in
+// it through the branches they decide, and deletes the blocks that are
+// unreachable.
+func SCCP(fn *Function) {
+	s := &sccp{
+		fn:         fn,
+		tracked:    make(map[Value]bool),
+		cells:      make(map[Value]cell),
+		executable: make(map[*BasicBlock]bool),
+		edges:      make(map[edge]bool),
+	}
+	s.track()
+	s.propagate()
+	s.prune()
+	for _, nested := range fn.Functions {
+		SCCP(nested)
+	}
+}
+
+// track finds the values whose cell is computed: the results of the
+// operations, and the locals with exactly one definition, a φ-node or
+// an assignment.
+func (s *sccp) track() {
+	n := make(map[*Local]int)
+	for _, p := range s.fn.Params {
+		n[p]++
+	}
+	for _, b := range s.fn.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *Arithmetic, *Relation, *Concat, *Unary, *Logic:
+				s.tracked[instr.(Value)] = true
+			case *Phi:
+				s.tracked[instr.Local] = true
+			case *Assign:
+				if l, ok := instr.Lhs.(*Local); ok && l.Outer == nil && !l.Escapes {
+					s.tracked[l] = true
+				}
+			}
+			for _, l := range defs(instr) {
+				n[l]++
+			}
+		}
+	}
+	for l, n := range n {
+		if n != 1 {
+			delete(s.tracked, l)
+		}
+	}
+}
+
+// cell returns the cell of the operand v.
+func (s *sccp) cell(v Value) cell {
+	if isConst(v) {
+		return cell{constant, v}
+	}
+	if s.tracked[v] {
+		return s.cells[v]
+	}
+	return cell{kind: overdefined}
+}
+
+// set lowers the cell of v to c, and revisits its uses if it changed.
+func (s *sccp) set(v Value, c cell) {
+	if s.cells[v] == c {
+		return
+	}
+	s.cells[v] = c
+	for _, instr := range *v.Referrers() {
+		if s.executable[instr.Block()] {
+			s.ssa = append(s.ssa, instr)
+		}
+	}
+}
+
+// meet returns the greatest lower bound of a and b.
+func meet(a, b cell) cell {
+	switch {
+	case a.kind == undefined:
+		return b
+	case b.kind == undefined:
+		return a
+	case a == b:
+		return a
+	}
+	return cell{kind: overdefined}
+}
+
+// propagate computes the cells and the executable blocks.
+func (s *sccp) propagate() {
+	s.reach(s.fn.Blocks[0])
+	for len(s.flow) > 0 || len(s.ssa) > 0 {
+		for len(s.flow) > 0 {
+			e := s.flow[len(s.flow)-1]
+			s.flow = s.flow[:len(s.flow)-1]
+			if s.edges[e] {
+				continue
+			}
+			s.edges[e] = true
+			if s.executable[e.to] {
+				for _, phi := range e.to.phis() {
+					s.visit(phi)
+				}
+			} else {
+				s.reach(e.to)
+			}
+		}
+		for len(s.ssa) > 0 {
+			instr := s.ssa[len(s.ssa)-1]
+			s.ssa = s.ssa[:len(s.ssa)-1]
+			s.visit(instr)
+		}
+	}
+}
+
+// reach marks b executable and visits its instructions.
+func (s *sccp) reach(b *BasicBlock) {
+	s.executable[b] = true
+	for _, instr := range b.Instrs {
+		s.visit(instr)
+	}
+}
+
+// follow marks the edges from b to succs executable.
+func (s *sccp) follow(b *BasicBlock, succs ...*BasicBlock) {
+	for _, succ := range succs {
+		s.flow = append(s.flow, edge{b, succ})
+	}
+}
+
+// visit computes the cell of the value defined by instr, or the
+// executable edges from its block if it is a terminator.
+func (s *sccp) visit(instr Instruction) {
+	b := instr.Block()
+	switch instr := instr.(type) {
+	case *Phi:
+		if !s.tracked[instr.Local] {
+			return
+		}
+		var c cell
+		for i, v := range instr.Edges {
+			if s.edges[edge{b.Preds[i], b}] {
+				c = meet(c, s.cell(v))
+			}
+		}
+		s.set(instr.Local, c)
+	case *Assign:
+		if s.tracked[instr.Lhs] {
+			s.set(instr.Lhs, s.cell(instr.Rhs))
+		}
+	case *Arithmetic, *Relation, *Concat, *Unary, *Logic:
+		s.set(instr.(Value), s.eval(instr))
+	case *If:
+		switch c := s.cell(instr.Cond); c.kind {
+		case constant:
+			if truthy(c.c) {
+				s.follow(b, b.Succs[0])
+			} else {
+				s.follow(b, b.Succs[1])
+			}
+		case overdefined:
+			s.follow(b, b.Succs...)
+		}
+	default:
+		if isTerminator(instr) {
+			s.follow(b, b.Succs...)
+		}
+	}
+}
+
+// eval returns the cell of the result of the operation instr.
+func (s *sccp) eval(instr Instruction) cell {
+	if l, ok := instr.(*Logic); ok {
+		c := s.cell(l.Lhs)
+		if c.kind != constant {
+			return c
+		}
+		if truthy(c.c) == (l.Op == "or") {
+			return c
+		}
+		return s.cell(l.Rhs)
+	}
+	// Fold a copy of instr on the constants of its operands.
+	var op Instruction
+	switch instr := instr.(type) {
+	case *Arithmetic:
+		v := *instr
+		op = &v
+	case *Relation:
+		v := *instr
+		op = &v
+	case *Concat:
+		v := *instr
+		op = &v
+	case *Unary:
+		v := *instr
+		op = &v
+	}
+	kind := constant
+	for _, rand := range op.Operands(nil) {
+		c := s.cell(*rand)
+		if c.kind == overdefined || c.kind == undefined && kind == constant {
+			kind = c.kind
+		}
+		*rand = c.c
+	}
+	if kind != constant {
+		return cell{kind: kind}
+	}
+	if v, ok := fold(op); ok {
+		return cell{constant, v}
+	}
+	return cell{kind: overdefined}
+}
+
+// prune replaces the constant values by their constants, the Ifs
+// deciding on a constant by Jumps, and deletes the blocks that are not
+// executable.
+func (s *sccp) prune() {
+	fn := s.fn
+	removed := make(map[*Local]bool)
+	for _, b := range fn.Blocks {
+		if !s.executable[b] {
+			continue
+		}
+		for i, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *If:
+				c := s.cell(instr.Cond)
+				if c.kind != constant || b.Succs[0] == b.Succs[1] {
+					break
+				}
+				taken, other := b.Succs[0], b.Succs[1]
+				if !truthy(c.c) {
+					taken, other = other, taken
+				}
+				removeUses(instr)
+				jump := new(Jump)
+				jump.setBlock(b)
+				b.Instrs[i] = jump
+				other.removePred(b)
+				b.Succs = append(b.Succs[:0], taken)
+			case *Phi:
+				if c := s.cells[instr.Local]; c.kind == constant {
+					replaceAll(instr.Local, c.c)
+					removeUses(instr)
+					removed[instr.Local] = true
+					b.Instrs[i] = nil
+				}
+			case *Assign:
+				if c := s.cells[instr.Lhs]; c.kind == constant {
+					replaceConst(instr.Lhs.(*Local), c.c)
+				}
+			case *Arithmetic, *Relation, *Concat, *Unary, *Logic:
+				if c := s.cells[instr.(Value)]; c.kind == constant {
+					replaceAll(instr.(Value), c.c)
+					removeUses(instr)
+					b.Instrs[i] = nil
+				}
+			}
+		}
+		removeNilInstrs(b)
+	}
+
+	for i, b := range fn.Blocks {
+		if s.executable[b] {
+			continue
+		}
+		for _, succ := range b.Succs {
+			if s.executable[succ] {
+				succ.removePred(b)
+			}
+		}
+		for _, instr := range b.Instrs {
+			removeUses(instr)
+			for _, l := range defs(instr) {
+				removed[l] = true // unless it is defined elsewhere
+			}
+		}
+		if b == fn.Exit {
+			fn.Exit = nil // the function never returns
+		}
+		fn.Blocks[i] = nil
+	}
+	fn.removeNilBlocks()
+
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				delete(removed, l)
+			}
+		}
+	}
+	if len(removed) > 0 {
+		locals := fn.Locals[:0]
+		for _, l := range fn.Locals {
+			if !removed[l] {
+				locals = append(locals, l)
+			}
+		}
+		fn.Locals = locals
+	}
+	numberRegisters(fn)
+}
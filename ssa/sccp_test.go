@@ -0,0 +1,58 @@
+package ssa
+
+import (
+	"testing"
+)
+
+func TestSCCP(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			"if 1 + 1 == 2 then print('a') else print('b') end",
+			"print(\"a\");\n",
+		},
+		{
+			"if 1 / 2 == 0.5 then print('a') else print('b') end",
+			"print(\"a\");\n",
+		},
+		{
+			"local x = 1 + 1\nif x == 2.0 then print(x) end",
+			"local x = 2;\nprint(2);\n",
+		},
+		{
+			"local x = 1\nif 1 + 1 ~= 2 then x = f() end\nreturn x",
+			"local x = 1;\nreturn 1;\n",
+		},
+		{
+			"local x = 1\nwhile x < 0 do x = x + 1 end\nprint(x)",
+			"local x = 1;\nprint(1);\n",
+		},
+		{
+			"local a = 2\nlocal b\nif a > 1 then b = 'big' else b = 'small' end\nif b == 'big' then return 1 end\nreturn 2",
+			"local a = 2;\nlocal b;\nb = \"big\";\nreturn 1;\n",
+		},
+		{
+			"local t = 'x'\nrepeat print(t) until #t == 1",
+			"local t = \"x\";\nprint(\"x\");\n",
+		},
+		{
+			"local function f() if false then return 1 end return 2 end return f()",
+			"local function f()\n\treturn 2;\nend;\nreturn f();\n",
+		},
+		{
+			"while true do print(1) end",
+			"while true do\n\tprint(1);\nend;\n",
+		},
+		{
+			"local i = 0\nwhile i < 10 do i = i + 1 end\nif i then return i end",
+			"local i = 0;\nwhile i < 10 do\n\ti = i + 1;\nend;\nif i then\n\treturn i;\nend;\n",
+		},
+	}
+	for _, test := range tests {
+		if got := runPasses(test.input, t, SCCP); got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.input, got, test.want)
+		}
+	}
+}
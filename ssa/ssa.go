@@ -6,17 +6,32 @@ import (
 	"github.com/hootrhino/beautiful-lua-go/ast"
 )
 
+// A Value is an expression that yields a value: a constant, a local, a
+// global, a function or an instruction computing it.
 type Value interface {
 	String() string
+	Parent() *Function
+
+	// Referrers returns the instructions using the value as an operand,
+	// or nil for constants, whose uses are not tracked.
+	Referrers() *[]Instruction
 }
 
+// An Instruction is a statement of a basic block. The instructions that
+// compute a value, such as *Arithmetic or *Call, are also Values, and
+// their String is the expression they compute.
 type Instruction interface {
 	String() string
 	Parent() *Function
 	Block() *BasicBlock
 	setBlock(*BasicBlock)
+
+	// Operands appends to rands the addresses of the values the
+	// instruction uses, so that a pass can replace them.
+	Operands(rands []*Value) []*Value
 }
 
+// A Node is a Value or an Instruction.
 type Node interface {
 	// Common methods:
 	String() string
@@ -27,17 +42,21 @@ type Node interface {
 	Referrers() *[]Instruction        // nil for non-Values
 }
 
+// A Variable is a value that a name of a scope refers to.
 type Variable interface {
-	String() string
+	Value
+	Name() string
 }
 
 type Function struct {
 	Name      string
 	Params    []*Local // function parameters; for methods, includes receiver
 	Locals    []*Local
-	UpValues  []*Local
+	UpValues  []*Local      // the locals of enclosing functions it uses, in order of first use
 	Functions []*Function   // nested functions defined inside this one
 	Blocks    []*BasicBlock // basic blocks of the function; nil => external
+	Exit      *BasicBlock   // the successor of every Return; nil if none is reachable
+	Env       *Global       // the _ENV of the main function, if it uses globals
 	VarArg    bool
 
 	syntax        *ast.FunctionExpr
@@ -57,6 +76,8 @@ type Scope struct {
 	function *Function
 	parent   *Scope
 	names    map[string]Variable
+	locals   []*Local           // locals declared in the scope, in order
+	labels   map[string]*lblock // labels of the block of the scope
 }
 
 type BasicBlock struct {
@@ -74,6 +95,13 @@ type anInstruction struct {
 	block *BasicBlock // the basic block of this instruction
 }
 
+// register is the base of the instructions that are also Values.
+type register struct {
+	anInstruction
+	num       int           // "name" of the value, like t1
+	referrers []Instruction // the instructions using the value
+}
+
 type Jump struct {
 	anInstruction
 }
@@ -81,38 +109,63 @@ type Jump struct {
 type Phi struct {
 	anInstruction
 	Comment string  // a hint as to its purpose
+	Local   *Local  // the local defined by the φ-node
 	Edges   []Value // Edges[i] is value for Block().Preds[i]
 }
 
+// A Local is a local variable, a parameter or a temporary of a function.
+// An upvalue of a function is a Local of its own whose Outer is the
+// captured local of the enclosing function, itself possibly an upvalue.
+// A local captured by a nested function Escapes, and it is not lifted.
 type Local struct {
 	Comment string
 	Value   Value
 	Num     int
+	Outer   *Local // for an upvalue, the local it captures
+	Escapes bool   // captured by a nested function
 
-	declared bool
+	parent    *Function
+	referrers []Instruction
+	orig      *Local // the variable a lifted local is a version of
 }
 
+// A Global is a variable the chunk does not declare. Since lua 5.2 the
+// only one is _ENV, the environment of the chunk: the global x is the
+// field _ENV.x, which the builder loads with Index and assigns with
+// Store.
 type Global struct {
 	Comment string
 	Value   Value
+
+	parent    *Function
+	referrers []Instruction
 }
 
+// Assign assigns Rhs to the local or global Lhs. Fields of tables are
+// assigned by Store.
 type Assign struct {
 	anInstruction
 	Lhs Value
 	Rhs Value
 }
 
-type CompoundAssign struct {
+// Store assigns Value to the field Key of the table Object, as in
+// t[k] = v or t.k = v.
+type Store struct {
 	anInstruction
-	Op  string
-	Lhs Value
-	Rhs Value
+	Object Value
+	Key    Value
+	Value  Value
 }
 
+// Return returns Results from the function. If Spread is set, the last
+// result is a call or ... whose values are all returned, as in
+// return f(). The block of a Return has the exit block of the function
+// as its only successor.
 type Return struct {
 	anInstruction
-	//TODO
+	Results []Value
+	Spread  bool
 }
 
 type NumberFor struct {
@@ -134,15 +187,20 @@ type If struct {
 	Cond Value
 }
 
+// Call calls Func with Args, or the method Method of Recv. If Spread is
+// set, the last argument is a call or ... whose values are all passed.
+// As an operand, a call is its first result; Extract selects the
+// others.
 type Call struct {
-	anInstruction
+	register
+	Spread bool
 	Args   []Value
 	Func   Value
 	Method string
 	Recv   Value
 }
 
-// Expressions
+// Constants
 
 type Nil struct{}
 
@@ -150,8 +208,11 @@ type True struct{}
 
 type False struct{}
 
+// A Number is an integer, or a float if Float is set, as its literal
+// says: 1 is an integer and 1.0 a float.
 type Number struct {
 	Value float64
+	Float bool
 }
 
 type String struct {
@@ -160,43 +221,65 @@ type String struct {
 
 type VarArg struct{}
 
+// Expressions
+
 type Field struct {
-	Key   Value
+	Key   Value // nil for positional fields
 	Value Value
 }
 
-type Table struct {
+// Extract is the value at Index of the values of Tuple, a call or ...,
+// or nil if Tuple has fewer values.
+type Extract struct {
+	register
+	Tuple Value
+	Index int
+}
+
+// NewTable creates a table from its constructor fields, in order. If
+// Spread is set, the last field is a positional call or ... whose values
+// are all added.
+type NewTable struct {
+	register
+	Spread bool
 	Fields []*Field
 }
 
-type AttrGet struct {
+// Index loads the field Key of the table Object, as in t[k] or t.k.
+type Index struct {
+	register
 	Object Value
 	Key    Value
 }
 
 type Arithmetic struct {
+	register
 	Op  string
 	Lhs Value
 	Rhs Value
 }
 
 type Unary struct {
+	register
 	Op    string
 	Value Value
 }
 
 type Concat struct {
+	register
 	Lhs Value
 	Rhs Value
 }
 
 type Relation struct {
+	register
 	Op  string
 	Lhs Value
 	Rhs Value
 }
 
 type Logic struct {
+	register
 	Op  string
 	Lhs Value
 	Rhs Value
@@ -217,6 +300,50 @@ func (v *anInstruction) Block() *BasicBlock         { return v.block }
 func (v *anInstruction) setBlock(block *BasicBlock) { v.block = block }
 func (v *anInstruction) Referrers() *[]Instruction  { return nil }
 
+func (v *register) Name() string              { return fmt.Sprintf("t%d", v.num) }
+func (v *register) Referrers() *[]Instruction { return &v.referrers }
+func (v *register) setNum(num int)            { v.num = num }
+
+func (v *Local) Name() string {
+	if v.Outer != nil {
+		return fmt.Sprintf("u%d", v.Num)
+	}
+	return fmt.Sprintf("t%d", v.Num)
+}
+
+func (v *Local) Parent() *Function         { return v.parent }
+func (v *Local) Referrers() *[]Instruction { return &v.referrers }
+
+func (v *Global) Parent() *Function         { return v.parent }
+func (v *Global) Referrers() *[]Instruction { return &v.referrers }
+
+// Constants have no parent and their uses are not tracked.
+
+func (Nil) Parent() *Function    { return nil }
+func (True) Parent() *Function   { return nil }
+func (False) Parent() *Function  { return nil }
+func (Number) Parent() *Function { return nil }
+func (String) Parent() *Function { return nil }
+func (VarArg) Parent() *Function { return nil }
+
+func (Nil) Referrers() *[]Instruction    { return nil }
+func (True) Referrers() *[]Instruction   { return nil }
+func (False) Referrers() *[]Instruction  { return nil }
+func (Number) Referrers() *[]Instruction { return nil }
+func (String) Referrers() *[]Instruction { return nil }
+func (VarArg) Referrers() *[]Instruction { return nil }
+
+// Operands of the instructions. Locals defined by an instruction, like
+// the Lhs of an Assign to a local, are not operands.
+
+func (v *Jump) Operands(rands []*Value) []*Value { return rands }
+func (v *Return) Operands(rands []*Value) []*Value {
+	for i := range v.Results {
+		rands = append(rands, &v.Results[i])
+	}
+	return rands
+}
+
 func (v *Phi) Operands(rands []*Value) []*Value {
 	for i := range v.Edges {
 		rands = append(rands, &v.Edges[i])
@@ -224,9 +351,121 @@ func (v *Phi) Operands(rands []*Value) []*Value {
 	return rands
 }
 
+func (v *Assign) Operands(rands []*Value) []*Value {
+	if _, ok := v.Lhs.(*Local); !ok {
+		rands = append(rands, &v.Lhs)
+	}
+	return append(rands, &v.Rhs)
+}
+
+func (v *Store) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Object, &v.Key, &v.Value)
+}
+
+func (v *NumberFor) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Init, &v.Limit, &v.Step)
+}
+
+func (v *GenericFor) Operands(rands []*Value) []*Value {
+	for i := range v.Values {
+		rands = append(rands, &v.Values[i])
+	}
+	return rands
+}
+
+func (v *If) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Cond)
+}
+
+func (v *Call) Operands(rands []*Value) []*Value {
+	rands = append(rands, &v.Func, &v.Recv)
+	for i := range v.Args {
+		rands = append(rands, &v.Args[i])
+	}
+	return rands
+}
+
+func (v *Extract) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Tuple)
+}
+
+func (v *NewTable) Operands(rands []*Value) []*Value {
+	for _, field := range v.Fields {
+		rands = append(rands, &field.Key, &field.Value)
+	}
+	return rands
+}
+
+func (v *Index) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Object, &v.Key)
+}
+
+func (v *Arithmetic) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Lhs, &v.Rhs)
+}
+
+func (v *Unary) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Value)
+}
+
+func (v *Concat) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Lhs, &v.Rhs)
+}
+
+func (v *Relation) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Lhs, &v.Rhs)
+}
+
+func (v *Logic) Operands(rands []*Value) []*Value {
+	return append(rands, &v.Lhs, &v.Rhs)
+}
+
 // Non-Instruction Values:
-// func (v *Const) Operands(rands []*Value) []*Value    { return rands }
 func (v *Function) Operands(rands []*Value) []*Value { return rands }
-
-// func (v *Function) Name() string                     { return fmt.Sprintf("func:%d", v.num) }
-func (v *Local) Name() string { return fmt.Sprintf("t%d", v.Num) }
+func (v *Local) Operands(rands []*Value) []*Value    { return rands }
+func (v *Global) Operands(rands []*Value) []*Value   { return rands }
+func (Nil) Operands(rands []*Value) []*Value         { return rands }
+func (True) Operands(rands []*Value) []*Value        { return rands }
+func (False) Operands(rands []*Value) []*Value       { return rands }
+func (Number) Operands(rands []*Value) []*Value      { return rands }
+func (String) Operands(rands []*Value) []*Value      { return rands }
+func (VarArg) Operands(rands []*Value) []*Value      { return rands }
+
+var (
+	_ Node = (*Jump)(nil)
+	_ Node = (*Phi)(nil)
+	_ Node = (*Assign)(nil)
+	_ Node = (*Store)(nil)
+	_ Node = (*Return)(nil)
+	_ Node = (*NumberFor)(nil)
+	_ Node = (*GenericFor)(nil)
+	_ Node = (*If)(nil)
+	_ Node = (*Call)(nil)
+	_ Node = (*Extract)(nil)
+	_ Node = (*NewTable)(nil)
+	_ Node = (*Index)(nil)
+	_ Node = (*Arithmetic)(nil)
+	_ Node = (*Unary)(nil)
+	_ Node = (*Concat)(nil)
+	_ Node = (*Relation)(nil)
+	_ Node = (*Logic)(nil)
+	_ Node = (*Function)(nil)
+	_ Node = (*Local)(nil)
+	_ Node = (*Global)(nil)
+	_ Node = Nil{}
+	_ Node = True{}
+	_ Node = False{}
+	_ Node = Number{}
+	_ Node = String{}
+	_ Node = VarArg{}
+
+	_ Value = (*Call)(nil)
+	_ Value = (*Extract)(nil)
+	_ Value = (*NewTable)(nil)
+	_ Value = (*Index)(nil)
+	_ Value = (*Arithmetic)(nil)
+	_ Value = (*Unary)(nil)
+	_ Value = (*Concat)(nil)
+	_ Value = (*Relation)(nil)
+	_ Value = (*Logic)(nil)
+)
@@ -1,12 +1,1370 @@
 package ssa
 
+// This file implements the decompiler, which turns the SSA code of a
+// function back into a lua chunk that the formatter can print.
+//
+// The control flow is structured with the dominator and post-dominator
+// trees. A block ending in an If becomes an if statement whose arms join
+// at its immediate post-dominator, and the blocks that only test another
+// condition are merged into it with and and or. The natural loop of a
+// header becomes a for loop, or a while true loop that is simplified
+// into a while or repeat loop when its first or last statement leaves
+// it. The edges that do not fit become break, continue or goto.
+//
+// The versions of a lifted local share the name of their variable, and
+// the φ-nodes become multiple assignments on their incoming edges. A
+// register used once, by a later statement of its block, is inlined into
+// it when that keeps the order of evaluation; the others are assigned to
+// temporaries, and to _ if unused but their operation may raise an error
+// or call a metamethod. A variable is declared local where it is first
+// assigned at the top level of the function, and at its start otherwise,
+// or if the function uses goto. A variable captured by closures is also
+// declared where it is first assigned in a nested block, or at the start
+// of the block if a goto may jump into its scope, unless it is used out
+// of the block, so that each iteration of a loop has its own.
+
 import (
 	"fmt"
+	"math"
 
 	"github.com/hootrhino/beautiful-lua-go/ast"
 )
 
-func expr(v Value) ast.Expr {
+// Chunk decompiles f into lua. It takes f out of SSA form first. The
+// error reports a control flow or an instruction that the decompiler
+// cannot turn back into lua.
+func (f *Function) Chunk() (chunk ast.Chunk, err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil:
+		case decompileError:
+			chunk, err = nil, e.err
+		default:
+			panic(e)
+		}
+	}()
+
+	OutOfSSA(f)
+	return newDecompiler(f, nil).function(), nil
+}
+
+// A decompileError carries the error stopping the decompilation.
+type decompileError struct{ err error }
+
+// errorf stops the decompilation with an error.
+func (d *decompiler) errorf(format string, args ...interface{}) {
+	panic(decompileError{fmt.Errorf("ssa: "+format, args...)})
+}
+
+type decompiler struct {
+	fn     *Function
+	parent *decompiler // of the enclosing function
+
+	names   map[*Local]string // the names of the variables
+	regs    map[Value]string  // the temporaries holding registers
+	taken   map[string]bool   // the names of the variables of fn
+	special map[string]bool   // the variables declared by parameters and loops
+
+	ipdom   []*BasicBlock         // immediate post-dominators by Block.Index
+	loops   map[*BasicBlock]*loop // natural loops by header
+	visited map[*BasicBlock]bool
+	placed  map[*BasicBlock]bool // blocks whose label is emitted
+	labels  map[*BasicBlock]bool // targets of gotos
+	used    map[string]bool      // labels used by gotos
+	targets []*BasicBlock        // targets of gotos, in order
+
+	inline  map[Value]bool       // registers inlined into their use
+	pending []Value              // inlined registers not used yet, in order
+	handled map[Instruction]bool // instructions emitted with an earlier one
+
+	depth   int // nesting of the chunk being emitted
+	defined map[string]bool
+	escapes map[string]bool   // the variables captured by closures
+	decls   map[ast.Stmt]bool // the local statements of the top level
+	scoped  map[ast.Stmt]bool // the local statements of nested blocks
+	hoisted []string          // the variables declared at the start
+}
+
+// A loop is the natural loop of a header.
+type loop struct {
+	header *BasicBlock
+	blocks map[*BasicBlock]bool
+	follow *BasicBlock          // the block following the loop, or nil
+	after  map[*BasicBlock]bool // the blocks reachable from follow, not through the loop
+	cont   string               // the label ending the body, if used
+}
+
+// A region is the context blocks are emitted in.
+type region struct {
+	stop   *BasicBlock // the block following the region, emitted by the parent
+	loop   *loop       // the innermost loop
+	parent *region
+
+	// For an arm of an if, the block it starts at, the blocks of the
+	// condition, and the blocks it jumps to that are emitted after the
+	// if since they are also reached from elsewhere.
+	entry    *BasicBlock
+	merged   map[*BasicBlock]bool
+	deferred []*BasicBlock
+}
+
+func newDecompiler(fn *Function, parent *decompiler) *decompiler {
+	numberRegisters(fn)
+	d := &decompiler{
+		fn:      fn,
+		parent:  parent,
+		names:   make(map[*Local]string),
+		regs:    make(map[Value]string),
+		taken:   make(map[string]bool),
+		special: make(map[string]bool),
+		loops:   make(map[*BasicBlock]*loop),
+		visited: make(map[*BasicBlock]bool),
+		placed:  make(map[*BasicBlock]bool),
+		labels:  make(map[*BasicBlock]bool),
+		used:    make(map[string]bool),
+		inline:  make(map[Value]bool),
+		handled: make(map[Instruction]bool),
+		defined: make(map[string]bool),
+		escapes: make(map[string]bool),
+		decls:   make(map[ast.Stmt]bool),
+		scoped:  make(map[ast.Stmt]bool),
+	}
+	// Upvalues keep the names of the variables they capture. The names
+	// of all the variables are chosen before any code is emitted, so
+	// that globals and nested functions can avoid them. A local _ENV is
+	// renamed if the environment of the chunk is used.
+	if usesEnv(fn) {
+		d.taken["_ENV"] = true
+	}
+	for _, u := range fn.UpValues {
+		name := u.Comment
+		if parent != nil {
+			name = parent.localName(u.Outer)
+		}
+		d.names[u] = name
+		d.taken[name] = true
+		d.special[name] = true
+	}
+	for _, p := range fn.Params {
+		d.special[d.localName(p)] = true
+	}
+	for _, l := range fn.Locals {
+		if name := d.localName(l); l.Escapes {
+			d.escapes[name] = true
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, l := range defs(instr) {
+				d.localName(l)
+			}
+			switch instr := instr.(type) {
+			case *NumberFor:
+				d.special[d.localName(instr.Local.(*Local))] = true
+			case *GenericFor:
+				for _, l := range instr.Locals {
+					d.special[d.localName(l.(*Local))] = true
+				}
+			}
+		}
+	}
+	return d
+}
+
+// usesEnv reports whether fn or a function nested in it uses the
+// environment of the chunk.
+func usesEnv(fn *Function) bool {
+	root := fn
+	for root.parent != nil {
+		root = root.parent
+	}
+	if root.Env == nil {
+		return false
+	}
+	for _, instr := range root.Env.referrers {
+		for f := instr.Parent(); f != nil; f = f.parent {
+			if f == fn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// function returns the body of the function.
+func (d *decompiler) function() ast.Chunk {
+	fn := d.fn
+	buildDomTree(fn)
+	d.ipdom = postDominators(fn)
+	d.findLoops()
+	d.findInlined()
+
+	var chunk ast.Chunk
+	d.seq(&chunk, fn.Blocks[0], &region{})
+	// The targets of gotos reached from nowhere else go at the end.
+	for i := 0; i < len(d.targets); i++ {
+		if b := d.targets[i]; !d.visited[b] {
+			if n := len(chunk); n > 0 {
+				if ret, ok := chunk[n-1].(*ast.ReturnStmt); ok {
+					chunk[n-1] = &ast.DoBlockStmt{Chunk: ast.Chunk{ret}}
+				}
+			}
+			d.seq(&chunk, b, &region{})
+		}
+	}
+	chunk = d.simplify(chunk)
+	chunk = d.checkScopes(chunk, chunk, nil)
+
+	if len(d.used) > 0 {
+		// A goto must not jump into the scope of a local.
+		var names []string
+		for i, stmt := range chunk {
+			if !d.decls[stmt] {
+				continue
+			}
+			switch s := stmt.(type) {
+			case *ast.LocalAssignStmt:
+				names = append(names, s.Names...)
+				chunk[i] = assignment(s.Names, s.Exprs)
+			case *ast.LocalFunctionStmt:
+				names = append(names, s.Name)
+				chunk[i] = assignment([]string{s.Name}, []ast.Expr{s.Func})
+			}
+		}
+		d.hoisted = append(names, d.hoisted...)
+	}
+	if n := len(chunk); n > 0 {
+		if ret, ok := chunk[n-1].(*ast.ReturnStmt); ok && len(ret.Exprs) == 0 {
+			chunk = chunk[:n-1] // the end of the function
+		}
+	}
+	if len(d.hoisted) > 0 {
+		chunk = append(ast.Chunk{&ast.LocalAssignStmt{Names: d.hoisted}}, chunk...)
+	}
+	return chunk
+}
+
+// localName returns the name of the variable l is a version of.
+func (d *decompiler) localName(l *Local) string {
+	if l.orig != nil {
+		l = l.orig
+	}
+	name, ok := d.names[l]
+	if !ok {
+		base := l.Comment
+		if base == "" {
+			base = l.Name()
+		}
+		name = d.unique(base)
+		d.names[l] = name
+	}
+	return name
+}
+
+// regName returns the name of the temporary holding the register v.
+func (d *decompiler) regName(v Value) string {
+	name, ok := d.regs[v]
+	if !ok {
+		name = d.unique(v.(interface{ Name() string }).Name())
+		d.regs[v] = name
+	}
+	return name
+}
+
+// unique returns base, or base with a suffix if a variable of the
+// function already has this name.
+func (d *decompiler) unique(base string) string {
+	name := base
+	for i := 1; d.taken[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	d.taken[name] = true
+	return name
+}
+
+// isLocalName reports whether name may refer to a variable of the
+// function or of an enclosing one.
+func (d *decompiler) isLocalName(name string) bool {
+	for ; d != nil; d = d.parent {
+		if d.taken[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// findLoops finds the natural loop of each block that is the target of
+// a back edge, and of each for loop, which has none if its body always
+// leaves it.
+func (d *decompiler) findLoops() {
+	for _, h := range d.fn.Blocks {
+		var l *loop
+		if n := len(h.Instrs); n > 0 {
+			switch h.Instrs[n-1].(type) {
+			case *NumberFor, *GenericFor:
+				l = &loop{header: h, blocks: map[*BasicBlock]bool{h: true}}
+			}
+		}
+		for _, p := range h.Preds {
+			if !h.Dominates(p) {
+				continue
+			}
+			if l == nil {
+				l = &loop{header: h, blocks: map[*BasicBlock]bool{h: true}}
+			}
+			work := []*BasicBlock{p}
+			for len(work) > 0 {
+				b := work[len(work)-1]
+				work = work[:len(work)-1]
+				if !l.blocks[b] {
+					l.blocks[b] = true
+					work = append(work, b.Preds...)
+				}
+			}
+		}
+		if l != nil {
+			l.follow = d.loopFollow(l)
+			d.loops[h] = l
+		}
+	}
+}
+
+// loopFollow returns the block the loop l exits to, or nil if it only
+// leaves the function.
+func (d *decompiler) loopFollow(l *loop) *BasicBlock {
+	h := l.header
+	switch h.Instrs[len(h.Instrs)-1].(type) {
+	case *NumberFor, *GenericFor:
+		return h.Succs[1]
+	}
+	p := d.ipdom[h.Index]
+	for p != nil && l.blocks[p] {
+		p = d.ipdom[p.Index]
+	}
+	if p != nil {
+		return p
+	}
+	for _, s := range h.Succs {
+		if !l.blocks[s] {
+			return s
+		}
+	}
+	for _, b := range d.fn.Blocks {
+		if !l.blocks[b] {
+			continue
+		}
+		for _, s := range b.Succs {
+			if !l.blocks[s] && s != d.fn.Exit && !returns(s) {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// owns reports whether b, which is not in the loop l, is only reached
+// by leaving the loop some other way than to its follow: it can be
+// emitted in the body of the loop.
+func (d *decompiler) owns(l *loop, b *BasicBlock) bool {
+	if l.after == nil {
+		l.after = make(map[*BasicBlock]bool)
+		if l.follow != nil {
+			work := []*BasicBlock{l.follow}
+			for len(work) > 0 {
+				b := work[len(work)-1]
+				work = work[:len(work)-1]
+				if !l.after[b] && !l.blocks[b] {
+					l.after[b] = true
+					work = append(work, b.Succs...)
+				}
+			}
+		}
+	}
+	return l.blocks[b] || l.header.Dominates(b) && !l.after[b]
+}
+
+// findInlined finds the registers that may be inlined into their only
+// use: in their block, or at the end of it, by the φ-nodes or the loop
+// of its successor.
+func (d *decompiler) findInlined() {
+	for _, b := range d.fn.Blocks {
+		for _, instr := range b.Instrs {
+			if _, ok := instr.(interface{ setNum(int) }); !ok {
+				continue
+			}
+			if _, ok := instr.(*Extract); ok || extracts(instr) != nil {
+				continue
+			}
+			refs := *instr.(Value).Referrers()
+			if len(refs) != 1 {
+				continue
+			}
+			switch u := refs[0]; u.(type) {
+			case *Extract:
+			case *Phi, *NumberFor, *GenericFor:
+				// A loop without back edges may be in the block before it.
+				_, phi := u.(*Phi)
+				d.inline[instr.(Value)] = !phi && u.Block() == b ||
+					u.Block() != b && len(b.Succs) == 1 && b.Succs[0] == u.Block()
+			default:
+				d.inline[instr.(Value)] = u.Block() == b
+			}
+		}
+	}
+}
+
+// extracts returns the Extracts of the values of the call instr, if it
+// is only used through them.
+func extracts(instr Instruction) []*Extract {
+	call, ok := instr.(*Call)
+	if !ok || len(call.referrers) == 0 {
+		return nil
+	}
+	xs := make([]*Extract, len(call.referrers))
+	for i, ref := range call.referrers {
+		x, ok := ref.(*Extract)
+		if !ok || x.Tuple != call {
+			return nil
+		}
+		xs[i] = x
+	}
+	return xs
+}
+
+// seq emits to out the blocks from b on, up to the stop of ctx.
+func (d *decompiler) seq(out *ast.Chunk, b *BasicBlock, ctx *region) {
+	for b != nil && b != ctx.stop {
+		if l := d.loops[b]; l != nil && !d.visited[b] {
+			b = d.loop(out, l, ctx)
+		} else {
+			b = d.block(out, b, ctx)
+		}
+	}
+	d.use(out, nil)
+}
+
+// block emits b to out, and returns the block to emit after it, if any.
+func (d *decompiler) block(out *ast.Chunk, b *BasicBlock, ctx *region) *BasicBlock {
+	d.visited[b] = true
+	if !d.placed[b] && (d.labels[b] || len(b.Preds) > 1) {
+		d.placed[b] = true
+		d.stmt(out, &ast.LabelStmt{Name: label(b)})
+	}
+	for i, instr := range b.Instrs {
+		if d.handled[instr] {
+			continue
+		}
+		switch instr := instr.(type) {
+		case *Jump:
+			return d.edge(out, b, b.Succs[0], ctx)
+		case *If:
+			return d.ifStmt(out, b, instr, ctx)
+		case *Return:
+			d.use(out, instr.Results)
+			*out = append(*out, &ast.ReturnStmt{Exprs: d.exprs(instr.Results, instr.Spread)})
+			return nil
+		case *NumberFor, *GenericFor:
+			d.errorf("loop %s is entered at %s", instr, b)
+		default:
+			d.instr(out, b.Instrs[i:])
+		}
+	}
+	return nil
+}
+
+// instr emits to out the first of instrs, which is not a terminator.
+func (d *decompiler) instr(out *ast.Chunk, instrs []Instruction) {
+	switch instr := instrs[0].(type) {
+	case *Phi:
+		// assigned on the edges
+	case *Assign:
+		d.assignInstr(out, instr)
+	case *Store:
+		d.use(out, operands(instr))
+		lhs := d.field(instr.Object, instr.Key)
+		*out = append(*out, &ast.AssignStmt{
+			Lhs: []ast.Expr{lhs},
+			Rhs: []ast.Expr{d.expr(instr.Value)},
+		})
+	case *Extract:
+		if _, ok := instr.Tuple.(VarArg); !ok {
+			d.errorf("%s is not with its call", instr)
+		}
+		d.group(out, instrs)
+	case *Call:
+		if extracts(instr) != nil {
+			d.group(out, instrs)
+		} else {
+			d.value(out, instr)
+		}
+	case interface{ setNum(int) }:
+		d.value(out, instr.(Value))
+	default:
+		d.errorf("cannot decompile %s", instr)
+	}
+}
+
+// value emits the computation of the register v.
+func (d *decompiler) value(out *ast.Chunk, v Value) {
+	switch {
+	case d.inline[v]:
+		delete(d.regs, v)
+		d.pending = append(d.pending, v)
+	case len(*v.Referrers()) > 0:
+		d.use(out, operands(v.(Instruction)))
+		d.materialize(out, v)
+	default:
+		if call, ok := v.(*Call); ok {
+			d.use(out, operands(call))
+			*out = append(*out, &ast.FuncCallStmt{Expr: d.call(call)})
+		} else if hasSideEffects(v.(Instruction), 0) {
+			// The operation may raise an error or call a metamethod.
+			d.use(out, operands(v.(Instruction)))
+			d.assign(out, []string{d.unique("_")}, []ast.Expr{d.compute(v)})
+		}
+		// Other unused values have no effect.
+	}
+}
+
+// materialize emits the assignment of the register v to a temporary.
+func (d *decompiler) materialize(out *ast.Chunk, v Value) {
+	ex := d.compute(v)
+	d.assign(out, []string{d.regName(v)}, []ast.Expr{ex})
+}
+
+// use prepares the emission to out of a statement evaluating rands, in
+// order: it assigns to temporaries the pending registers the statement
+// does not inline, or all of them if inlining would change the order of
+// evaluation.
+func (d *decompiler) use(out *ast.Chunk, rands []Value) {
+	if len(d.pending) == 0 {
+		return
+	}
+	pos := make(map[Value]int, len(d.pending))
+	for i, v := range d.pending {
+		pos[v] = i
+	}
+	var order []int // the positions of the inlined registers
+	var visit func(v Value)
+	visit = func(v Value) {
+		i, ok := pos[v]
+		if !ok {
+			return
+		}
+		for _, rand := range operands(v.(Instruction)) {
+			visit(rand)
+		}
+		order = append(order, i)
+	}
+	for _, v := range rands {
+		visit(v)
+	}
+	pending := d.pending
+	d.pending = nil
+	first := len(pending) - len(order)
+	for k, i := range order {
+		if i != first+k {
+			first = len(pending)
+			break
+		}
+	}
+	for _, v := range pending[:first] {
+		d.materialize(out, v)
+	}
+}
+
+// stmt emits the statement s, which uses no register.
+func (d *decompiler) stmt(out *ast.Chunk, s ast.Stmt) {
+	d.use(out, nil)
+	*out = append(*out, s)
+}
+
+// assign emits the assignment of exprs to the variables names, declaring
+// the ones assigned for the first time.
+func (d *decompiler) assign(out *ast.Chunk, names []string, exprs []ast.Expr) {
+	var fresh []string
+	for _, name := range names {
+		if !d.defined[name] && !d.special[name] {
+			d.defined[name] = true
+			fresh = append(fresh, name)
+		}
+	}
+	if len(fresh) == len(names) && (d.depth == 0 || d.escaping(names)) {
+		var stmt ast.Stmt = &ast.LocalAssignStmt{Names: names, Exprs: exprs}
+		if isNil(exprs) {
+			stmt = &ast.LocalAssignStmt{Names: names}
+		} else if f, ok := exprs[0].(*ast.FunctionExpr); ok && len(names) == 1 {
+			stmt = &ast.LocalFunctionStmt{Name: names[0], Func: f}
+		}
+		if d.depth == 0 {
+			d.decls[stmt] = true
+		} else {
+			d.scoped[stmt] = true
+		}
+		*out = append(*out, stmt)
+		return
+	}
+	d.hoisted = append(d.hoisted, fresh...)
+	*out = append(*out, &ast.AssignStmt{Lhs: idents(names), Rhs: exprs})
+}
+
+func (d *decompiler) escaping(names []string) bool {
+	for _, name := range names {
+		if !d.escapes[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkScopes turns the local statements of the nested blocks of list
+// into assignments if a goto may jump into the scope of their variables,
+// and declares the variables at the start of list, or at the start of
+// the function if they are used out of their scope. The chunk is
+// the body of the function, and until the condition ending list if it is
+// the body of a repeat loop. It returns list.
+func (d *decompiler) checkScopes(chunk, list ast.Chunk, until ast.Expr) ast.Chunk {
+	var declared []string // at the start of list
+	for i, stmt := range list {
+		if d.scoped[stmt] {
+			var names []string
+			switch s := stmt.(type) {
+			case *ast.LocalAssignStmt:
+				names = s.Names
+			case *ast.LocalFunctionStmt:
+				names = []string{s.Name}
+			}
+			used := d.usedIn(chunk, names, list[i+1:], until)
+			if !used || jumpsInto(list[:i], list[i+1:], until) {
+				switch s := stmt.(type) {
+				case *ast.LocalAssignStmt:
+					list[i] = assignment(s.Names, s.Exprs)
+				case *ast.LocalFunctionStmt:
+					list[i] = assignment(names, []ast.Expr{s.Func})
+				}
+				if used {
+					declared = append(declared, names...)
+				} else {
+					d.hoisted = append(d.hoisted, names...)
+				}
+			}
+		}
+		switch s := stmt.(type) {
+		case *ast.IfStmt:
+			s.Then = d.checkScopes(chunk, s.Then, nil)
+			s.Else = d.checkScopes(chunk, s.Else, nil)
+		case *ast.DoBlockStmt:
+			s.Chunk = d.checkScopes(chunk, s.Chunk, nil)
+		case *ast.WhileStmt:
+			s.Chunk = d.checkScopes(chunk, s.Chunk, nil)
+		case *ast.RepeatStmt:
+			s.Chunk = d.checkScopes(chunk, s.Chunk, s.Condition)
+		case *ast.NumberForStmt:
+			s.Chunk = d.checkScopes(chunk, s.Chunk, nil)
+		case *ast.GenericForStmt:
+			s.Chunk = d.checkScopes(chunk, s.Chunk, nil)
+		}
+	}
+	if len(declared) > 0 {
+		list = append(ast.Chunk{&ast.LocalAssignStmt{Names: declared}}, list...)
+	}
+	return list
+}
+
+// jumpsInto reports whether a goto may jump into scope, the statements
+// following a local statement, from before, the statements preceding it:
+// before has a goto and scope a label but at its end, or until follows
+// it.
+func jumpsInto(before, scope ast.Chunk, until ast.Expr) bool {
+	gotos := false
+	ast.Inspect(before, func(node interface{}) bool {
+		_, ok := node.(*ast.GotoStmt)
+		gotos = gotos || ok
+		return !gotos
+	})
+	if !gotos {
+		return false
+	}
+	for i, stmt := range scope {
+		if _, ok := stmt.(*ast.LabelStmt); !ok {
+			continue
+		}
+		if until != nil {
+			return true
+		}
+		for _, next := range scope[i+1:] {
+			if _, ok := next.(*ast.LabelStmt); !ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usedIn reports whether the variables names declared before scope, and
+// until, are only used in them.
+func (d *decompiler) usedIn(chunk ast.Chunk, names []string, scope ast.Chunk, until ast.Expr) bool {
+	uses := func(node interface{}) int {
+		n := 0
+		ast.Inspect(node, func(node interface{}) bool {
+			if id, ok := node.(*ast.IdentExpr); ok {
+				for _, name := range names {
+					if id.Value == name {
+						n++
+					}
+				}
+			}
+			return true
+		})
+		return n
+	}
+	inside := uses(scope)
+	if until != nil {
+		inside += uses(until)
+	}
+	return inside == uses(chunk)
+}
+
+func (d *decompiler) assignInstr(out *ast.Chunk, a *Assign) {
+	lhs, ok := a.Lhs.(*Local)
+	if !ok { // _ENV
+		d.use(out, operands(a))
+		*out = append(*out, &ast.AssignStmt{
+			Lhs: []ast.Expr{d.expr(a.Lhs)},
+			Rhs: []ast.Expr{d.expr(a.Rhs)},
+		})
+		return
+	}
+	name := d.localName(lhs)
+	if rhs, ok := a.Rhs.(*Local); ok && d.localName(rhs) == name {
+		return // between versions of a variable
+	}
+	d.use(out, operands(a))
+	d.assign(out, []string{name}, []ast.Expr{d.expr(a.Rhs)})
+}
+
+// group emits the multiple assignment of the values of a call or of ...
+// to the Extracts selecting them, at the start of instrs. The locals
+// assigned only an Extract right after are assigned the value directly.
+func (d *decompiler) group(out *ast.Chunk, instrs []Instruction) {
+	var tuple Value
+	var xs []*Extract
+	next := 0 // the index in instrs after the group
+	switch first := instrs[0].(type) {
+	case *Call:
+		tuple, xs = first, extracts(first)
+		for next = 1; next < len(instrs); next++ {
+			if x, ok := instrs[next].(*Extract); !ok || x.Tuple != first {
+				break
+			}
+		}
+	case *Extract:
+		tuple = first.Tuple
+		seen := make(map[int]bool)
+		for ; next < len(instrs); next++ {
+			x, ok := instrs[next].(*Extract)
+			if !ok || x.Tuple != tuple || seen[x.Index] {
+				break
+			}
+			seen[x.Index] = true
+			xs = append(xs, x)
+		}
+	}
+
+	n := 0
+	for _, x := range xs {
+		d.handled[x] = true
+		if x.Index >= n {
+			n = x.Index + 1
+		}
+	}
+	names := make([]string, n)
+	target := make(map[Value]bool)
+	for _, x := range xs {
+		target[x] = true
+	}
+	assigned := make(map[string]bool)
+	for _, instr := range instrs[next:] {
+		a, ok := instr.(*Assign)
+		if !ok || !target[a.Rhs] || len(*a.Rhs.Referrers()) != 1 {
+			break
+		}
+		lhs, ok := a.Lhs.(*Local)
+		if !ok || assigned[d.localName(lhs)] {
+			break
+		}
+		x := a.Rhs.(*Extract)
+		names[x.Index] = d.localName(lhs)
+		assigned[names[x.Index]] = true
+		d.regs[x] = names[x.Index]
+		d.handled[a] = true
+	}
+	for _, x := range xs {
+		if names[x.Index] == "" {
+			names[x.Index] = d.regName(x)
+		}
+	}
+	for i, name := range names {
+		if name == "" {
+			names[i] = d.unique("_")
+		}
+	}
+
+	var rhs ast.Expr = &ast.Comma3Expr{}
+	if call, ok := tuple.(*Call); ok {
+		d.use(out, operands(call))
+		rhs = d.call(call)
+	} else {
+		d.use(out, nil)
+	}
+	d.assign(out, names, []ast.Expr{rhs})
+}
+
+// edge emits the transfer of control from from to to, and returns to
+// if it is to be emitted next.
+func (d *decompiler) edge(out *ast.Chunk, from, to *BasicBlock, ctx *region) *BasicBlock {
+	d.copies(out, from, to)
+	return d.transfer(out, to, ctx)
+}
+
+// copies emits the assignments of the φ-nodes of to for the edge from
+// from, in parallel.
+func (d *decompiler) copies(out *ast.Chunk, from, to *BasicBlock) {
+	var names []string
+	var values []Value
+	for _, instr := range to.phis() {
+		phi := instr.(*Phi)
+		v := phi.Edges[to.predIndex(from)]
+		name := d.localName(phi.Local)
+		if l, ok := v.(*Local); ok && d.localName(l) == name {
+			continue
+		}
+		names = append(names, name)
+		values = append(values, v)
+	}
+	if len(names) == 0 {
+		return
+	}
+	d.use(out, values)
+	exprs := make([]ast.Expr, len(values))
+	for i, v := range values {
+		exprs[i] = d.expr(v)
+	}
+	d.assign(out, names, exprs)
+}
+
+// transfer emits the statement jumping to to from the region ctx, and
+// returns to if it is rather to be emitted next.
+func (d *decompiler) transfer(out *ast.Chunk, to *BasicBlock, ctx *region) *BasicBlock {
+	if to == ctx.stop {
+		return nil
+	}
+	if l := ctx.loop; l != nil {
+		switch {
+		case to == l.header:
+			d.stmt(out, &ast.ContinueStmt{})
+			return nil
+		case to == l.follow || jumpsTo(l.follow, to):
+			d.stmt(out, &ast.BreakStmt{})
+			return nil
+		}
+	}
+	for c := ctx.parent; c != nil; c = c.parent {
+		if l := c.loop; l != nil && l != ctx.loop && to == l.header {
+			if l.cont == "" {
+				l.cont = fmt.Sprintf("C%d", l.header.Index)
+			}
+			d.jump(out, l.cont)
+			return nil
+		}
+		if to == c.stop || c.loop != nil && to == c.loop.follow {
+			d.gotoBlock(out, to)
+			return nil
+		}
+	}
+	if d.visited[to] && !d.duplicable(to) || ctx.loop != nil && !d.owns(ctx.loop, to) {
+		d.gotoBlock(out, to)
+		return nil
+	}
+	if a := armOf(ctx); a != nil && !d.duplicable(to) && !a.encloses(to) {
+		// Its label would not be visible from the other gotos to it.
+		a.deferred = append(a.deferred, to)
+		d.gotoBlock(out, to)
+		return nil
+	}
+	return to
+}
+
+// armOf returns the innermost arm of an if in ctx, if any.
+func armOf(ctx *region) *region {
+	for ; ctx != nil; ctx = ctx.parent {
+		if ctx.entry != nil {
+			return ctx
+		}
+	}
+	return nil
+}
+
+// encloses reports whether the arm a holds all the jumps to b.
+func (a *region) encloses(b *BasicBlock) bool {
+	for _, p := range b.Preds {
+		if !a.merged[p] && !a.entry.Dominates(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// jumpsTo reports whether b only jumps to to, which has no φ-nodes, so
+// that jumping to b is the same as jumping to to.
+func jumpsTo(b, to *BasicBlock) bool {
+	if b == nil || len(b.Instrs) != 1 || len(b.Succs) != 1 {
+		return false
+	}
+	_, ok := b.Instrs[0].(*Jump)
+	return ok && b.Succs[0] == to && !to.hasPhi()
+}
+
+// returns reports whether b ends in a Return.
+func returns(b *BasicBlock) bool {
+	_, ok := b.Instrs[len(b.Instrs)-1].(*Return)
+	return ok
+}
+
+// duplicable reports whether b may be emitted again rather than jumped
+// to: it returns, and is reached without assignments.
+func (d *decompiler) duplicable(b *BasicBlock) bool {
+	return returns(b) && !b.hasPhi() && d.loops[b] == nil
+}
+
+func (d *decompiler) gotoBlock(out *ast.Chunk, b *BasicBlock) {
+	if !d.labels[b] {
+		d.labels[b] = true
+		d.targets = append(d.targets, b)
+	}
+	d.jump(out, label(b))
+}
+
+func (d *decompiler) jump(out *ast.Chunk, label string) {
+	d.used[label] = true
+	d.stmt(out, &ast.GotoStmt{Label: label})
+}
+
+func label(b *BasicBlock) string {
+	return fmt.Sprintf("L%d", b.Index)
+}
+
+// ifStmt emits the If ending b, and returns the block where its arms
+// join, if it is to be emitted next.
+func (d *decompiler) ifStmt(out *ast.Chunk, b *BasicBlock, instr *If, ctx *region) *BasicBlock {
+	switch instr.Cond.(type) {
+	case True:
+		return d.edge(out, b, b.Succs[0], ctx)
+	case False, Nil:
+		return d.edge(out, b, b.Succs[1], ctx)
+	}
+	d.use(out, []Value{instr.Cond})
+	merged := map[*BasicBlock]bool{b: true}
+	cond, t, tFrom, f, fFrom := d.condition(b, d.expr(instr.Cond), merged, ctx)
+	follow := d.follow(b, ctx)
+
+	tCtx := &region{stop: follow, loop: ctx.loop, parent: ctx, entry: t, merged: merged}
+	fCtx := &region{stop: follow, loop: ctx.loop, parent: ctx, entry: f, merged: merged}
+	var then, els ast.Chunk
+	d.depth++
+	d.arm(&then, tFrom, t, tCtx)
+	d.arm(&els, fFrom, f, fCtx)
+	d.depth--
+
+	// An arm ending in a jump is better followed by the other one, and
+	// the shorter the better.
+	switch {
+	case jumps(then) && (!jumps(els) || len(then) <= len(els)):
+		*out = append(*out, &ast.IfStmt{Condition: cond, Then: then})
+		*out = append(*out, els...)
+	case jumps(els) || len(then) == 0 && len(els) > 0:
+		*out = append(*out, &ast.IfStmt{Condition: negate(cond), Then: els})
+		*out = append(*out, then...)
+	default:
+		*out = append(*out, &ast.IfStmt{Condition: cond, Then: then, Else: els})
+	}
+
+	// The blocks the arms defer are jumped over.
+	for _, x := range append(tCtx.deferred, fCtx.deferred...) {
+		if d.visited[x] {
+			continue
+		}
+		if a := armOf(ctx); a != nil && !a.encloses(x) {
+			a.deferred = append(a.deferred, x)
+			continue
+		}
+		if follow != nil && !jumps(*out) {
+			d.gotoBlock(out, follow)
+		}
+		d.seq(out, x, &region{stop: follow, loop: ctx.loop, parent: ctx})
+	}
+	return follow
+}
+
+func (d *decompiler) arm(out *ast.Chunk, from, to *BasicBlock, ctx *region) {
+	if next := d.edge(out, from, to, ctx); next != nil {
+		d.seq(out, next, ctx)
+	}
+	d.use(out, nil)
+}
+
+// follow returns the block where the arms of the If ending b join, or
+// nil if they leave the region ctx.
+func (d *decompiler) follow(b *BasicBlock, ctx *region) *BasicBlock {
+	p := d.ipdom[b.Index]
+	if p == nil || p == d.fn.Exit || d.visited[p] {
+		return nil
+	}
+	if l := ctx.loop; l != nil && (!l.blocks[p] || p == l.header) {
+		return nil
+	}
+	for c := ctx.parent; c != nil; c = c.parent {
+		if p == c.stop && p != ctx.stop {
+			return nil
+		}
+	}
+	return p
+}
+
+// condition merges into cond, the condition ending b, the blocks that
+// only test another condition on its branches, and returns the targets
+// of the merged condition with the blocks jumping to them. It adds the
+// merged blocks to merged, which holds b.
+func (d *decompiler) condition(b *BasicBlock, cond ast.Expr, merged map[*BasicBlock]bool, ctx *region) (ex ast.Expr, t, tFrom, f, fFrom *BasicBlock) {
+	t, f = b.Succs[0], b.Succs[1]
+	tFrom, fFrom = b, b
+	for {
+		if c := f; d.isTest(c, t, merged, ctx) {
+			x := d.expr(c.Instrs[len(c.Instrs)-1].(*If).Cond)
+			if c.Succs[0] == t {
+				cond, f = &ast.LogicalOpExpr{Operator: "or", Lhs: cond, Rhs: x}, c.Succs[1]
+			} else {
+				cond, f = &ast.LogicalOpExpr{Operator: "or", Lhs: cond, Rhs: negate(x)}, c.Succs[0]
+			}
+			fFrom = c
+			d.visited[c] = true
+			merged[c] = true
+		} else if c := t; d.isTest(c, f, merged, ctx) {
+			x := d.expr(c.Instrs[len(c.Instrs)-1].(*If).Cond)
+			if c.Succs[1] == f {
+				cond, t = &ast.LogicalOpExpr{Operator: "and", Lhs: cond, Rhs: x}, c.Succs[0]
+			} else {
+				cond, t = &ast.LogicalOpExpr{Operator: "and", Lhs: cond, Rhs: negate(x)}, c.Succs[1]
+			}
+			tFrom = c
+			d.visited[c] = true
+			merged[c] = true
+		} else {
+			return cond, t, tFrom, f, fFrom
+		}
+	}
+}
+
+// isTest reports whether c only computes and tests a condition, with
+// shared as one of its two successors, so that its test can be merged
+// into the condition of its predecessors, all merged already.
+func (d *decompiler) isTest(c, shared *BasicBlock, merged map[*BasicBlock]bool, ctx *region) bool {
+	if d.visited[c] || c == shared || d.loops[c] != nil || shared.hasPhi() {
+		return false
+	}
+	for _, p := range c.Preds {
+		if !merged[p] {
+			return false
+		}
+	}
+	if ctx.loop != nil && !ctx.loop.blocks[c] {
+		return false
+	}
+	n := len(c.Instrs)
+	instr, ok := c.Instrs[n-1].(*If)
+	if !ok || c.Succs[0] == c.Succs[1] || c.Succs[0] != shared && c.Succs[1] != shared {
+		return false
+	}
+	// The condition must inline all the instructions, in order.
+	var order []Instruction
+	var visit func(v Value)
+	visit = func(v Value) {
+		if instr, ok := v.(Instruction); ok && instr.Block() == c && d.inline[v] {
+			for _, rand := range operands(instr) {
+				visit(rand)
+			}
+			order = append(order, instr)
+		}
+	}
+	visit(instr.Cond)
+	if len(order) != n-1 {
+		return false
+	}
+	for i, instr := range order {
+		if c.Instrs[i] != instr {
+			return false
+		}
+	}
+	return true
+}
+
+// loop emits the loop l, and returns the block to emit after it, if any.
+func (d *decompiler) loop(out *ast.Chunk, l *loop, ctx *region) *BasicBlock {
+	h := l.header
+	d.visited[h] = true
+	d.placed[h] = true
+	sub := &region{loop: l, parent: ctx}
+	var body ast.Chunk
+
+	last := h.Instrs[len(h.Instrs)-1]
+	switch last.(type) {
+	case *NumberFor, *GenericFor:
+		// Without back edges, the header may be fused with the block
+		// before the loop.
+		for i, instr := range h.Instrs[:len(h.Instrs)-1] {
+			if _, ok := instr.(*Phi); ok || d.handled[instr] {
+				continue
+			}
+			if len(l.blocks) > 1 {
+				d.errorf("loop %s has other instructions", h)
+			}
+			d.instr(out, h.Instrs[i:])
+		}
+	}
+	switch t := last.(type) {
+	case *NumberFor:
+		d.use(out, []Value{t.Init, t.Limit, t.Step})
+		s := &ast.NumberForStmt{
+			Name:  d.localName(t.Local.(*Local)),
+			Init:  d.expr(t.Init),
+			Limit: d.expr(t.Limit),
+		}
+		if t.Step != (Number{Value: 1}) {
+			s.Step = d.expr(t.Step)
+		}
+		s.Chunk = d.body(h, sub)
+		*out = append(*out, s)
+		return d.edge(out, h, h.Succs[1], ctx)
+	case *GenericFor:
+		d.use(out, t.Values)
+		s := &ast.GenericForStmt{
+			Names: make([]string, len(t.Locals)),
+			Exprs: make([]ast.Expr, len(t.Values)),
+		}
+		for i, v := range t.Locals {
+			s.Names[i] = d.localName(v.(*Local))
+		}
+		for i, v := range t.Values {
+			s.Exprs[i] = d.expr(v)
+		}
+		s.Chunk = d.body(h, sub)
+		*out = append(*out, s)
+		return d.edge(out, h, h.Succs[1], ctx)
+	}
+
+	if len(h.Preds) > 1 {
+		d.stmt(out, &ast.LabelStmt{Name: label(h)})
+	}
+	d.depth++
+	if next := d.block(&body, h, sub); next != nil {
+		d.seq(&body, next, sub)
+	}
+	d.use(&body, nil)
+	d.depth--
+	if l.cont != "" {
+		body = append(body, &ast.LabelStmt{Name: l.cont})
+	}
+	*out = append(*out, &ast.WhileStmt{Condition: &ast.TrueExpr{}, Chunk: body})
+	if l.follow == nil {
+		return nil
+	}
+	return d.transfer(out, l.follow, ctx)
+}
+
+// body returns the body of the for loop with header h.
+func (d *decompiler) body(h *BasicBlock, ctx *region) ast.Chunk {
+	var body ast.Chunk
+	d.depth++
+	if next := d.edge(&body, h, h.Succs[0], ctx); next != nil {
+		d.seq(&body, next, ctx)
+	}
+	d.use(&body, nil)
+	d.depth--
+	if ctx.loop.cont != "" {
+		body = append(body, &ast.LabelStmt{Name: ctx.loop.cont})
+	}
+	return body
+}
+
+// simplify removes the labels no goto uses from chunk, the continue
+// statements ending loops, and rewrites while true loops that test a
+// condition first or last as while and repeat loops.
+func (d *decompiler) simplify(chunk ast.Chunk) ast.Chunk {
+	var out ast.Chunk
+	for _, stmt := range chunk {
+		switch s := stmt.(type) {
+		case *ast.LabelStmt:
+			if !d.used[s.Name] {
+				continue
+			}
+		case *ast.IfStmt:
+			s.Then = d.simplify(s.Then)
+			s.Else = d.simplify(s.Else)
+			flipIf(s)
+		case *ast.DoBlockStmt:
+			s.Chunk = d.simplify(s.Chunk)
+		case *ast.NumberForStmt:
+			s.Chunk = trimContinue(d.simplify(s.Chunk))
+		case *ast.GenericForStmt:
+			s.Chunk = trimContinue(d.simplify(s.Chunk))
+		case *ast.WhileStmt:
+			s.Chunk = trimContinue(d.simplify(s.Chunk))
+			stmt = simplifyLoop(s)
+		}
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// trimContinue removes the continue statements ending a loop body.
+func trimContinue(chunk ast.Chunk) ast.Chunk {
+	n := len(chunk)
+	if n == 0 {
+		return chunk
+	}
+	switch s := chunk[n-1].(type) {
+	case *ast.ContinueStmt:
+		return trimContinue(chunk[:n-1])
+	case *ast.IfStmt:
+		s.Then = trimContinue(s.Then)
+		s.Else = trimContinue(s.Else)
+		flipIf(s)
+	}
+	return chunk
+}
+
+// flipIf negates the condition of an if statement with only an else arm.
+func flipIf(s *ast.IfStmt) {
+	if len(s.Then) == 0 && len(s.Else) > 0 {
+		s.Condition, s.Then, s.Else = negate(s.Condition), s.Else, nil
+	}
+}
+
+// simplifyLoop returns the while or repeat loop equivalent to s, if it
+// is a while true loop left by its first or last statement.
+func simplifyLoop(s *ast.WhileStmt) ast.Stmt {
+	if _, ok := s.Condition.(*ast.TrueExpr); !ok || len(s.Chunk) == 0 {
+		return s
+	}
+	if cond, ok := breakIf(s.Chunk[0]); ok {
+		s.Condition, s.Chunk = negate(cond), s.Chunk[1:]
+		return s
+	}
+	n := len(s.Chunk)
+	if cond, ok := breakIf(s.Chunk[n-1]); ok && !continues(s.Chunk[:n-1]) {
+		return &ast.RepeatStmt{Condition: cond, Chunk: s.Chunk[:n-1]}
+	}
+	return s
+}
+
+// breakIf returns the condition of an if statement that only breaks.
+func breakIf(stmt ast.Stmt) (ast.Expr, bool) {
+	if s, ok := stmt.(*ast.IfStmt); ok && len(s.Then) == 1 && len(s.Else) == 0 {
+		if _, ok := s.Then[0].(*ast.BreakStmt); ok {
+			return s.Condition, true
+		}
+	}
+	return nil, false
+}
+
+// continues reports whether chunk continues the loop it is the body of.
+func continues(chunk ast.Chunk) bool {
+	found := false
+	for _, stmt := range chunk {
+		ast.Inspect(stmt, func(node interface{}) bool {
+			switch node.(type) {
+			case *ast.ContinueStmt:
+				found = true
+			case *ast.WhileStmt, *ast.RepeatStmt, *ast.NumberForStmt, *ast.GenericForStmt, *ast.FunctionExpr:
+				return false
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// jumps reports whether chunk ends in a jump.
+func jumps(chunk ast.Chunk) bool {
+	if n := len(chunk); n > 0 {
+		switch chunk[n-1].(type) {
+		case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt, *ast.GotoStmt:
+			return true
+		}
+	}
+	return false
+}
+
+// negate returns the negation of the condition ex.
+func negate(ex ast.Expr) ast.Expr {
+	switch ex := ex.(type) {
+	case *ast.UnaryOpExpr:
+		if ex.Operator == "not " {
+			return ex.Expr
+		}
+	case *ast.RelationalOpExpr:
+		switch ex.Operator {
+		case "==":
+			return &ast.RelationalOpExpr{Operator: "~=", Lhs: ex.Lhs, Rhs: ex.Rhs}
+		case "~=":
+			return &ast.RelationalOpExpr{Operator: "==", Lhs: ex.Lhs, Rhs: ex.Rhs}
+		}
+	case *ast.TrueExpr:
+		return &ast.FalseExpr{}
+	case *ast.FalseExpr:
+		return &ast.TrueExpr{}
+	}
+	return &ast.UnaryOpExpr{Operator: "not ", Expr: ex}
+}
+
+// isNil reports whether exprs are all nil.
+func isNil(exprs []ast.Expr) bool {
+	for _, ex := range exprs {
+		if _, ok := ex.(*ast.NilExpr); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// assignment returns the assignment of exprs to the variables names,
+// which assigns nil if there are no exprs.
+func assignment(names []string, exprs []ast.Expr) ast.Stmt {
+	if len(exprs) == 0 {
+		exprs = []ast.Expr{&ast.NilExpr{}}
+	}
+	return &ast.AssignStmt{Lhs: idents(names), Rhs: exprs}
+}
+
+func idents(names []string) []ast.Expr {
+	exprs := make([]ast.Expr, len(names))
+	for i, name := range names {
+		exprs[i] = &ast.IdentExpr{Value: name}
+	}
+	return exprs
+}
+
+// operands returns the values instr uses.
+func operands(instr Instruction) []Value {
+	var values []Value
+	for _, rand := range instr.Operands(nil) {
+		values = append(values, *rand)
+	}
+	return values
+}
+
+// expr returns the expression of v as an operand.
+func (d *decompiler) expr(v Value) ast.Expr {
 	switch v := v.(type) {
 	case Nil:
 		return &ast.NilExpr{}
@@ -17,109 +1375,246 @@ func expr(v Value) ast.Expr {
 	case VarArg:
 		return &ast.Comma3Expr{}
 	case Number:
-		return &ast.NumberExpr{Value: v.Value}
+		if math.Signbit(v.Value) {
+			return &ast.UnaryOpExpr{Operator: "-", Expr: &ast.NumberExpr{Value: -v.Value, Float: v.Float}}
+		}
+		return &ast.NumberExpr{Value: v.Value, Float: v.Float}
 	case String:
 		return &ast.StringExpr{Value: v.Value}
 	case *Local:
+		return &ast.IdentExpr{Value: d.localName(v)}
+	case *Global:
 		return &ast.IdentExpr{Value: v.Comment}
-	case AttrGet:
-		return &ast.AttrGetExpr{
-			Object: expr(v.Object),
-			Key:    expr(v.Key),
-		}
-	case Table:
-		panic("implement")
-	case Arithmetic:
+	case *Function:
+		nested := newDecompiler(v, d)
+		ex := &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: v.VarArg}}
+		for _, p := range v.Params {
+			ex.ParList.Names = append(ex.ParList.Names, nested.localName(p))
+		}
+		ex.Chunk = nested.function()
+		return ex
+	}
+	if name, ok := d.regs[v]; ok {
+		return &ast.IdentExpr{Value: name}
+	}
+	if d.inline[v] {
+		return d.compute(v)
+	}
+	d.errorf("%s is used before it is computed", relName(v))
+	return nil
+}
+
+// compute returns the expression computing the register v.
+func (d *decompiler) compute(v Value) ast.Expr {
+	switch v := v.(type) {
+	case *Index:
+		return d.field(v.Object, v.Key)
+	case *NewTable:
+		ex := &ast.TableExpr{Fields: make([]*ast.Field, len(v.Fields))}
+		for i, field := range v.Fields {
+			ex.Fields[i] = &ast.Field{}
+			if field.Key != nil {
+				ex.Fields[i].Key = d.expr(field.Key)
+			}
+			ex.Fields[i].Value = d.expr(field.Value)
+		}
+		if n := len(ex.Fields); n > 0 && !v.Spread {
+			if c, ok := ex.Fields[n-1].Value.(*ast.FuncCallExpr); ok {
+				c.AdjustRet = true
+			}
+		}
+		return ex
+	case *Call:
+		return d.call(v)
+	case *Arithmetic:
 		return &ast.ArithmeticOpExpr{
 			Operator: v.Op,
 
-			Lhs: expr(v.Lhs),
-			Rhs: expr(v.Rhs),
+			Lhs: d.expr(v.Lhs),
+			Rhs: d.expr(v.Rhs),
 		}
-	case Concat:
+	case *Concat:
 		return &ast.StringConcatOpExpr{
-			Lhs: expr(v.Lhs),
-			Rhs: expr(v.Rhs),
+			Lhs: d.expr(v.Lhs),
+			Rhs: d.expr(v.Rhs),
 		}
-	case Relation:
+	case *Relation:
 		return &ast.RelationalOpExpr{
 			Operator: v.Op,
 
-			Lhs: expr(v.Lhs),
-			Rhs: expr(v.Rhs),
+			Lhs: d.expr(v.Lhs),
+			Rhs: d.expr(v.Rhs),
 		}
-	case Logic:
+	case *Logic:
 		return &ast.LogicalOpExpr{
 			Operator: v.Op,
 
-			Lhs: expr(v.Lhs),
-			Rhs: expr(v.Rhs),
+			Lhs: d.expr(v.Lhs),
+			Rhs: d.expr(v.Rhs),
 		}
-	case Unary:
+	case *Unary:
 		return &ast.UnaryOpExpr{
 			Operator: v.Op,
-			Expr:     expr(v.Value),
+			Expr:     d.expr(v.Value),
 		}
-	default:
-		panic("unimplemented" + fmt.Sprint(v))
 	}
+	d.errorf("cannot decompile %s", relName(v))
+	return nil
 }
 
-func (b *BasicBlock) ToAst(dom domFrontier) (chunk ast.Chunk) {
-	for _, inst := range b.Instrs {
-		switch i := inst.(type) {
-		case *Assign:
-			if l, ok := i.Lhs.(*Local); ok && !l.declared {
-				chunk = append(chunk, &ast.LocalAssignStmt{
-					Names: []string{l.Comment},
-					Exprs: []ast.Expr{expr(i.Rhs)},
-				})
-				l.declared = true
-			} else {
-				chunk = append(chunk, &ast.AssignStmt{
-					Lhs: []ast.Expr{expr(i.Lhs)},
-					Rhs: []ast.Expr{expr(i.Rhs)},
-				})
-			}
-		case *If:
-			tFront := dom[b.Succs[0].Index]
-			fFront := dom[b.Succs[1].Index]
-			if len(tFront) == len(fFront) &&
-				tFront[0].Index == fFront[0].Index {
-				panic("if then end")
-			} else {
-				panic("if then else end")
-			}
-		default:
-			panic("reached")
+// field returns object[key], or the name of a global for a field of the
+// environment of the chunk that no local hides.
+func (d *decompiler) field(object, key Value) ast.Expr {
+	if _, ok := object.(*Global); ok {
+		if key, ok := key.(String); ok && isName(key.Value) && !d.isLocalName(key.Value) {
+			return &ast.IdentExpr{Value: key.Value}
 		}
 	}
+	return &ast.AttrGetExpr{
+		Object: d.expr(object),
+		Key:    d.expr(key),
+	}
+}
 
-	return
+var keywords = map[string]bool{
+	"and": true, "break": true, "continue": true, "do": true, "else": true,
+	"elseif": true, "end": true, "false": true, "for": true, "function": true,
+	"goto": true, "if": true, "in": true, "local": true, "nil": true,
+	"not": true, "or": true, "repeat": true, "return": true, "then": true,
+	"true": true, "until": true, "while": true,
 }
 
-func (f *Function) Chunk() (chunk ast.Chunk) {
-	root := f.Blocks[0]
-	return root.ToAst()
+// isName reports whether s is a lua name, which can name a global.
+func isName(s string) bool {
+	if s == "" || keywords[s] || '0' <= s[0] && s[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch != '_' && !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9') {
+			return false
+		}
+	}
+	return true
 }
 
-/*
-if cond then
-	...
-end
+func (d *decompiler) call(v *Call) *ast.FuncCallExpr {
+	ex := &ast.FuncCallExpr{}
+	if v.Func != nil {
+		ex.Func = d.expr(v.Func)
+	} else {
+		ex.Receiver = d.expr(v.Recv)
+		ex.Method = v.Method
+	}
+	ex.Args = d.exprs(v.Args, v.Spread)
+	return ex
+}
 
-if cond then
-	...
-else
-	...
-end
+// exprs returns the expressions of values, whose last is truncated to
+// one value unless spread.
+func (d *decompiler) exprs(values []Value, spread bool) []ast.Expr {
+	exprs := make([]ast.Expr, len(values))
+	for i, v := range values {
+		exprs[i] = d.expr(v)
+	}
+	if n := len(exprs); n > 0 && !spread {
+		if c, ok := exprs[n-1].(*ast.FuncCallExpr); ok {
+			c.AdjustRet = true
+		}
+	}
+	return exprs
+}
 
-while cond do
-	...
-end
+// postDominators returns the immediate post-dominator of each block of
+// fn by Block.Index, or nil for the blocks only post-dominated by the
+// end of the function, and those never reaching it.
+func postDominators(fn *Function) []*BasicBlock {
+	// The dominators of the reverse CFG, where the node n is a virtual
+	// exit following the blocks without successors.
+	n := len(fn.Blocks)
+	preds := func(i int) []int { // in the reverse CFG
+		var nodes []int
+		if i == n {
+			for _, b := range fn.Blocks {
+				if len(b.Succs) == 0 {
+					nodes = append(nodes, b.Index)
+				}
+			}
+		} else {
+			for _, p := range fn.Blocks[i].Preds {
+				nodes = append(nodes, p.Index)
+			}
+		}
+		return nodes
+	}
+	succs := func(i int) []int {
+		if len(fn.Blocks[i].Succs) == 0 {
+			return []int{n}
+		}
+		var nodes []int
+		for _, s := range fn.Blocks[i].Succs {
+			nodes = append(nodes, s.Index)
+		}
+		return nodes
+	}
 
-repeat
-	...
-until cond end
+	num := make([]int, n+1) // postorder numbers, from 1
+	var order []int
+	var visit func(i int)
+	visit = func(i int) {
+		num[i] = -1
+		for _, p := range preds(i) {
+			if num[p] == 0 {
+				visit(p)
+			}
+		}
+		order = append(order, i)
+		num[i] = len(order)
+	}
+	visit(n)
 
-*/
+	idom := make([]int, n+1)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[n] = n
+	intersect := func(a, b int) int {
+		for a != b {
+			for num[a] < num[b] {
+				a = idom[a]
+			}
+			for num[b] < num[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for k := len(order) - 2; k >= 0; k-- {
+			i := order[k]
+			dom := -1
+			for _, s := range succs(i) {
+				switch {
+				case idom[s] < 0:
+				case dom < 0:
+					dom = s
+				default:
+					dom = intersect(s, dom)
+				}
+			}
+			if idom[i] != dom {
+				idom[i] = dom
+				changed = true
+			}
+		}
+	}
+
+	ipdom := make([]*BasicBlock, n)
+	for i := range ipdom {
+		if idom[i] >= 0 && idom[i] != n {
+			ipdom[i] = fn.Blocks[idom[i]]
+		}
+	}
+	return ipdom
+}
@@ -1,7 +1,11 @@
 package ssa
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
 )
 
 func TestToAst(t *testing.T) {
@@ -12,6 +16,186 @@ func TestToAst(t *testing.T) {
 	fn := build(input, t)
 	t.Error(fn.String())
 
-	chunk := fn.Chunk()
-	t.Error("\n" + chunk.String())
-}
\ No newline at end of file
+	t.Error("\n" + decompile(fn, t))
+}
+// decompile returns the lua code of fn.
+func decompile(fn *Function, t *testing.T) string {
+	chunk, err := fn.Chunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunk.String()
+}
+
+func TestToAstReturn(t *testing.T) {
+	for _, test := range []struct{ input, want string }{
+		{"return 1, f()", "return 1, f();\n"},
+		{"return (f())", "return (f());\n"},
+		{"return ...", "return ...;\n"},
+		{"local a = 1\nreturn a", "local a = 1;\nreturn a;\n"},
+	} {
+		fn := build(test.input, t)
+		if got := decompile(fn, t); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestToAstControl(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		mode  BuilderMode
+		want  string
+	}{
+		{
+			"if a > 0 then print(1) elseif a < 0 then print(2) else print(3) end", 0,
+			"if a > 0 then\n\tprint(1);\nelseif a < 0 then\n\tprint(2);\nelse\n\tprint(3);\nend;\n",
+		},
+		{
+			"if a and b or c then print(1) end", LowerLogic,
+			"if a and b or c then\n\tprint(1);\nend;\n",
+		},
+		{
+			"local i = 0\nwhile i < 10 do i = i + 1 end\nreturn i", 0,
+			"local i = 0;\nwhile i < 10 do\n\ti = i + 1;\nend;\nreturn i;\n",
+		},
+		{
+			"local i = 0\nrepeat i = i + 1 until i > 10\nreturn i", LowerLogic,
+			"local i = 0;\nrepeat\n\ti = i + 1;\nuntil i > 10;\nreturn i;\n",
+		},
+		{
+			"for i = 1, 10 do if i == 5 then break end print(i) end", 0,
+			"for i = 1, 10 do\n\tif i == 5 then\n\t\tbreak;\n\tend;\n\tprint(i);\nend;\n",
+		},
+		{
+			"for i = 1, 3 do for j = 1, 3 do if j == 2 then continue end print(i, j) end end", 0,
+			"for i = 1, 3 do\n\tfor j = 1, 3 do\n\t\tif j == 2 then\n\t\t\tcontinue;\n\t\tend;\n\t\tprint(i, j);\n\tend;\nend;\n",
+		},
+		{
+			"for k, v in pairs(t) do print(k, v) end", 0,
+			"for k, v in pairs(t) do\n\tprint(k, v);\nend;\n",
+		},
+		{
+			// Loops without back edges.
+			"for i = 1, 3 do break end\nprint(1)", 0,
+			"for i = 1, 3 do\n\tbreak;\nend;\nprint(1);\n",
+		},
+		{
+			"for k, v in pairs(t) do return k end", 0,
+			"for k, v in pairs(t) do\n\treturn k;\nend;\n",
+		},
+		{
+			"local s = 0\nfor i = 1, 10 do s = s + i end\nreturn s", LowerLoops,
+			"local s = 0;\nlocal i = 1;\nwhile i <= 10 do\n\ts = s + i;\n\ti = i + 1;\nend;\nreturn s;\n",
+		},
+		{
+			"local n = 0\nfor i = 1, 3 do for j = 1, 3 do if j == i then goto next end n = n + 1 end ::next:: end", 0,
+			"local n = 0;\nfor i = 1, 3 do\n\tfor j = 1, 3 do\n\t\tif j == i then\n\t\t\tbreak;\n\t\tend;\n\t\tn = n + 1;\n\tend;\nend;\n",
+		},
+		{
+			"local x = nil\nfor i = 1, 3 do for j = 1, 3 do if j == i then goto done end x = j end end ::done:: print(x)", 0,
+			"local x;\nx = nil;\nfor i = 1, 3 do\n\tfor j = 1, 3 do\n\t\tif j == i then\n\t\t\tgoto L1;\n\t\tend;\n\t\tx = j;\n\tend;\nend;\n::L1::;\nprint(x);\n",
+		},
+		{
+			"for i = 1, 2 do if i then goto a end end ::a:: local x = nil print(x)", 0,
+			"for i = 1, 2 do\n\tif i then\n\t\tbreak;\n\tend;\nend;\nlocal x;\nprint(x);\n",
+		},
+		{
+			// The block reached from both arms follows the if.
+			"if b then goto c end\nif d then goto e end\n::c:: f()\n::e:: g()", 0,
+			"if b then\n\tgoto L1;\nend;\nif not d then\n\tgoto L1;\nend;\ngoto L2;\n::L1::;\nf();\n::L2::;\ng();\n",
+		},
+		{
+			"local x = 0\nlocal function inc() x = x + 1 return x end\ninc()", 0,
+			"local x = 0;\nlocal function inc()\n\tx = x + 1;\n\treturn x;\nend;\ninc();\n",
+		},
+		{
+			"local a, b = f()\nlocal print = print\nprint(a, b)", 0,
+			"local a, b = f();\nlocal print = _ENV.print;\nprint(a, b);\n",
+		},
+		{
+			// The local _ENV does not hide the environment of the chunk.
+			"do local _ENV = {print = print} w = 2 print(w) end", 0,
+			"local _ENV_1 = {\n\tprint = print\n};\n_ENV_1.w = 2;\n_ENV_1.print(_ENV_1.w);\n",
+		},
+		{
+			"local function f() local _ENV = {x = 1} return function() return x end end\nreturn f", 0,
+			"local function f()\n\tlocal _ENV = {\n\t\tx = 1\n\t};\n\treturn function()\n\t\treturn _ENV.x;\n\tend;\nend;\nreturn f;\n",
+		},
+		{
+			// Each iteration has its own c for its closure.
+			"local fs, j = {}, 1\nwhile j < 3 do local c = j fs[j] = function() return c end j = j + 1 end\nreturn fs", 0,
+			"local fs = {};\nlocal j = 1;\nwhile j < 3 do\n\tlocal c = j;\n\tfs[j] = function()\n\t\treturn c;\n\tend;\n\tj = j + 1;\nend;\nreturn fs;\n",
+		},
+		{
+			"local n = 0\nwhile x do local c = n f = function() return c end if g() then goto a end if h() then goto b end ::a:: c = 3 ::b:: print(c) n = n + 1 end", 0,
+			"local n;\nn = 0;\nwhile x do\n\tlocal c = n;\n\tf = function()\n\t\treturn c;\n\tend;\n\tif g() then\n\t\tgoto L4;\n\tend;\n\tif not h() then\n\t\tgoto L4;\n\tend;\n\tgoto L5;\n\t::L4::;\n\tc = 3;\n\t::L5::;\n\tprint(c);\n\tn = n + 1;\nend;\n",
+		},
+		{
+			"local fs = {}\nfor i = 1, 3 do fs[i] = function() return i end end\nreturn fs", LowerLoops,
+			"local fs = {};\nlocal t3 = 1;\nwhile t3 <= 3 do\n\tlocal i = t3;\n\tfs[i] = function()\n\t\treturn i;\n\tend;\n\tt3 = t3 + 1;\nend;\nreturn fs;\n",
+		},
+	} {
+		chunk, err := parse.Parse(strings.NewReader(test.input), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := BuildWith(chunk, test.mode|SanityCheckFunctions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := decompile(fn, t)
+		if got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.input, got, test.want)
+			continue
+		}
+		// The decompiled chunk builds again.
+		chunk, err = parse.Parse(strings.NewReader(got), "")
+		if err != nil {
+			t.Errorf("%q: %v", test.input, err)
+			continue
+		}
+		if _, err := BuildWith(chunk, test.mode|SanityCheckFunctions); err != nil {
+			t.Errorf("%q: %v", test.input, err)
+		}
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	for _, test := range []struct{ input, want string }{
+		{"do local c = 1 print(c) end", "do\n\tlocal c = 1;\n\tprint(c);\nend;\n"},
+		{"do local c = 1 end print(c)", "local c;\ndo\n\tc = 1;\nend;\nprint(c);\n"},
+		{"do local c ::l:: print(c) end", "do\n\tlocal c;\n\t::l::;\n\tprint(c);\nend;\n"},
+		{"do goto l local c ::l:: print(c) end", "do\n\tlocal c;\n\tgoto l;\n\tc = nil;\n\t::l::;\n\tprint(c);\nend;\n"},
+		{"do goto l local c = 1 ::l:: end print(c)", "local c;\ndo\n\tgoto l;\n\tc = 1;\n\t::l::;\nend;\nprint(c);\n"},
+		{"do goto l local c = 1 print(c) ::l:: end", "do\n\tgoto l;\n\tlocal c = 1;\n\tprint(c);\n\t::l::;\nend;\n"},
+		{"repeat local c = 1 ::l:: until c", "repeat\n\tlocal c = 1;\n\t::l::;\nuntil c;\n"},
+		{"repeat goto l local c = 1 ::l:: until c", "repeat\n\tlocal c;\n\tgoto l;\n\tc = 1;\n\t::l::;\nuntil c;\n"},
+	} {
+		chunk, err := parse.Parse(strings.NewReader(test.input), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The local statement of the nested block is declared there.
+		d := &decompiler{scoped: make(map[ast.Stmt]bool)}
+		var block ast.Chunk
+		switch s := chunk[0].(type) {
+		case *ast.DoBlockStmt:
+			block = s.Chunk
+		case *ast.RepeatStmt:
+			block = s.Chunk
+		}
+		for _, stmt := range block {
+			if _, ok := stmt.(*ast.LocalAssignStmt); ok {
+				d.scoped[stmt] = true
+			}
+		}
+		chunk = d.checkScopes(chunk, chunk, nil)
+		if len(d.hoisted) > 0 {
+			chunk = append(ast.Chunk{&ast.LocalAssignStmt{Names: d.hoisted}}, chunk...)
+		}
+		if got := chunk.String(); got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package luautil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnquoteError reports an invalid lua string literal. The position is
+// relative to the start of the literal.
+type UnquoteError struct {
+	Offset int // byte offset, counting from 0
+	Line   int // line, counting from 1
+	Column int // column in bytes, counting from 1
+	Msg    string
+}
+
+func (e *UnquoteError) Error() string {
+	return fmt.Sprintf("line:%d(column:%d): %s", e.Line, e.Column, e.Msg)
+}
+
+func unquoteError(lit string, offset int, msg string) *UnquoteError {
+	e := &UnquoteError{Offset: offset, Line: 1, Column: 1, Msg: msg}
+	for i := 0; i < offset && i < len(lit); i++ {
+		if lit[i] == '\n' || lit[i] == '\r' {
+			i = skipNewline(lit, i) - 1
+			e.Line++
+			e.Column = 1
+			continue
+		}
+		e.Column++
+	}
+	return e
+}
+
+// Unquote interprets lit as a single, double or long bracket quoted lua
+// string literal and returns the string it represents. The lexer decodes
+// string literals with it. The returned error is an *UnquoteError.
+func Unquote(lit string) (string, error) {
+	if len(lit) == 0 {
+		return "", unquoteError(lit, 0, "string literal expected")
+	}
+	switch lit[0] {
+	case '"', '\'':
+		return unquoteString(lit)
+	case '[':
+		return unquoteLong(lit)
+	}
+	return "", unquoteError(lit, 0, "string literal expected")
+}
+
+func unquoteString(lit string) (string, error) {
+	b := &strings.Builder{}
+	b.Grow(len(lit))
+	quote := lit[0]
+	for i := 1; ; {
+		if i >= len(lit) {
+			return "", unquoteError(lit, i, "unterminated string")
+		}
+		switch ch := lit[i]; ch {
+		case quote:
+			if i+1 != len(lit) {
+				return "", unquoteError(lit, i+1, "unexpected characters after string")
+			}
+			return b.String(), nil
+		case '\n', '\r':
+			return "", unquoteError(lit, i, "unterminated string")
+		case '\\':
+			var err error
+			if i, err = unescape(lit, i+1, b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+}
+
+// unescape decodes the escape sequence following the backslash at lit[i-1]
+// and returns the offset of the first byte after it.
+func unescape(lit string, i int, b *strings.Builder) (int, error) {
+	if i >= len(lit) {
+		return i, unquoteError(lit, i, "unterminated string")
+	}
+	switch ch := lit[i]; ch {
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '\\', '"', '\'':
+		b.WriteByte(ch)
+	case '\n', '\r':
+		b.WriteByte('\n')
+		return skipNewline(lit, i), nil
+	case 'z':
+		for i++; i < len(lit) && isSpace(lit[i]); i++ {
+		}
+		return i, nil
+	case 'x':
+		var val byte
+		for j := i + 1; j < i+3; j++ {
+			if j >= len(lit) || !isHex(lit[j]) {
+				return j, unquoteError(lit, j, "hex digit expected")
+			}
+			val = val<<4 | hexValue(lit[j])
+		}
+		b.WriteByte(val)
+		return i + 3, nil
+	case 'u':
+		if i+1 >= len(lit) || lit[i+1] != '{' {
+			return i + 1, unquoteError(lit, i+1, "{ expected")
+		}
+		var val uint64
+		j := i + 2
+		for ; j < len(lit) && isHex(lit[j]); j++ {
+			val = val<<4 | uint64(hexValue(lit[j]))
+			if val > 0x7FFFFFFF {
+				return j, unquoteError(lit, j, "UTF-8 value too large")
+			}
+		}
+		if j == i+2 {
+			return j, unquoteError(lit, j, "hex digit expected")
+		}
+		if j >= len(lit) || lit[j] != '}' {
+			return j, unquoteError(lit, j, "} expected")
+		}
+		b.Write(utf8esc(uint32(val)))
+		return j + 1, nil
+	default:
+		if !isDecimal(ch) {
+			return i, unquoteError(lit, i, "invalid escape sequence")
+		}
+		val, j := 0, i
+		for ; j < i+3 && j < len(lit) && isDecimal(lit[j]); j++ {
+			val = val*10 + int(lit[j]-'0')
+		}
+		if val > 255 {
+			return i, unquoteError(lit, i, "decimal escape too large")
+		}
+		b.WriteByte(byte(val))
+		return j, nil
+	}
+	return i + 1, nil
+}
+
+func unquoteLong(lit string) (string, error) {
+	level, i := 0, 1
+	for ; i < len(lit) && lit[i] == '='; i++ {
+		level++
+	}
+	if i >= len(lit) || lit[i] != '[' {
+		return "", unquoteError(lit, i, "invalid multiline string")
+	}
+	i++
+	if i < len(lit) && (lit[i] == '\n' || lit[i] == '\r') { // the first newline is skipped
+		i = skipNewline(lit, i)
+	}
+
+	b := &strings.Builder{}
+	b.Grow(len(lit))
+	for {
+		if i >= len(lit) {
+			return "", unquoteError(lit, i, "unterminated multiline string")
+		}
+		switch ch := lit[i]; ch {
+		case ']':
+			j, n := i+1, 0
+			for ; j < len(lit) && lit[j] == '='; j++ {
+				n++
+			}
+			if n == level && j < len(lit) && lit[j] == ']' {
+				if j+1 != len(lit) {
+					return "", unquoteError(lit, j+1, "unexpected characters after string")
+				}
+				return b.String(), nil
+			}
+			b.WriteString(lit[i:j])
+			i = j
+		case '\n', '\r':
+			b.WriteByte('\n')
+			i = skipNewline(lit, i)
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+}
+
+// skipNewline returns the offset after the newline at lit[i], treating
+// \r\n and \n\r as a single newline.
+func skipNewline(lit string, i int) int {
+	if i+1 < len(lit) && (lit[i+1] == '\n' || lit[i+1] == '\r') && lit[i+1] != lit[i] {
+		return i + 2
+	}
+	return i + 1
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\v' || ch == '\f' || ch == '\r'
+}
+
+func isDecimal(ch byte) bool { return '0' <= ch && ch <= '9' }
+
+func isHex(ch byte) bool {
+	return '0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func hexValue(ch byte) byte {
+	switch {
+	case ch >= 'a':
+		return ch - 'a' + 10
+	case ch >= 'A':
+		return ch - 'A' + 10
+	}
+	return ch - '0'
+}
+
+// utf8esc encodes x like lua does for \u{XXX} escapes, which allows values
+// up to 2^31 using the original six byte UTF-8 scheme.
+func utf8esc(x uint32) []byte {
+	if x < 0x80 {
+		return []byte{byte(x)}
+	}
+	var buf [6]byte
+	n := len(buf)
+	mfb := uint32(0x3f) // maximum that fits in the first byte
+	for {
+		n--
+		buf[n] = byte(0x80 | x&0x3f)
+		x >>= 6
+		mfb >>= 1
+		if x <= mfb {
+			break
+		}
+	}
+	n--
+	buf[n] = byte(^mfb<<1 | x)
+	return buf[n:]
+}
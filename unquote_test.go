@@ -0,0 +1,145 @@
+package luautil_test
+
+import (
+	"strings"
+	"testing"
+
+	luautil "github.com/hootrhino/beautiful-lua-go"
+	"github.com/hootrhino/beautiful-lua-go/ast"
+	"github.com/hootrhino/beautiful-lua-go/parse"
+)
+
+var literals = []struct {
+	lit      string
+	expected string
+}{
+	{`""`, ""},
+	{`''`, ""},
+	{`"hello"`, "hello"},
+	{`'say "hi"'`, `say "hi"`},
+	{`"\a\b\f\n\r\t\v\\\"\'"`, "\a\b\f\n\r\t\v\\\"'"},
+	{`"\0\65\0655\255"`, "\x00A\x415\xff"},
+	{`"\x00\x41\xff\xFf"`, "\x00A\xff\xff"},
+	{`"\u{41}\u{e9}\u{20AC}\u{10FFFF}"`, "Aé€\U0010FFFF"},
+	{`"\u{7FFFFFFF}"`, "\xfd\xbf\xbf\xbf\xbf\xbf"},
+	{"\"a\\z  \n\t b\"", "ab"},
+	{"\"\\z\v\fb\"", "b"},
+	{"\"a\\\nb\"", "a\nb"},
+	{"\"a\\\r\nb\"", "a\nb"},
+	{"[[]]", ""},
+	{"[[\nfirst newline]]", "first newline"},
+	{"[[\r\nfirst newline]]", "first newline"},
+	{"[[a\r\nb\n\rc]]", "a\nb\nc"},
+	{"[==[a]]b]=]c]==]", "a]]b]=]c"},
+	{`[=[\n is not an escape]=]`, `\n is not an escape`},
+}
+
+func TestUnquote(t *testing.T) {
+	for _, test := range literals {
+		result, err := luautil.Unquote(test.lit)
+		if err != nil {
+			t.Errorf("%s: %v", test.lit, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%s: Expected %q, got %q", test.lit, test.expected, result)
+		}
+	}
+}
+
+// TestUnquoteLexer checks that Unquote decodes literals like the lexer does.
+func TestUnquoteLexer(t *testing.T) {
+	for _, test := range literals {
+		chunk, err := parse.Parse(strings.NewReader("_ = "+test.lit), "")
+		if err != nil {
+			t.Errorf("%s: %v", test.lit, err)
+			continue
+		}
+		lexed := chunk[0].(*ast.AssignStmt).Rhs[0].(*ast.StringExpr).Value
+		result, _ := luautil.Unquote(test.lit)
+		if result != lexed {
+			t.Errorf("%s: lexer got %q, Unquote got %q", test.lit, lexed, result)
+		}
+	}
+}
+
+func TestUnquoteQuote(t *testing.T) {
+	var b []byte
+	for i := 0; i < 256; i++ {
+		b = append(b, byte(i))
+	}
+	for _, s := range []string{string(b), "héllo wörld", "\xff\xfe invalid"} {
+		result, err := luautil.Unquote(luautil.Quote(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != s {
+			t.Errorf("Expected %q, got %q", s, result)
+		}
+	}
+}
+
+func TestUnquoteErrors(t *testing.T) {
+	tests := []struct {
+		lit          string
+		msg          string
+		line, column int
+	}{
+		{``, "string literal expected", 1, 1},
+		{`abc`, "string literal expected", 1, 1},
+		{`"abc`, "unterminated string", 1, 5},
+		{`"abc'`, "unterminated string", 1, 6},
+		{"\"ab\nc\"", "unterminated string", 1, 4},
+		{`"a"b`, "unexpected characters after string", 1, 4},
+		{`"\q"`, "invalid escape sequence", 1, 3},
+		{`"\256"`, "decimal escape too large", 1, 3},
+		{`"\xg0"`, "hex digit expected", 1, 4},
+		{`"\x0"`, "hex digit expected", 1, 5},
+		{`"\u41"`, "{ expected", 1, 4},
+		{`"\u{}"`, "hex digit expected", 1, 5},
+		{`"\u{41"`, "} expected", 1, 7},
+		{`"\u{80000000}"`, "UTF-8 value too large", 1, 12},
+		{"\"a\\\n\\q\"", "invalid escape sequence", 2, 2},
+		{"[=[abc]]", "unterminated multiline string", 1, 9},
+		{"[=abc]=]", "invalid multiline string", 1, 3},
+		{"[[a\r\nb]]c", "unexpected characters after string", 2, 4},
+	}
+
+	for _, test := range tests {
+		_, err := luautil.Unquote(test.lit)
+		e, ok := err.(*luautil.UnquoteError)
+		if !ok {
+			t.Errorf("%q: Expected *UnquoteError, got %v", test.lit, err)
+			continue
+		}
+		if e.Msg != test.msg || e.Line != test.line || e.Column != test.column {
+			t.Errorf("%q: Expected %s at %d:%d, got %s at %d:%d", test.lit, test.msg, test.line, test.column, e.Msg, e.Line, e.Column)
+		}
+	}
+}
+
+// TestUnquoteLexerErrors checks that the lexer reports the errors of
+// Unquote where they are in the source.
+func TestUnquoteLexerErrors(t *testing.T) {
+	tests := []struct {
+		src          string
+		msg          string
+		line, column int
+	}{
+		{`_ = "\q"`, "invalid escape sequence", 1, 7},
+		{`_ = 1 _ = "ab\xg0"`, "hex digit expected", 1, 16},
+		{"_ = 1\n_ = 'a\\\n\\256'", "decimal escape too large", 3, 2},
+		{"_ = 'a\\z \n\t \\u{}'", "hex digit expected", 2, 6},
+	}
+	for _, test := range tests {
+		_, err := parse.Parse(strings.NewReader(test.src), "")
+		e, ok := err.(*parse.Error)
+		if !ok {
+			t.Errorf("%q: Expected *parse.Error, got %v", test.src, err)
+			continue
+		}
+		if e.Message != test.msg || e.Pos.Line != test.line || e.Pos.Column != test.column {
+			t.Errorf("%q: Expected %s at %d:%d, got %s at %d:%d", test.src, test.msg, test.line, test.column, e.Message, e.Pos.Line, e.Pos.Column)
+		}
+	}
+}